@@ -0,0 +1,181 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/nats-io/prometheus-nats-exporter/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// alertRule is a Prometheus alerting rule.
+type alertRule struct {
+	name        string
+	expr        string
+	duration    string
+	severity    string
+	summary     string
+	description string
+}
+
+// upMetric returns the name of the metric reporting whether the last poll
+// of a server's endpoint succeeded.
+func upMetric(system, endpoint, prefix string) string {
+	if prefix != "" {
+		system = prefix
+	}
+	return prometheus.BuildFQName(system, endpoint, "up")
+}
+
+// alertRules returns the alerting rules on the metrics of the collectors
+// enabled, named after the prefix and renames configured.
+func alertRules(opts *exporter.NATSExporterOptions) []alertRule {
+	var rules []alertRule
+	down := func(name, kind, metric string) {
+		rules = append(rules, alertRule{
+			name:        name,
+			expr:        metric + " == 0",
+			duration:    "5m",
+			severity:    "critical",
+			summary:     kind + " {{ $labels.server_id }} is down",
+			description: "The exporter has failed to poll " + kind + " {{ $labels.server_id }} for 5 minutes.",
+		})
+	}
+
+	// A server is down when the first core endpoint polled fails.
+	for _, ep := range []struct {
+		enabled  bool
+		endpoint string
+	}{
+		{opts.GetVarz, "varz"},
+		{opts.GetConnz, "connz"},
+		{opts.GetSubz, "subsz"},
+		{opts.GetRoutez, "routez"},
+		{opts.GetGatewayz, "gatewayz"},
+		{opts.GetJsz, "jsz"},
+		{opts.GetIpqueuesz, "ipqueuesz"},
+	} {
+		if ep.enabled {
+			down("NATSServerDown", "NATS server", upMetric(collector.CoreSystem, ep.endpoint, opts.Prefix))
+			break
+		}
+	}
+	if opts.GetStreamingServerz {
+		down("NATSStreamingServerDown", "NATS Streaming server", upMetric(collector.StreamingSystem, "serverz", opts.Prefix))
+	} else if opts.GetStreamingChannelz {
+		down("NATSStreamingServerDown", "NATS Streaming server", upMetric(collector.StreamingSystem, "channelsz", opts.Prefix))
+	}
+	if opts.GetReplicatorVarz {
+		down("NATSReplicatorDown", "NATS replicator", upMetric(collector.ReplicatorSystem, "varz", opts.Prefix))
+	}
+	if opts.GetStreamingServerz {
+		system := collector.StreamingSystem
+		if opts.Prefix != "" {
+			system = opts.Prefix
+		}
+		rules = append(rules, alertRule{
+			name:        "NATSStreamingFailover",
+			expr:        "changes(" + prometheus.BuildFQName(system, "server", "active") + "[10m]) > 0",
+			severity:    "warning",
+			summary:     "NATS Streaming server {{ $labels.server_id }} failed over",
+			description: "NATS Streaming server {{ $labels.server_id }} became active or standby in the last 10 minutes.",
+		})
+	}
+	if opts.GetReplicatorVarz {
+		system := collector.ReplicatorSystem
+		if opts.Prefix != "" {
+			system = opts.Prefix
+		}
+		rules = append(rules, alertRule{
+			name:        "NATSReplicatorConnectorFlapping",
+			expr:        "increase(" + prometheus.BuildFQName(system, "connector", "disconnects") + "[15m]) > 3",
+			severity:    "warning",
+			summary:     "Connector {{ $labels.name }} of NATS replicator {{ $labels.server_id }} is flapping",
+			description: "Connector {{ $labels.name }} of NATS replicator {{ $labels.server_id }} disconnected {{ $value }} times in 15 minutes.",
+		})
+	}
+
+	if opts.GetVarz {
+		metric := collector.MetricName(collector.CoreSystem, "varz", "slow_consumers", opts.Prefix, &opts.CollectorOptions)
+		rules = append(rules, alertRule{
+			name:        "NATSSlowConsumersIncreasing",
+			expr:        "increase(" + metric + "[5m]) > 0",
+			duration:    "5m",
+			severity:    "warning",
+			summary:     "Slow consumers on NATS server {{ $labels.server_id }}",
+			description: "NATS server {{ $labels.server_id }} has had new slow consumers for 5 minutes.",
+		})
+		rules = append(rules, alertRule{
+			name:        "NATSServerRestarted",
+			expr:        "increase(nats_server_restarts_total[15m]) > 0",
+			severity:    "warning",
+			summary:     "NATS server {{ $labels.url }} restarted",
+			description: "NATS server {{ $labels.url }} restarted {{ $value }} times in 15 minutes.",
+		})
+	}
+
+	var routes string
+	if opts.GetRoutez {
+		routes = collector.MetricName(collector.CoreSystem, "routez", "num_routes", opts.Prefix, &opts.CollectorOptions)
+	} else if opts.GetVarz {
+		routes = collector.MetricName(collector.CoreSystem, "varz", "routes", opts.Prefix, &opts.CollectorOptions)
+	}
+	if routes != "" {
+		rules = append(rules, alertRule{
+			name:        "NATSRouteFlapping",
+			expr:        "changes(" + routes + "[15m]) > 4",
+			severity:    "warning",
+			summary:     "Routes of NATS server {{ $labels.server_id }} are flapping",
+			description: "The number of routes of NATS server {{ $labels.server_id }} changed {{ $value }} times in 15 minutes.",
+		})
+	}
+	return rules
+}
+
+// writeAlertRules writes the rules as a Prometheus rule file.
+func writeAlertRules(out io.Writer, rules []alertRule) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "groups:\n- name: nats\n  rules:\n")
+	for _, r := range rules {
+		fmt.Fprintf(w, "  - alert: %s\n", r.name)
+		fmt.Fprintf(w, "    expr: %s\n", strconv.Quote(r.expr))
+		if r.duration != "" {
+			fmt.Fprintf(w, "    for: %s\n", r.duration)
+		}
+		fmt.Fprintf(w, "    labels:\n      severity: %s\n", r.severity)
+		fmt.Fprintf(w, "    annotations:\n")
+		fmt.Fprintf(w, "      summary: %s\n", strconv.Quote(r.summary))
+		fmt.Fprintf(w, "      description: %s\n", strconv.Quote(r.description))
+	}
+	return w.Flush()
+}
+
+// genAlerts writes the alerting rules of the exporter run with the flags
+// given.
+func genAlerts(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("gen-alerts", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	o, err := parseOptions(fs, args)
+	if err != nil {
+		return err
+	}
+	return writeAlertRules(w, alertRules(o.exporter))
+}
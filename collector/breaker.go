@@ -0,0 +1,58 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long a server is skipped once its
+// circuit opens.
+var DefaultBreakerCooldown = time.Minute
+
+// circuitBreaker stops polling a server that keeps failing.  Once the
+// cooldown has passed a single poll is let through; if it fails the
+// circuit opens again right away.
+type circuitBreaker struct {
+	sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether the server may be polled.
+func (cb *circuitBreaker) allow() bool {
+	cb.Lock()
+	defer cb.Unlock()
+	return !time.Now().Before(cb.openUntil)
+}
+
+// record records the outcome of a poll, opening the circuit after
+// threshold consecutive failures.  A zero threshold disables the breaker.
+func (cb *circuitBreaker) record(err error, threshold int, cooldown time.Duration) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if threshold > 0 && cb.failures >= threshold {
+		if cooldown <= 0 {
+			cooldown = DefaultBreakerCooldown
+		}
+		cb.openUntil = time.Now().Add(cooldown)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewBuildInfoCollector returns a collector of the nats_exporter_build_info
+// metric, always 1, labeled with the version and commit of the exporter
+// and the Go version it was built with.
+func NewBuildInfoCollector(version, commit string) prometheus.Collector {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nats_exporter",
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by the version, commit and Go version of the exporter.",
+		ConstLabels: prometheus.Labels{
+			"version":    version,
+			"commit":     commit,
+			"go_version": runtime.Version(),
+		},
+	})
+	g.Set(1)
+	return g
+}
@@ -12,14 +12,31 @@
 // limitations under the License.
 
 // Package collector has various collector utilities and implementations.
+//
+// The collectors poll the monitoring endpoints of NATS servers when they
+// are collected, and can be registered with any prometheus.Registerer:
+// NewCollectorWithOptions creates the collector of an endpoint of a
+// system, polling as configured by a CollectorOptions.  The package logs
+// nothing until ConfigureLogger is called, or logs to the Logger of the
+// options instead.
 package collector
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,10 +52,237 @@ var (
 	ReplicatorSystem = "replicator"
 )
 
+// Collector defaults
+var (
+	// DefaultMaxConcurrentRequests is the default number of servers a
+	// collector polls at the same time.
+	DefaultMaxConcurrentRequests = 8
+
+	// DefaultCollectTimeout bounds a collection when the scraper does
+	// not provide a deadline of its own.
+	DefaultCollectTimeout = 10 * time.Second
+
+	// DefaultMaxResponseBytes is the default limit on the size of a
+	// monitor response.
+	DefaultMaxResponseBytes int64 = 64 << 20
+
+	// DefaultMaxPages is the default limit on the pages requested from a
+	// paginated endpoint in a poll.
+	DefaultMaxPages = 100
+)
+
 // CollectedServer is a NATS server polled by this collector
 type CollectedServer struct {
 	URL string
 	ID  string
+
+	// Headers are added to every request to the server.  A Host header
+	// overrides the host requested.
+	Headers http.Header
+
+	// Cluster labels the metrics of the server with the NATS cluster it
+	// belongs to.
+	Cluster string
+
+	// Labels are static labels added to every metric of the server.
+	Labels map[string]string
+
+	// Collectors, if any, are the only collectors of the exporter polling
+	// the server, named after their flags, e.g. varz or jsz.
+	Collectors []string
+
+	breaker  circuitBreaker
+	name     serverName
+	id       serverID
+	restarts serverRestarts
+}
+
+// CollectorOptions configure how a collector polls the NATS servers.
+type CollectorOptions struct {
+	// MaxConcurrentRequests is the maximum number of servers polled
+	// concurrently.  Zero or less uses DefaultMaxConcurrentRequests.
+	MaxConcurrentRequests int
+
+	// CollectTimeout bounds a call to Collect.  Zero means no limit
+	// beyond the HTTP client's own.
+	CollectTimeout time.Duration
+
+	// Retry configures how failed polls are retried within a collection.
+	Retry RetryPolicy
+
+	// PollSpread spreads the polls of the servers over this duration
+	// rather than polling them all at once, each delayed by an offset
+	// given by the server and endpoint, with some jitter.  Zero polls them
+	// at once.
+	PollSpread time.Duration
+
+	// BreakerThreshold is the number of consecutive failed polls after
+	// which a server is skipped for BreakerCooldown.  Zero disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxResponseBytes is the largest monitor response read from a
+	// server.  Zero means no limit.
+	MaxResponseBytes int64
+
+	// DisableCompression stops requesting gzip encoded responses.
+	DisableCompression bool
+
+	// Timeouts of the HTTP client polling the servers.  Zero means no
+	// limit.  RequestTimeout bounds each request, including reading the
+	// response body.
+	ConnectTimeout        time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	RequestTimeout        time.Duration
+
+	// Connection pooling of the HTTP client.  Zero MaxIdleConnsPerHost
+	// keeps two idle connections per server, and zero IdleConnTimeout
+	// keeps them open indefinitely.
+	DisableKeepAlives   bool
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// TLSConfig is used to poll HTTPS monitor endpoints.
+	TLSConfig *tls.Config
+
+	// Proxy is the HTTP or SOCKS5 proxy the servers are polled through.
+	// If nil, the proxy is taken from the environment.
+	Proxy *url.URL
+
+	// MonitorUser and MonitorPassword are sent as basic auth credentials
+	// to servers whose URL has none of its own.
+	MonitorUser     string
+	MonitorPassword string
+
+	// BearerTokenFile holds a token sent as an Authorization: Bearer header
+	// instead.  The file is read on every request, so the token can be
+	// rotated.
+	BearerTokenFile string
+
+	// ServerNameLabel labels the metrics of a server with the server_name
+	// from its /varz: ServerNameLabelAdd adds a server_name label, and
+	// ServerNameLabelReplace uses the name as the server_id.  The name is
+	// looked up again every ServerNameRefresh.
+	ServerNameLabel   string
+	ServerNameRefresh time.Duration
+
+	// InternalServerIDLabel uses the server_id a server reports in its
+	// /varz as its server_id.  The ID is looked up in the background, the
+	// metrics keeping the ID given to the server until it responds, and
+	// again every ServerNameRefresh, since it changes as the server
+	// restarts.
+	InternalServerIDLabel bool
+
+	// UseServerURLLabel uses the host:port of the URL of a server as its
+	// server_id, which unlike the ID reported by the server is stable
+	// across restarts.
+	UseServerURLLabel bool
+
+	// MetricRenames renames the metrics of monitor fields, given by the
+	// endpoint and field name, e.g. varz.mem.
+	MetricRenames map[string]MetricRename
+
+	// EndpointParams are query parameters appended to the URLs of the
+	// varz, connz, subsz, routez and gatewayz endpoints, by endpoint name,
+	// e.g. to sort connz or list the subscriptions of subsz.
+	EndpointParams map[string]url.Values
+
+	// Accounts, if any, are the only accounts whose metrics are collected,
+	// by the collectors labeling them by account.
+	Accounts []string
+
+	// ConnzAccounts are the accounts whose connections connz is also
+	// polled for, filtered by its acc parameter, labeling their metrics by
+	// account.  Those left out by Accounts are not polled.
+	ConnzAccounts []string
+
+	// SubjectAggregations are subject patterns, e.g. orders.*.eu.>, the
+	// subscriptions listed by subsz are counted by, under the first their
+	// subject matches, or "other" if none.
+	SubjectAggregations []string
+
+	// MaxPages bounds the pages requested from the paginated connz and
+	// subsz endpoints in a poll, following their offset and limit until
+	// all the connections or subscriptions are retrieved.  Zero uses
+	// DefaultMaxPages, and one only requests the first page.
+	MaxPages int
+
+	// UnitMetricNames names the metrics of monitor fields with a unit
+	// after their base unit, e.g. varz_mem_bytes and varz_uptime_seconds,
+	// converting their values to it.  LegacyMetricNames serves the former
+	// metrics of these fields too, to ease migrating dashboards.
+	UnitMetricNames   bool
+	LegacyMetricNames bool
+
+	// HTTPClient, if set, is shared by the collectors rather than each
+	// creating its own from the options above.
+	HTTPClient *http.Client
+
+	// OnPoll, if set, is called with the outcome of every poll of a
+	// server, given by its ID.  Servers skipped while their circuit is
+	// open are reported with errCircuitOpen.
+	OnPoll func(serverID string, err error)
+
+	// OnResponse, if set, is called with the body of every response of a
+	// server polled at an endpoint, e.g. to keep the last one for
+	// debugging.  The body is not reused by the collectors.
+	OnResponse func(serverID, endpoint string, body []byte)
+
+	// OnPollStatus, if set, is called with the outcome of every poll of a
+	// server at an endpoint, detailed, e.g. to report it for
+	// troubleshooting.
+	OnPollStatus func(status PollStatus)
+
+	// Logger, if set, receives the logs of the collectors instead of the
+	// package logger, which logs nothing until set up by ConfigureLogger.
+	// Its debug and trace statements are not filtered, but the responses
+	// of the servers are only traced by a package logger tracing.
+	Logger Logger
+}
+
+// logger returns the logger of the collectors.
+func (opts *CollectorOptions) logger() Logger {
+	return opts.serverLogger("", "")
+}
+
+// serverLogger returns the logger of the statements of a collector about a
+// server it polls at an endpoint.
+func (opts *CollectorOptions) serverLogger(endpoint, serverID string) Logger {
+	var log Logger = packageLogger{}
+	if opts.Logger != nil {
+		log = opts.Logger
+	}
+	return withFields(log, logFields{component: "collector", serverID: serverID, endpoint: endpoint})
+}
+
+// PollStatus is the outcome of a poll of a server at an endpoint.
+type PollStatus struct {
+	ServerID   string
+	Endpoint   string
+	Time       time.Time // When the poll started.
+	Duration   time.Duration
+	StatusCode int // Of the last response of the server, zero if none.
+	Err        error
+}
+
+// MetricRename renames the metric of a monitor field.
+type MetricRename struct {
+	// Name is the full name of the metric.
+	Name string
+
+	// Help is the help of the metric, the field name if empty.
+	Help string
+}
+
+// ContextCollector is a prometheus.Collector whose collection can be
+// bound to a context, e.g. one carrying the deadline of a scrape.
+type ContextCollector interface {
+	prometheus.Collector
+
+	// CollectWithContext is Collect, abandoning any outstanding requests
+	// to the NATS servers once ctx is done.
+	CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric)
 }
 
 // NATSCollector collects NATS metrics
@@ -49,13 +293,58 @@ type NATSCollector struct {
 	endpoint   string
 	system     string
 	servers    []*CollectedServer
+	opts       *CollectorOptions
+	polls      *pollMetrics
+	restarts   *restartTracker  // Of the servers polled at their /varz.
+	subjects   *prometheus.Desc // Subscriptions by subject, if subsz lists them.
+}
+
+// errResponseTooLarge is returned when a monitor response is larger
+// than the MaxResponseBytes option.
+var errResponseTooLarge = errors.New("response exceeds the maximum size")
+
+// errCircuitOpen is reported for the servers skipped while their circuit
+// is open.
+var errCircuitOpen = errors.New("circuit is open")
+
+// pollMetrics report the outcome of polling a server's endpoint.
+type pollMetrics struct {
+	system   string
+	endpoint string
+	up       *prometheus.Desc
+	tooLarge *prometheus.CounterVec
+}
+
+func newPollMetrics(system, endpoint string) *pollMetrics {
+	return &pollMetrics{
+		system:   system,
+		endpoint: endpoint,
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(system, endpoint, "up"),
+			"Whether the last poll of the server succeeded",
+			[]string{"server_id"},
+			nil,
+		),
+		tooLarge: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: system,
+			Subsystem: endpoint,
+			Name:      "response_too_large_total",
+			Help:      "Number of polls whose response exceeded the maximum size",
+		}, []string{"server_id"}),
+	}
+}
+
+// Describe describes the poll metrics.
+func (pm *pollMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pm.up
+	pm.tooLarge.Describe(ch)
 }
 
 // newPrometheusGaugeVec creates a custom GaugeVec
 // Based on our current integration, we're going to treat all metrics as gauges.
 // We are going to call the set message on the gauge when we receive an updated
 // metrics pull.
-func newPrometheusGaugeVec(system, subsystem, name, help, prefix string) (metric *prometheus.GaugeVec) {
+func newPrometheusGaugeVec(system, subsystem, name, help, prefix string, log Logger) (metric *prometheus.GaugeVec) {
 	if help == "" {
 		help = name
 	}
@@ -71,76 +360,191 @@ func newPrometheusGaugeVec(system, subsystem, name, help, prefix string) (metric
 	}
 	metric = prometheus.NewGaugeVec(opts, []string{"server_id"})
 
-	Tracef("Created metric: %s, %s, %s, %s", namespace, subsystem, name, help)
+	log.Tracef("Created metric: %s, %s, %s, %s", namespace, subsystem, name, help)
 	return metric
 }
 
-// GetMetricURL retrieves a NATS Metrics JSON.
+// MetricName returns the name of the metric of a numeric monitor field of
+// an endpoint polled by the generic collector, following the prefix,
+// metric renames and unit names configured.
+func MetricName(system, endpoint, field, prefix string, opts *CollectorOptions) string {
+	key := endpoint + "." + field
+	if r, ok := opts.MetricRenames[key]; ok {
+		return r.Name
+	}
+	if u, ok := unitFields[key]; ok && opts.UnitMetricNames {
+		field = u.name
+	}
+	return prometheus.BuildFQName(getSystem(system, prefix), endpoint, field)
+}
+
+// newRenamedGaugeVec creates a GaugeVec for a monitor field under the
+// name it is renamed to.
+func newRenamedGaugeVec(field string, r MetricRename, log Logger) *prometheus.GaugeVec {
+	help := r.Help
+	if help == "" {
+		help = field
+	}
+	log.Tracef("Created metric: %s, %s, renamed from %s", r.Name, help, field)
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: r.Name, Help: help}, []string{"server_id"})
+}
+
+// GetMetricURL retrieves a NATS Metrics JSON, retrying failed attempts
+// as configured by the retry policy.
 // This can be called against any monitoring URL for NATS.
 // On any this function will error, warn and return nil.
-func getMetricURL(httpClient *http.Client, url string, response interface{}) error {
-	resp, err := httpClient.Get(url)
+func getMetricURL(ctx context.Context, httpClient *http.Client, opts *CollectorOptions,
+	url string, headers http.Header, response interface{}) error {
+	return opts.Retry.retry(ctx, opts.logger(), func() error {
+		err := fetchMetricURL(ctx, httpClient, opts, url, headers, response)
+		if err == errResponseTooLarge {
+			// The response will not shrink by asking again.
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+// fetchMetricURL makes a single attempt to retrieve a NATS Metrics JSON.
+func fetchMetricURL(ctx context.Context, httpClient *http.Client, opts *CollectorOptions,
+	url string, headers http.Header, response interface{}) (err error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	if err := setAuthorization(req, opts); err != nil {
+		return err
+	}
+	setHeaders(req, headers)
+
+	ctx, span := StartSpan(ctx, "GET "+req.URL.Path)
+	defer func() { span.Finish(err) }()
+	u := *req.URL
+	u.User = nil
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", u.String())
+	span.SetAttribute("server.address", req.URL.Host)
+	setTraceParent(req, span)
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
-	Tracef("Retrieved metric result:\n%s\n", string(body))
-	return json.Unmarshal(body, &response)
+	defer resp.Body.Close()
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+
+	// Decode straight from the body rather than buffering whole responses,
+	// which can be very large for connz and subsz.
+	var r io.Reader = resp.Body
+	var lr *io.LimitedReader
+	if opts.MaxResponseBytes > 0 {
+		lr = &io.LimitedReader{R: r, N: opts.MaxResponseBytes + 1}
+		r = lr
+	}
+	polled, _ := ctx.Value(polledServerKey{}).(*polledServer)
+	if polled != nil {
+		polled.statusCode = resp.StatusCode
+	}
+	recorded := polled != nil && opts.OnResponse != nil
+	var body *bytes.Buffer
+	if atomic.LoadInt32(&trace) != 0 || recorded {
+		body = &bytes.Buffer{}
+		r = io.TeeReader(r, body)
+	}
+	err = json.NewDecoder(r).Decode(response)
+	if recorded {
+		opts.OnResponse(polled.id, polled.endpoint, body.Bytes())
+	}
+	if lr != nil && lr.N <= 0 {
+		opts.logger().Errorf("Response from %s exceeds %d bytes", url, opts.MaxResponseBytes)
+		return errResponseTooLarge
+	}
+	if atomic.LoadInt32(&trace) != 0 {
+		opts.logger().Tracef("Retrieved metric result:\n%s\n", body.String())
+	}
+	return err
 }
 
-// GetServerIDFromVarz gets the server ID from the server.
+// polledServer is the server polled, and endpoint, a request is made for,
+// recording the status of the last response.  Requests of a poll are
+// made one after another.
+type polledServer struct {
+	id         string
+	endpoint   string
+	statusCode int
+}
+
+type polledServerKey struct{}
+
+// GetServerIDFromVarz gets the server ID from the server, retrying every
+// retryInterval until it is available.
 func GetServerIDFromVarz(endpoint string, retryInterval time.Duration) string {
-	getServerID := func() (string, error) {
-		resp, err := http.DefaultClient.Get(endpoint + "/varz")
-		if err != nil {
-			return "", err
-		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
-		}
+	// Retry periodically until available, in case it never starts
+	// then a liveness check against the NATS Server itself should
+	// detect that an restart the server, in terms of the exporter
+	// we just wait for it to eventually be available.
+	opts := &CollectorOptions{
+		Retry: RetryPolicy{
+			MaxAttempts: -1,
+			Backoff:     retryInterval,
+			MaxBackoff:  retryInterval,
+		},
+	}
+	id, _ := GetServerIDFromVarzWithOptions(context.Background(), endpoint, opts)
+	return id
+}
+
+// GetServerIDFromVarzWithOptions gets the server ID from the server,
+// polling as configured by the options and retrying as configured by
+// their policy until it is available or ctx is done.
+func GetServerIDFromVarzWithOptions(ctx context.Context, endpoint string, opts *CollectorOptions) (string, error) {
+	var id string
+	httpClient := newHTTPClient(opts)
+	err := opts.Retry.retry(ctx, opts.logger(), func() error {
 		var response map[string]interface{}
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			return "", err
+		if err := fetchMetricURL(ctx, httpClient, opts, endpoint+"/varz", nil, &response); err != nil {
+			return err
 		}
 		serverID, ok := response["server_id"]
 		if !ok {
-			Fatalf("Could not find server id in /varz")
+			return permanentError{errors.New("could not find server id in /varz")}
 		}
-		id, ok := serverID.(string)
+		id, ok = serverID.(string)
 		if !ok {
-			Fatalf("Invalid server_id type in /varz: %+v", serverID)
+			return permanentError{fmt.Errorf("invalid server_id type in /varz: %+v", serverID)}
 		}
-
-		return id, nil
+		return nil
+	})
+	if err != nil {
+		opts.logger().Errorf("Could not find server id: %s", err)
 	}
+	return id, err
+}
 
-	var id string
-	var err error
-	id, err = getServerID()
-	if err == nil {
-		return id
+// GetRouteIPsFromRoutez gets the IP addresses of the servers routed to
+// from the /routez of the server at endpoint, polling as configured by the
+// options.
+func GetRouteIPsFromRoutez(ctx context.Context, endpoint string, headers http.Header,
+	opts *CollectorOptions) ([]string, error) {
+	var routez struct {
+		Routes []struct {
+			IP string `json:"ip"`
+		} `json:"routes"`
 	}
-
-	// Retry periodically until available, in case it never starts
-	// then a liveness check against the NATS Server itself should
-	// detect that an restart the server, in terms of the exporter
-	// we just wait for it to eventually be available.
-	for range time.NewTicker(retryInterval).C {
-		id, err = getServerID()
-		if err != nil {
-			Errorf("Could not find server id: %s", err)
-			continue
+	httpClient := newHTTPClient(opts)
+	err := opts.Retry.retry(ctx, opts.logger(), func() error {
+		return fetchMetricURL(ctx, httpClient, opts, endpoint+"/routez", headers, &routez)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(routez.Routes))
+	for _, r := range routez.Routes {
+		if r.IP != "" {
+			ips = append(ips, r.IP)
 		}
-		break
 	}
-	return id
+	return ips, nil
 }
 
 // Describe the metric to the Prometheus server.
@@ -148,6 +552,18 @@ func (nc *NATSCollector) Describe(ch chan<- *prometheus.Desc) {
 	nc.Lock()
 	defer nc.Unlock()
 
+	// Only describe up once the metrics are known, so registration is
+	// retried until a server responds.
+	if len(nc.Stats) > 0 {
+		nc.polls.Describe(ch)
+		if nc.restarts != nil {
+			nc.restarts.Describe(ch)
+		}
+		if nc.subjects != nil {
+			ch <- nc.subjects
+		}
+	}
+
 	// for each stat in nc.Stats
 	for _, k := range nc.Stats {
 		switch m := k.(type) {
@@ -157,26 +573,140 @@ func (nc *NATSCollector) Describe(ch chan<- *prometheus.Desc) {
 			m.Describe(ch)
 		case *prometheus.CounterVec:
 			m.Describe(ch)
+		case *unitGaugeVec:
+			m.Describe(ch)
 		default:
-			Tracef("Describe: Unknown metric type: %v", k)
+			nc.opts.logger().Tracef("Describe: Unknown metric type: %v", k)
+		}
+	}
+}
+
+// collectContext returns the context bounding a collection that was not
+// given one, as configured by the CollectTimeout option.
+func collectContext(opts *CollectorOptions) (context.Context, context.CancelFunc) {
+	if opts.CollectTimeout > 0 {
+		return context.WithTimeout(context.Background(), opts.CollectTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// pollServers calls poll for each of the servers, running at most
+// MaxConcurrentRequests polls concurrently, and returns once all of them
+// have completed.  Servers whose circuit is open are skipped.  The poll
+// metrics are sent for every server with the outcome of its poll.  Each
+// poll is given a context traced apart, if ctx is traced.  The polls are
+// spread over PollSpread.
+func pollServers(ctx context.Context, servers []*CollectedServer, opts *CollectorOptions, pm *pollMetrics,
+	ch chan<- prometheus.Metric, poll func(ctx context.Context, server *CollectedServer) error) {
+	ctx, span := StartSpan(ctx, "collect "+pm.endpoint)
+	defer span.Finish(nil)
+	span.SetAttribute("nats.system", pm.system)
+	span.SetAttribute("nats.endpoint", pm.endpoint)
+
+	limit := opts.MaxConcurrentRequests
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentRequests
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		// Export the counters from the start, rather than on the first error.
+		pm.tooLarge.WithLabelValues(s.ID)
+
+		if !s.breaker.allow() {
+			opts.serverLogger("", s.ID).Debugf("skipping server %s, circuit is open", s.ID)
+			ch <- prometheus.MustNewConstMetric(pm.up, prometheus.GaugeValue, 0, s.ID)
+			if opts.OnPoll != nil {
+				opts.OnPoll(s.ID, errCircuitOpen)
+			}
+			if opts.OnPollStatus != nil {
+				opts.OnPollStatus(PollStatus{ServerID: s.ID, Endpoint: pm.endpoint, Time: time.Now(), Err: errCircuitOpen})
+			}
+			continue
 		}
+		wg.Add(1)
+		go func(s *CollectedServer) {
+			defer wg.Done()
+			waitPollOffset(ctx, opts.PollSpread, pm.endpoint, s.ID)
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ctx, span := StartSpan(ctx, "poll "+pm.endpoint)
+			span.SetAttribute("nats.server_id", s.ID)
+			polled := &polledServer{id: s.ID, endpoint: pm.endpoint}
+			start := time.Now()
+			err := poll(context.WithValue(ctx, polledServerKey{}, polled), s)
+			span.Finish(err)
+			if opts.OnPollStatus != nil {
+				opts.OnPollStatus(PollStatus{
+					ServerID:   s.ID,
+					Endpoint:   pm.endpoint,
+					Time:       start,
+					Duration:   time.Since(start),
+					StatusCode: polled.statusCode,
+					Err:        err,
+				})
+			}
+			if err == errResponseTooLarge {
+				pm.tooLarge.WithLabelValues(s.ID).Inc()
+			}
+			s.breaker.record(err, opts.BreakerThreshold, opts.BreakerCooldown)
+			if opts.OnPoll != nil {
+				opts.OnPoll(s.ID, err)
+			}
+			ch <- prometheus.MustNewConstMetric(pm.up, prometheus.GaugeValue, boolToFloat(err == nil), s.ID)
+		}(s)
+	}
+	wg.Wait()
+	pm.tooLarge.Collect(ch)
+}
+
+// waitPollOffset waits for the offset of the poll of a server at an
+// endpoint within spread, or until ctx is done.  The offset is given by the
+// hash of the endpoint and server ID, so that the servers are polled in the
+// same order on every poll, plus a jitter of up to a tenth of spread.
+func waitPollOffset(ctx context.Context, spread time.Duration, endpoint, serverID string) {
+	if spread <= 0 {
+		return
+	}
+	h := fnv.New64a()
+	h.Write([]byte(endpoint + "/" + serverID)) // nolint
+	offset := time.Duration(h.Sum64() % uint64(spread))
+	offset += time.Duration(rand.Int63n(int64(spread/10) + 1))
+	t := time.NewTimer(offset % spread)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
 	}
 }
 
 // makeRequests makes HTTP request to the NATS server(s) monitor URLs and returns
 // a map of responses.
-func (nc *NATSCollector) makeRequests() map[string]map[string]interface{} {
+func (nc *NATSCollector) makeRequests(ctx context.Context, ch chan<- prometheus.Metric) map[string]map[string]interface{} {
 	// query the URL for the most recent stats.
 	// get all the Metrics at once, then set the stats and collect them together.
+	var mu sync.Mutex
 	resps := make(map[string]map[string]interface{})
-	for _, u := range nc.servers {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, u *CollectedServer) error {
 		var response = map[string]interface{}{}
-		if err := getMetricURL(nc.httpClient, u.URL, &response); err != nil {
-			Debugf("ignoring server %s: %v", u.ID, err)
-			delete(resps, u.ID)
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, u.URL, u.Headers, &response); err != nil {
+			nc.opts.serverLogger(nc.endpoint, u.ID).Debugf("ignoring server %s: %v", u.ID, err)
+			return err
 		}
+		if nc.endpoint == "subsz" && subszDetail(nc.opts) {
+			if err := nc.getSubszPages(ctx, u, response); err != nil {
+				nc.opts.serverLogger(nc.endpoint, u.ID).Debugf("ignoring server %s: %v", u.ID, err)
+				return err
+			}
+		}
+		if nc.restarts != nil {
+			nc.restarts.observe(u.ID, response)
+		}
+		mu.Lock()
 		resps[u.ID] = response
-	}
+		mu.Unlock()
+		return nil
+	})
 	return resps
 }
 
@@ -191,7 +721,7 @@ func (nc *NATSCollector) collectStatsFromRequests(
 			case float64: // not sure why, but all my json numbers are coming here.
 				m.WithLabelValues(id).Set(v)
 			default:
-				Debugf("value no longer a float", id, v)
+				nc.opts.logger().Debugf("value of %s from %s no longer a float: %v", key, id, v)
 			}
 		}
 		m.Collect(ch) // update the stat.
@@ -201,26 +731,53 @@ func (nc *NATSCollector) collectStatsFromRequests(
 			case float64: // not sure why, but all my json numbers are coming here.
 				m.WithLabelValues(id).Add(v)
 			default:
-				Debugf("value no longer a float", id, v)
+				nc.opts.logger().Debugf("value of %s from %s no longer a float: %v", key, id, v)
+			}
+		}
+		m.Collect(ch) // update the stat.
+	case *unitGaugeVec:
+		for id, response := range resps {
+			if v, ok := m.value(response[key]); ok {
+				m.WithLabelValues(id).Set(v)
+			} else {
+				nc.opts.logger().Debugf("value of %s from %s not in its unit: %v", key, id, response[key])
+			}
+			if v, ok := response[key].(float64); ok && m.legacy != nil {
+				m.legacy.WithLabelValues(id).Set(v)
 			}
 		}
 		m.Collect(ch) // update the stat.
 	default:
-		Tracef("Unknown Metric Type %s", key)
+		nc.opts.logger().Tracef("Unknown Metric Type %s", key)
 	}
 }
 
 // Collect all metrics for all URLs to send to Prometheus.
 func (nc *NATSCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext collects all metrics for all URLs, bounded by ctx.
+func (nc *NATSCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	nc.Lock()
 	defer nc.Unlock()
 
-	resps := nc.makeRequests()
+	resps := nc.makeRequests(ctx, ch)
 	if len(resps) > 0 {
 		for key, stat := range nc.Stats {
 			nc.collectStatsFromRequests(key, stat, resps, ch)
 		}
 	}
+	if nc.restarts != nil && len(nc.Stats) > 0 {
+		nc.restarts.Collect(ch)
+	}
+	if nc.subjects != nil && len(nc.Stats) > 0 {
+		for id, response := range resps {
+			nc.collectSubjects(id, response, ch)
+		}
+	}
 }
 
 // initMetricsFromServers builds the configuration
@@ -232,16 +789,19 @@ func (nc *NATSCollector) initMetricsFromServers(namespace string) {
 
 	nc.Stats = make(map[string]interface{})
 
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+
 	// gets URLs until one responds.
 	for _, v := range nc.servers {
-		Tracef("Initializing metrics collection from: %s", v.URL)
-		if err := getMetricURL(nc.httpClient, v.URL, &response); err != nil {
+		nc.opts.logger().Tracef("Initializing metrics collection from: %s", v.URL)
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, v.URL, v.Headers, &response); err != nil {
 			// if a server is not running, silently ignore it.
 			if strings.Contains(err.Error(), "connection refused") {
-				Debugf("Unable to connect to the NATS server: %v", err)
+				nc.opts.logger().Debugf("Unable to connect to the NATS server: %v", err)
 			} else {
 				// TODO:  Do not retry for other errors?
-				Errorf("Error loading metric config from response: %s", err)
+				nc.opts.logger().Errorf("Error loading metric config from response: %s", err)
 			}
 		} else {
 			break
@@ -254,27 +814,34 @@ func (nc *NATSCollector) initMetricsFromServers(namespace string) {
 		_, ok := nc.Stats[k]
 		if !ok {
 			i := response[k]
+			if u, ok := unitFields[nc.endpoint+"."+k]; ok && nc.opts.UnitMetricNames {
+				nc.Stats[k] = nc.newUnitGaugeVec(k, namespace, u, i)
+				continue
+			}
 			switch v := i.(type) {
 			case float64: // all json numbers are handled here.
-				nc.Stats[k] = newPrometheusGaugeVec(nc.system, nc.endpoint, k, "", namespace)
+				if r, ok := nc.opts.MetricRenames[nc.endpoint+"."+k]; ok {
+					nc.Stats[k] = newRenamedGaugeVec(k, r, nc.opts.logger())
+				} else {
+					nc.Stats[k] = newPrometheusGaugeVec(nc.system, nc.endpoint, k, "", namespace, nc.opts.logger())
+				}
 			case string:
 				// do nothing
 			default:
 				// not one of the types currently handled
-				Tracef("Unknown type:  %v, %v", k, v)
+				nc.opts.logger().Tracef("Unknown type:  %v, %v", k, v)
 			}
 		}
 	}
 }
 
-func newNatsCollector(system, endpoint string, servers []*CollectedServer) prometheus.Collector {
-	// TODO:  Potentially add TLS config in the transport.
-	tr := &http.Transport{}
-	hc := &http.Client{Transport: tr}
+func newNatsCollector(system, endpoint string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	nc := &NATSCollector{
-		httpClient: hc,
+		httpClient: newHTTPClient(opts),
 		system:     system,
 		endpoint:   endpoint,
+		opts:       opts,
+		polls:      newPollMetrics(system, endpoint),
 	}
 
 	// create our own deep copy, and tweak the urls to be polled
@@ -282,16 +849,77 @@ func newNatsCollector(system, endpoint string, servers []*CollectedServer) prome
 	nc.servers = make([]*CollectedServer, len(servers))
 	for i, s := range servers {
 		nc.servers[i] = &CollectedServer{
-			ID:  s.ID,
-			URL: s.URL + "/" + endpoint,
+			ID:      s.ID,
+			URL:     endpointURL(s.URL, endpoint, opts),
+			Headers: s.Headers,
 		}
 	}
 
+	// Only the core endpoints are polled by a NATSCollector, the
+	// system possibly prefixed.
+	switch {
+	case endpoint == "varz":
+		nc.restarts = newRestartTracker(servers, opts)
+	case endpoint == "subsz" && subszDetail(opts):
+		nc.subjects = prometheus.NewDesc(
+			prometheus.BuildFQName(system, endpoint, "subject_subscriptions"),
+			"Number of subscriptions by the subject pattern they match, or other",
+			[]string{"server_id", "subject"},
+			nil,
+		)
+	}
+
 	nc.initMetricsFromServers(system)
 
 	return nc
 }
 
+// endpointURL returns the URL of an endpoint of a server, with the query
+// parameters configured for the endpoint.
+func endpointURL(serverURL, endpoint string, opts *CollectorOptions) string {
+	u := serverURL + "/" + endpoint
+	if params := opts.EndpointParams[endpoint]; len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u
+}
+
+// pageURL returns the URL of the page of a paginated endpoint starting
+// at offset.
+func pageURL(endpointURL string, offset int) (string, error) {
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// maxPages returns the limit on the pages requested from a paginated
+// endpoint.
+func maxPages(opts *CollectorOptions) int {
+	if opts.MaxPages <= 0 {
+		return DefaultMaxPages
+	}
+	return opts.MaxPages
+}
+
+// accountSelected reports whether the metrics of an account are
+// collected: all of them, unless Accounts are listed.
+func accountSelected(opts *CollectorOptions, account string) bool {
+	if len(opts.Accounts) == 0 {
+		return true
+	}
+	for _, a := range opts.Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
 func getSystem(system, prefix string) string {
 	if prefix == "" {
 		return system
@@ -309,18 +937,40 @@ func boolToFloat(b bool) float64 {
 // NewCollector creates a new NATS Collector from a list of monitoring URLs.
 // Each URL should be to a specific endpoint (e.g. varz, connz, subsz, or routez)
 func NewCollector(system, endpoint, prefix string, servers []*CollectedServer) prometheus.Collector {
+	return NewCollectorWithOptions(system, endpoint, prefix, servers, nil)
+}
+
+// NewCollectorWithOptions creates a new NATS Collector from a list of
+// monitoring URLs, polling them as configured by opts.  A nil opts uses
+// the defaults.
+func NewCollectorWithOptions(system, endpoint, prefix string, servers []*CollectedServer,
+	opts *CollectorOptions) prometheus.Collector {
+	if opts == nil {
+		opts = &CollectorOptions{}
+	}
+	return newLabeledCollector(newCollector(system, endpoint, prefix, servers, opts), servers, opts)
+}
+
+func newCollector(system, endpoint, prefix string, servers []*CollectedServer,
+	opts *CollectorOptions) prometheus.Collector {
 	if isStreamingEndpoint(system, endpoint) {
-		return newStreamingCollector(getSystem(system, prefix), endpoint, servers)
+		return newStreamingCollector(getSystem(system, prefix), endpoint, servers, opts)
 	}
 	if isConnzEndpoint(system, endpoint) {
-		return newConnzCollector(getSystem(system, prefix), endpoint, servers)
+		return newConnzCollector(getSystem(system, prefix), endpoint, servers, opts)
 	}
 	if isGatewayzEndpoint(system, endpoint) {
-		return newGatewayzCollector(getSystem(system, prefix), endpoint, servers)
+		return newGatewayzCollector(getSystem(system, prefix), endpoint, servers, opts)
+	}
+	if isJszEndpoint(system, endpoint) {
+		return newJszCollector(getSystem(system, prefix), endpoint, servers, opts)
+	}
+	if isIpqueueszEndpoint(system, endpoint) {
+		return newIpqueueszCollector(getSystem(system, prefix), endpoint, servers, opts)
 	}
 
 	if isReplicatorEndpoint(system, endpoint) {
-		return newReplicatorCollector(getSystem(system, prefix), servers)
+		return newReplicatorCollector(getSystem(system, prefix), servers, opts)
 	}
-	return newNatsCollector(getSystem(system, prefix), endpoint, servers)
+	return newNatsCollector(getSystem(system, prefix), endpoint, servers, opts)
 }
@@ -14,14 +14,27 @@
 package collector
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	stan "github.com/nats-io/go-nats-streaming"
 	pet "github.com/nats-io/prometheus-nats-exporter/test"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
 )
 
@@ -240,6 +253,1313 @@ func TestNoServer(t *testing.T) {
 	verifyCollector(CoreSystem, url, "varz", cases, t)
 }
 
+func TestPollServersConcurrencyLimit(t *testing.T) {
+	servers := make([]*CollectedServer, 10)
+	for i := range servers {
+		servers[i] = &CollectedServer{ID: fmt.Sprintf("id%d", i)}
+	}
+
+	var inFlight, maxInFlight, polled int32
+	pm := newPollMetrics("test", "varz")
+	ch := make(chan prometheus.Metric, 2*len(servers))
+	opts := &CollectorOptions{MaxConcurrentRequests: 3}
+	pollServers(context.Background(), servers, opts, pm, ch, func(_ context.Context, _ *CollectedServer) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&polled, 1)
+		return nil
+	})
+
+	if polled != int32(len(servers)) {
+		t.Fatalf("Expected %d servers polled, got %d", len(servers), polled)
+	}
+	if maxInFlight > 3 {
+		t.Fatalf("Expected at most 3 concurrent polls, got %d", maxInFlight)
+	}
+	if maxInFlight < 2 {
+		t.Fatalf("Expected servers to be polled concurrently, got %d", maxInFlight)
+	}
+	if len(ch) != 2*len(servers) {
+		t.Fatalf("Expected up and response size metrics per server, got %d", len(ch))
+	}
+}
+
+func TestPollServersSpread(t *testing.T) {
+	servers := make([]*CollectedServer, 20)
+	for i := range servers {
+		servers[i] = &CollectedServer{ID: fmt.Sprintf("id%d", i)}
+	}
+
+	var mu sync.Mutex
+	var first, last time.Time
+	pm := newPollMetrics("test", "varz")
+	ch := make(chan prometheus.Metric, 2*len(servers))
+	opts := &CollectorOptions{PollSpread: 200 * time.Millisecond}
+	start := time.Now()
+	pollServers(context.Background(), servers, opts, pm, ch, func(_ context.Context, _ *CollectedServer) error {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		if first.IsZero() || now.Before(first) {
+			first = now
+		}
+		if now.After(last) {
+			last = now
+		}
+		return nil
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the polls within the spread, took %v", elapsed)
+	}
+	if last.Sub(first) < 50*time.Millisecond {
+		t.Fatalf("Expected the polls to be spread out, polled within %v", last.Sub(first))
+	}
+
+	// The polls are not delayed past the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	opts.PollSpread = time.Hour
+	start = time.Now()
+	ch = make(chan prometheus.Metric, 2*len(servers))
+	pollServers(ctx, servers, opts, pm, ch, func(_ context.Context, _ *CollectedServer) error { return nil })
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the polls to stop waiting once the context is done, took %v", elapsed)
+	}
+}
+
+func TestPollServersCircuitBreaker(t *testing.T) {
+	servers := []*CollectedServer{{ID: "id"}}
+	pm := newPollMetrics("test", "varz")
+	opts := &CollectorOptions{BreakerThreshold: 2, BreakerCooldown: 200 * time.Millisecond}
+	var outcomes []error
+	opts.OnPoll = func(id string, err error) {
+		outcomes = append(outcomes, err)
+	}
+
+	polls := 0
+	poll := func() float64 {
+		ch := make(chan prometheus.Metric, 2)
+		pollServers(context.Background(), servers, opts, pm, ch, func(_ context.Context, _ *CollectedServer) error {
+			polls++
+			return fmt.Errorf("fail")
+		})
+		pb := &dto.Metric{}
+		if err := (<-ch).Write(pb); err != nil {
+			t.Fatalf("Unable to write metric: %v", err)
+		}
+		return pb.GetGauge().GetValue()
+	}
+
+	// The circuit opens after two failures.
+	for i := 0; i < 4; i++ {
+		if v := poll(); v != 0 {
+			t.Fatalf("Expected up=0, got %v", v)
+		}
+	}
+	if polls != 2 {
+		t.Fatalf("Expected the server to be skipped once the circuit opened, polled %d times", polls)
+	}
+	if len(outcomes) != 4 || outcomes[1].Error() != "fail" || outcomes[2] != errCircuitOpen {
+		t.Fatalf("Expected the failed and skipped polls to be reported, got %v", outcomes)
+	}
+
+	// After the cooldown a single poll is let through.
+	time.Sleep(250 * time.Millisecond)
+	poll()
+	poll()
+	if polls != 3 {
+		t.Fatalf("Expected a single poll after the cooldown, polled %d times", polls)
+	}
+}
+
+func TestCollectWithContextDeadline(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a hung monitor port.
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	coll := NewCollector(CoreSystem, "connz", "", servers).(ContextCollector)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	ch := make(chan prometheus.Metric, 16)
+	start := time.Now()
+	coll.CollectWithContext(ctx, ch)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Collection was not bounded by the context deadline: %v", elapsed)
+	}
+	// Only up=0 and the response size counter are expected from a hung server.
+	if len(ch) != 2 {
+		t.Fatalf("Expected only the poll metrics from a hung server, got %d", len(ch))
+	}
+	pb := &dto.Metric{}
+	if err := (<-ch).Write(pb); err != nil {
+		t.Fatalf("Unable to write metric: %v", err)
+	}
+	if v := pb.GetGauge().GetValue(); v != 0 {
+		t.Fatalf("Expected up=0, got %v", v)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprintf(w, `{"server_id":"id","connections":%d}`, 100)
+	}))
+	defer ts.Close()
+
+	opts := &CollectorOptions{MaxResponseBytes: 16, Retry: RetryPolicy{MaxAttempts: 3}}
+	var resp map[string]interface{}
+	err := getMetricURL(context.Background(), http.DefaultClient, opts, ts.URL, nil, &resp)
+	if err != errResponseTooLarge {
+		t.Fatalf("Expected errResponseTooLarge, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected an oversized response not to be retried, got %d requests", n)
+	}
+
+	opts.MaxResponseBytes = 1024
+	if err := getMetricURL(context.Background(), http.DefaultClient, opts, ts.URL, nil, &resp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp["server_id"] != "id" {
+		t.Fatalf("Unexpected response: %v", resp)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			fmt.Fprint(w, `{"compressed":false}`)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprint(gz, `{"compressed":true}`)
+		gz.Close()
+	}))
+	defer ts.Close()
+
+	for _, disabled := range []bool{false, true} {
+		opts := &CollectorOptions{DisableCompression: disabled}
+		var resp map[string]interface{}
+		if err := getMetricURL(context.Background(), newHTTPClient(opts), opts, ts.URL, nil, &resp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp["compressed"] != !disabled {
+			t.Fatalf("Expected compressed=%v with compression disabled=%v, got %v",
+				!disabled, disabled, resp["compressed"])
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	opts := &CollectorOptions{RequestTimeout: 100 * time.Millisecond}
+	var resp map[string]interface{}
+	start := time.Now()
+	if err := getMetricURL(context.Background(), newHTTPClient(opts), opts, ts.URL, nil, &resp); err == nil {
+		t.Fatalf("Expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Request was not bounded by the timeout: %v", elapsed)
+	}
+}
+
+func TestMonitorBasicAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"server_id":"%s:%s"}`, user, pass)
+	}))
+	defer ts.Close()
+
+	check := func(url, expected string) {
+		t.Helper()
+		opts := &CollectorOptions{MonitorUser: "global", MonitorPassword: "secret"}
+		id, err := GetServerIDFromVarzWithOptions(context.Background(), url, opts)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if id != expected {
+			t.Fatalf("Expected credentials %q, got %q", expected, id)
+		}
+	}
+	check(ts.URL, "global:secret")
+	// Credentials in the URL take precedence.
+	check(strings.Replace(ts.URL, "http://", "http://user:pass@", 1), "user:pass")
+}
+
+func TestMonitorBearerToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"server_id":%q}`, r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	opts := &CollectorOptions{BearerTokenFile: f.Name()}
+	for _, token := range []string{"first", "rotated"} {
+		if err := ioutil.WriteFile(f.Name(), []byte(token+"\n"), 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+		id, err := GetServerIDFromVarzWithOptions(context.Background(), ts.URL, opts)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if id != "Bearer "+token {
+			t.Fatalf("Expected the bearer token %q, got %q", token, id)
+		}
+	}
+
+	opts.BearerTokenFile = "missing"
+	if _, err := GetServerIDFromVarzWithOptions(context.Background(), ts.URL, opts); err == nil {
+		t.Fatalf("Expected an error reading a missing token file")
+	}
+}
+
+func TestServerHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"org":%q,"host":%q}`, r.Header.Get("X-Scope-OrgID"), r.Host)
+	}))
+	defer ts.Close()
+
+	headers := http.Header{}
+	headers.Set("X-Scope-OrgID", "tenant")
+	headers.Set("Host", "nats.internal")
+
+	opts := &CollectorOptions{}
+	var resp map[string]interface{}
+	if err := getMetricURL(context.Background(), http.DefaultClient, opts, ts.URL, headers, &resp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp["org"] != "tenant" || resp["host"] != "nats.internal" {
+		t.Fatalf("Expected the server headers to be sent, got %v", resp)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"server_id":%q}`, r.URL.Host)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	opts := &CollectorOptions{Proxy: proxyURL}
+	id, err := GetServerIDFromVarzWithOptions(context.Background(), "http://nats.invalid:8222", opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id != "nats.invalid:8222" {
+		t.Fatalf("Expected the request to go through the proxy, got %q", id)
+	}
+}
+
+// recordingLogger records the statements logged.
+type recordingLogger struct {
+	sync.Mutex
+	logs []string
+}
+
+func (l *recordingLogger) log(format string, v ...interface{}) {
+	l.Lock()
+	l.logs = append(l.logs, fmt.Sprintf(format, v...))
+	l.Unlock()
+}
+
+func (l *recordingLogger) Noticef(format string, v ...interface{}) { l.log(format, v...) }
+func (l *recordingLogger) Fatalf(format string, v ...interface{})  { l.log(format, v...) }
+func (l *recordingLogger) Errorf(format string, v ...interface{})  { l.log(format, v...) }
+func (l *recordingLogger) Debugf(format string, v ...interface{})  { l.log(format, v...) }
+func (l *recordingLogger) Tracef(format string, v ...interface{})  { l.log(format, v...) }
+
+// errorLogger records the errors logged.
+type errorLogger struct {
+	recordingLogger
+	errors []string
+}
+
+func (l *errorLogger) Errorf(format string, v ...interface{}) {
+	l.Lock()
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+	l.Unlock()
+}
+
+func TestRetryLogsExhausted(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"server_id":"myid"}`)
+	}))
+	defer ts.Close()
+
+	l := &errorLogger{}
+	opts := &CollectorOptions{Logger: l, Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}}
+	if _, err := GetServerIDFromVarzWithOptions(context.Background(), ts.URL, opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(l.errors) != 0 {
+		t.Fatalf("Expected the failed attempts not to be logged as errors, got %q", l.errors)
+	}
+
+	opts.Retry.MaxAttempts = 2
+	if _, err := GetServerIDFromVarzWithOptions(context.Background(), ts.URL, opts); err == nil {
+		t.Fatalf("Expected an error once the attempts are exhausted")
+	}
+	if len(l.errors) != 1 {
+		t.Fatalf("Expected a single error once the attempts are exhausted, got %q", l.errors)
+	}
+}
+
+func TestCollectorLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"in_msgs":5}`)
+	}))
+	defer ts.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	l := &recordingLogger{}
+	opts := &CollectorOptions{Logger: l}
+	if _, err := GetServerIDFromVarzWithOptions(context.Background(), ts.URL, opts); err == nil {
+		t.Fatalf("Expected an error without a server id")
+	}
+
+	servers := []*CollectedServer{{ID: "down", URL: down.URL + "/connz"}}
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(NewCollectorWithOptions(CoreSystem, "connz", "", servers, opts)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	servers = []*CollectedServer{{ID: "up", URL: ts.URL}}
+	if err := reg.Register(NewCollectorWithOptions(CoreSystem, "varz", "", servers, opts)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	l.Lock()
+	defer l.Unlock()
+	if len(l.logs) == 0 || !strings.Contains(strings.Join(l.logs, "\n"), "ignoring server down") {
+		t.Fatalf("Expected the poll to be logged by the logger, got %q", l.logs)
+	}
+	if !strings.Contains(strings.Join(l.logs, "\n"), "Created metric: gnatsd, varz, in_msgs") {
+		t.Fatalf("Expected the metrics created to be logged by the logger, got %q", l.logs)
+	}
+}
+
+func ExampleNewCollectorWithOptions() {
+	servers := []*CollectedServer{{ID: "nats-0", URL: "http://localhost:8222/varz"}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", servers, &CollectorOptions{
+		CollectTimeout: 5 * time.Second,
+	}))
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+}
+
+func TestClusterLabel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"in_msgs":5}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{
+		{ID: "east1", URL: ts.URL, Cluster: "east"},
+		{ID: "single", URL: ts.URL},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(CoreSystem, "varz", "", servers))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	clusters := make(map[string]string)
+	for _, mf := range families {
+		if mf.GetName() != "gnatsd_varz_in_msgs" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			labels := make(map[string]string)
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if _, ok := labels["cluster"]; !ok {
+				t.Fatalf("Expected a cluster label, got %v", labels)
+			}
+			clusters[labels["server_id"]] = labels["cluster"]
+		}
+	}
+	if clusters["east1"] != "east" || clusters["single"] != "" || len(clusters) != 2 {
+		t.Fatalf("Unexpected cluster labels: %v", clusters)
+	}
+}
+
+func TestServerLabels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"in_msgs":5}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{
+		{ID: "edge1", URL: ts.URL, Labels: map[string]string{"region": "eu-west-1", "role": "edge"}},
+		{ID: "core1", URL: ts.URL, Labels: map[string]string{"region": "us-east-1"}},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(CoreSystem, "varz", "", servers))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	found := 0
+	for _, mf := range families {
+		if mf.GetName() != "gnatsd_varz_in_msgs" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			labels := make(map[string]string)
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			switch labels["server_id"] {
+			case "edge1":
+				if labels["region"] != "eu-west-1" || labels["role"] != "edge" {
+					t.Fatalf("Unexpected labels: %v", labels)
+				}
+			case "core1":
+				if v, ok := labels["role"]; labels["region"] != "us-east-1" || !ok || v != "" {
+					t.Fatalf("Unexpected labels: %v", labels)
+				}
+			}
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", found)
+	}
+
+	if err := CheckServerLabels(map[string]string{"server_id": "x"}); err == nil {
+		t.Fatalf("Expected an error for a reserved label")
+	}
+	if err := CheckServerLabels(map[string]string{"not-valid": "x"}); err == nil {
+		t.Fatalf("Expected an error for an invalid label")
+	}
+}
+
+func TestServerNameLabel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"server_name":"nats-0","in_msgs":5}`)
+	}))
+	defer ts.Close()
+
+	for mode, expected := range map[string]map[string]string{
+		ServerNameLabelAdd:     {"server_id": "NUID", "server_name": "nats-0"},
+		ServerNameLabelReplace: {"server_id": "nats-0"},
+	} {
+		servers := []*CollectedServer{{ID: "NUID", URL: ts.URL}}
+		opts := &CollectorOptions{ServerNameLabel: mode}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", servers, opts))
+
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		found := false
+		for _, mf := range families {
+			if mf.GetName() != "gnatsd_varz_in_msgs" {
+				continue
+			}
+			found = true
+			labels := make(map[string]string)
+			for _, lp := range mf.Metric[0].Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if len(labels) != len(expected) {
+				t.Fatalf("Expected labels %v with %q, got %v", expected, mode, labels)
+			}
+			for k, v := range expected {
+				if labels[k] != v {
+					t.Fatalf("Expected labels %v with %q, got %v", expected, mode, labels)
+				}
+			}
+		}
+		if !found {
+			t.Fatalf("Expected gnatsd_varz_in_msgs with %q", mode)
+		}
+	}
+}
+
+func TestServerURLLabel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"in_msgs":5}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "NUID", URL: ts.URL}}
+	opts := &CollectorOptions{UseServerURLLabel: true}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", servers, opts))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	u, _ := url.Parse(ts.URL)
+	found := false
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			for _, lp := range m.Label {
+				if lp.GetName() == "server_id" && lp.GetValue() != u.Host {
+					t.Fatalf("Expected server_id %q on %s, got %q", u.Host, mf.GetName(), lp.GetValue())
+				}
+			}
+		}
+		found = found || mf.GetName() == "gnatsd_varz_in_msgs"
+	}
+	if !found {
+		t.Fatalf("Expected gnatsd_varz_in_msgs")
+	}
+}
+
+func TestInternalServerIDLabel(t *testing.T) {
+	var up int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/varz" {
+			if atomic.LoadInt32(&up) == 0 {
+				http.Error(w, "starting", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"server_id":"NUID"}`)
+			return
+		}
+		fmt.Fprint(w, `{"num_connections":1}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "nats-0", URL: ts.URL}}
+	opts := &CollectorOptions{InternalServerIDLabel: true, ServerNameRefresh: 10 * time.Millisecond}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "connz", "", servers, opts))
+
+	serverID := func() string {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, mf := range families {
+			if mf.GetName() != "gnatsd_connz_num_connections" {
+				continue
+			}
+			for _, lp := range mf.Metric[0].Label {
+				if lp.GetName() == "server_id" {
+					return lp.GetValue()
+				}
+			}
+		}
+		t.Fatalf("Expected gnatsd_connz_num_connections")
+		return ""
+	}
+
+	// Until the server reports its ID, the ID given to it is used.
+	for i := 0; i < 3; i++ {
+		if id := serverID(); id != "nats-0" {
+			t.Fatalf("Expected server_id %q, got %q", "nats-0", id)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	atomic.StoreInt32(&up, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for serverID() != "NUID" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected server_id %q once the server responds", "NUID")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRestarts(t *testing.T) {
+	var mu sync.Mutex
+	id, start := "NUID1", "2019-01-01T00:00:00Z"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"server_id":%q,"start":%q,"in_msgs":5}`, id, start)
+	}))
+	defer ts.Close()
+
+	// The credentials of the URL do not label the restarts.
+	servers := []*CollectedServer{{ID: "nats-0", URL: strings.Replace(ts.URL, "://", "://colin:secret@", 1)}}
+	opts := &CollectorOptions{InternalServerIDLabel: true, ServerNameRefresh: time.Hour}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", servers, opts))
+
+	gather := func() (restarts float64, serverID string) {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		restarts = -1
+		for _, mf := range families {
+			switch mf.GetName() {
+			case "nats_server_restarts_total":
+				if url := mf.Metric[0].Label[0].GetValue(); url != ts.URL {
+					t.Fatalf("Expected the restarts of %s, got %s", ts.URL, url)
+				}
+				restarts = mf.Metric[0].Counter.GetValue()
+			case "gnatsd_varz_in_msgs":
+				serverID = mf.Metric[0].Label[0].GetValue()
+			}
+		}
+		return restarts, serverID
+	}
+	if restarts, _ := gather(); restarts != 0 {
+		t.Fatalf("Expected no restarts, got %v", restarts)
+	}
+
+	// A restart is detected by the start time changing, the server_id
+	// being the same...
+	mu.Lock()
+	start = "2019-01-02T00:00:00Z"
+	mu.Unlock()
+	if restarts, _ := gather(); restarts != 1 {
+		t.Fatalf("Expected a restart, got %v", restarts)
+	}
+
+	// ...or by the server_id changing, which labels the metrics from the
+	// next poll on.
+	mu.Lock()
+	id, start = "NUID2", "2019-01-03T00:00:00Z"
+	mu.Unlock()
+	if restarts, _ := gather(); restarts != 2 {
+		t.Fatalf("Expected two restarts, got %v", restarts)
+	}
+	if restarts, serverID := gather(); restarts != 2 || serverID != "NUID2" {
+		t.Fatalf("Expected two restarts of NUID2, got %v of %q", restarts, serverID)
+	}
+}
+
+func TestMetricRenames(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"mem":1024,"in_msgs":5}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	opts := &CollectorOptions{MetricRenames: map[string]MetricRename{
+		"varz.mem": {Name: "gnatsd_varz_memory_bytes", Help: "Resident memory in bytes"},
+	}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", servers, opts))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		names[mf.GetName()] = mf
+	}
+	mf, ok := names["gnatsd_varz_memory_bytes"]
+	if !ok || mf.GetHelp() != "Resident memory in bytes" || mf.Metric[0].GetGauge().GetValue() != 1024 {
+		t.Fatalf("Expected the renamed metric, got %v", mf)
+	}
+	if _, ok := names["gnatsd_varz_mem"]; ok {
+		t.Fatalf("Expected gnatsd_varz_mem to be renamed")
+	}
+	if _, ok := names["gnatsd_varz_in_msgs"]; !ok {
+		t.Fatalf("Expected gnatsd_varz_in_msgs to keep its name")
+	}
+}
+
+func TestUnitMetricNames(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"mem":1024,"cpu":50,"uptime":"1d2h3m4s","start":"2019-05-01T10:00:00Z",`+
+			`"ping_interval":120000000000,"in_msgs":5}`)
+	}))
+	defer ts.Close()
+
+	gather := func(opts *CollectorOptions) map[string]float64 {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", []*CollectedServer{{ID: "id", URL: ts.URL}}, opts))
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		values := make(map[string]float64)
+		for _, mf := range families {
+			values[mf.GetName()] = mf.Metric[0].GetGauge().GetValue()
+		}
+		return values
+	}
+
+	values := gather(&CollectorOptions{UnitMetricNames: true})
+	for name, expected := range map[string]float64{
+		"gnatsd_varz_mem_bytes":             1024,
+		"gnatsd_varz_cpu_ratio":             0.5,
+		"gnatsd_varz_uptime_seconds":        93784,
+		"gnatsd_varz_start_time_seconds":    1556704800,
+		"gnatsd_varz_ping_interval_seconds": 120,
+		"gnatsd_varz_in_msgs":               5,
+	} {
+		if v, ok := values[name]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v", name, expected, values)
+		}
+	}
+	if _, ok := values["gnatsd_varz_mem"]; ok {
+		t.Fatalf("Expected no legacy metric names, got %v", values)
+	}
+
+	values = gather(&CollectorOptions{UnitMetricNames: true, LegacyMetricNames: true})
+	if values["gnatsd_varz_mem"] != 1024 || values["gnatsd_varz_cpu"] != 50 || values["gnatsd_varz_mem_bytes"] != 1024 {
+		t.Fatalf("Expected both the legacy and unit metric names, got %v", values)
+	}
+}
+
+func TestEndpointParams(t *testing.T) {
+	var mu sync.Mutex
+	queries := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries[r.URL.Path] = r.URL.RawQuery
+		mu.Unlock()
+		fmt.Fprint(w, `{"num_subscriptions":1,"num_connections":1}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	opts := &CollectorOptions{EndpointParams: map[string]url.Values{
+		"connz": {"limit": {"4096"}, "sort": {"pending"}},
+		"subsz": {"subs": {"true"}},
+	}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "connz", "", servers, opts))
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "subsz", "", servers, opts))
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "varz", "", servers, opts))
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for path, expected := range map[string]string{
+		"/connz": "limit=4096&sort=pending",
+		"/subsz": "subs=true",
+		"/varz":  "",
+	} {
+		if q, ok := queries[path]; !ok || q != expected {
+			t.Fatalf("Expected %s to be polled with %q, got %v", path, expected, queries)
+		}
+	}
+}
+
+func TestConnzPagination(t *testing.T) {
+	const total, limit = 5, 2
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		var conns []string
+		for i := offset; i < total && i < offset+limit; i++ {
+			conns = append(conns, `{"pending_bytes":10}`)
+		}
+		fmt.Fprintf(w, `{"num_connections":%d,"total":%d,"offset":%d,"limit":%d,"connections":[%s]}`,
+			len(conns), total, offset, limit, strings.Join(conns, ","))
+	}))
+	defer ts.Close()
+
+	gather := func(opts *CollectorOptions) map[string]float64 {
+		servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(NewCollectorWithOptions(CoreSystem, "connz", "", servers, opts))
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		values := make(map[string]float64)
+		for _, mf := range families {
+			values[mf.GetName()] = mf.Metric[0].GetGauge().GetValue()
+		}
+		return values
+	}
+
+	values := gather(&CollectorOptions{})
+	if values["gnatsd_connz_num_connections"] != total || values["gnatsd_connz_pending_bytes"] != 10*total {
+		t.Fatalf("Expected all the connections, got %v", values)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("Expected 3 pages to be requested, got %d", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	values = gather(&CollectorOptions{MaxPages: 2})
+	if values["gnatsd_connz_num_connections"] != 2*limit {
+		t.Fatalf("Expected the connections of 2 pages, got %v", values)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("Expected 2 pages to be requested, got %d", n)
+	}
+}
+
+func TestConnzAccounts(t *testing.T) {
+	conns := map[string][]string{
+		"":  {`{"pending_bytes":1,"in_msgs":1}`, `{"pending_bytes":2,"in_msgs":2}`, `{"pending_bytes":4,"in_msgs":4}`},
+		"A": {`{"pending_bytes":1,"in_msgs":1,"out_msgs":3,"in_bytes":10,"out_bytes":30}`},
+		"B": {`{"pending_bytes":2,"in_msgs":2}`, `{"pending_bytes":4,"in_msgs":4}`},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := conns[r.URL.Query().Get("acc")]
+		if !ok {
+			http.Error(w, "unknown account", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"num_connections":%d,"total":%d,"connections":[%s]}`,
+			len(c), len(c), strings.Join(c, ","))
+	}))
+	defer ts.Close()
+
+	// The accounts whose connections cannot be listed are left out,
+	// without failing the poll.
+	var pollErr error
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	opts := &CollectorOptions{
+		ConnzAccounts: []string{"A", "C", "B"},
+		OnPoll:        func(serverID string, err error) { pollErr = err },
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "connz", "", servers, opts))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pollErr != nil {
+		t.Fatalf("Expected the poll to succeed, got %v", pollErr)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			name := mf.GetName()
+			for _, lp := range m.Label {
+				if lp.GetName() == "account" {
+					name += "/" + lp.GetValue()
+				}
+			}
+			values[name] = m.GetGauge().GetValue()
+		}
+	}
+	for name, expected := range map[string]float64{
+		"gnatsd_connz_num_connections":           3,
+		"gnatsd_connz_pending_bytes":             7,
+		"gnatsd_connz_account_num_connections/A": 1,
+		"gnatsd_connz_account_num_connections/B": 2,
+		"gnatsd_connz_account_pending_bytes/B":   6,
+		"gnatsd_connz_account_in_msgs/B":         6,
+		"gnatsd_connz_account_out_msgs/A":        3,
+		"gnatsd_connz_account_in_bytes/A":        10,
+		"gnatsd_connz_account_out_bytes/A":       30,
+	} {
+		if values[name] != expected {
+			t.Fatalf("Expected %s to be %v, got %v", name, expected, values)
+		}
+	}
+	if _, ok := values["gnatsd_connz_account_num_connections/C"]; ok {
+		t.Fatalf("Expected no metrics of the account that failed, got %v", values)
+	}
+}
+
+func TestConnzAccountsFilter(t *testing.T) {
+	for _, test := range []struct {
+		accounts, connzAccounts, expected []string
+	}{
+		{nil, nil, nil},
+		{[]string{"A", "B"}, nil, nil},
+		{nil, []string{"A", "C"}, []string{"A", "C"}},
+		{[]string{"A", "B"}, []string{"A", "C"}, []string{"A"}},
+	} {
+		opts := &CollectorOptions{Accounts: test.accounts, ConnzAccounts: test.connzAccounts}
+		if accounts := connzAccounts(opts); !reflect.DeepEqual(accounts, test.expected) {
+			t.Fatalf("Expected the accounts %v of %v and %v, got %v",
+				test.expected, test.accounts, test.connzAccounts, accounts)
+		}
+	}
+}
+
+func TestSubszPagination(t *testing.T) {
+	const total, limit = 5, 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		var subs []string
+		for i := offset; i < total && i < offset+limit; i++ {
+			subs = append(subs, fmt.Sprintf(`{"subject":"foo.%d"}`, i))
+		}
+		fmt.Fprintf(w, `{"num_subscriptions":%d,"total":%d,"offset":%d,"limit":%d,"subscriptions_list":[%s]}`,
+			total, total, offset, limit, strings.Join(subs, ","))
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	opts := &CollectorOptions{EndpointParams: map[string]url.Values{"subsz": {"subs": {"1"}}}}
+	nc := newNatsCollector(CoreSystem, "subsz", servers, opts).(*NATSCollector)
+	ch := make(chan prometheus.Metric, 10)
+	resps := nc.makeRequests(context.Background(), ch)
+	subs, _ := resps["id"]["subscriptions_list"].([]interface{})
+	if len(subs) != total {
+		t.Fatalf("Expected all the subscriptions to be listed, got %v", subs)
+	}
+	if subject := subs[total-1].(map[string]interface{})["subject"]; subject != "foo.4" {
+		t.Fatalf("Unexpected last subscription: %v", subject)
+	}
+
+	opts.MaxPages = 2
+	resps = nc.makeRequests(context.Background(), ch)
+	if subs, _ := resps["id"]["subscriptions_list"].([]interface{}); len(subs) != 2*limit {
+		t.Fatalf("Expected the subscriptions of 2 pages, got %v", subs)
+	}
+}
+
+func TestSubszSubjects(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"num_subscriptions":5,"total":5,"subscriptions_list":[
+			{"subject":"orders.1.eu.new"},{"subject":"orders.2.eu.paid.late"},
+			{"subject":"orders.3.us.new"},{"subject":"users"},{"subject":"users"}]}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	opts := &CollectorOptions{
+		EndpointParams:      map[string]url.Values{"subsz": {"subs": {"1"}}},
+		SubjectAggregations: []string{"orders.*.eu.>", "orders.>"},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "subsz", "", servers, opts))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counts := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() != "gnatsd_subsz_subject_subscriptions" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			for _, lp := range m.Label {
+				if lp.GetName() == "subject" {
+					counts[lp.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	expected := map[string]float64{"orders.*.eu.>": 2, "orders.>": 1, "other": 2}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("Expected the subscriptions %v, got %v", expected, counts)
+	}
+
+	for pattern, valid := range map[string]bool{
+		"orders.*.eu.>": true,
+		"orders.>.eu":   false,
+		"orders..eu":    false,
+		"orders.eu*":    false,
+	} {
+		if err := CheckSubjectAggregations([]string{pattern}); (err == nil) != valid {
+			t.Fatalf("Unexpected validity of %q: %v", pattern, err)
+		}
+	}
+}
+
+func TestJszKVBuckets(t *testing.T) {
+	var mu sync.Mutex
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		query = r.URL.RawQuery
+		mu.Unlock()
+		fmt.Fprint(w, `{"server_id":"NA","account_details":[{"name":"$G","stream_detail":[
+			{"name":"KV_sessions","config":{"max_msgs_per_subject":5,"max_age":60000000000},
+			 "state":{"messages":12,"bytes":2048,"num_subjects":4}},
+			{"name":"ORDERS","config":{"max_msgs_per_subject":-1},"state":{"messages":100}}]}]}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "jsz", "", servers, nil))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mu.Lock()
+	if query != "accounts=true&streams=true&config=true" {
+		t.Fatalf("Expected the stream configurations to be requested, got %q", query)
+	}
+	mu.Unlock()
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "bucket" && l.GetValue() != "sessions" {
+					t.Fatalf("Unexpected bucket %q", l.GetValue())
+				}
+			}
+			values[mf.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	for name, expected := range map[string]float64{
+		"gnatsd_kv_bucket_values":      12,
+		"gnatsd_kv_bucket_keys":        4,
+		"gnatsd_kv_bucket_bytes":       2048,
+		"gnatsd_kv_bucket_history":     5,
+		"gnatsd_kv_bucket_ttl_seconds": 60,
+	} {
+		if v, ok := values[name]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v (found: %v)", name, expected, v, ok)
+		}
+	}
+}
+
+func TestJszAccounts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"server_id":"NA","account_details":[
+			{"name":"A","stream_detail":[{"name":"KV_a","config":{},"state":{"messages":1}}]},
+			{"name":"B","stream_detail":[{"name":"KV_b","config":{},"state":{"messages":2}}]}]}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	opts := &CollectorOptions{Accounts: []string{"B"}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "jsz", "", servers, opts))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "gnatsd_kv_bucket_values" {
+			continue
+		}
+		if len(mf.Metric) != 1 || mf.Metric[0].GetGauge().GetValue() != 2 {
+			t.Fatalf("Expected the bucket of account B alone, got %v", mf.Metric)
+		}
+		return
+	}
+	t.Fatalf("Expected gnatsd_kv_bucket_values")
+}
+
+func TestJszObjectStores(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"server_id":"NA","account_details":[{"name":"$G","stream_detail":[
+			{"name":"OBJ_images","config":{"max_bytes":1073741824},"state":{"messages":9,"bytes":524288}},
+			{"name":"KV_images","config":{},"state":{}}]}]}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "jsz", "", servers, nil))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		values[mf.GetName()] = mf.Metric[0].GetGauge().GetValue()
+	}
+	for name, expected := range map[string]float64{
+		"gnatsd_obj_bucket_bytes":     524288,
+		"gnatsd_obj_bucket_chunks":    9,
+		"gnatsd_obj_bucket_max_bytes": 1073741824,
+		"gnatsd_kv_bucket_bytes":      0,
+	} {
+		if v, ok := values[name]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v (found: %v)", name, expected, v, ok)
+		}
+	}
+}
+
+func TestJszAPIStats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"server_id":"NA","api":{"total":120,"errors":3,"inflight":2},
+			"meta_cluster":{"name":"east","leader":"n1","cluster_size":3,"pending":7}}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "jsz", "", servers, nil))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		m := mf.Metric[0]
+		if m.Counter != nil {
+			values[mf.GetName()] = m.GetCounter().GetValue()
+		} else {
+			values[mf.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	for name, expected := range map[string]float64{
+		"gnatsd_jetstream_api_requests": 120,
+		"gnatsd_jetstream_api_errors":   3,
+		"gnatsd_jetstream_api_inflight": 2,
+		"gnatsd_jetstream_meta_pending": 7,
+	} {
+		if v, ok := values[name]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v (found: %v)", name, expected, v, ok)
+		}
+	}
+}
+
+func TestJszRaftGroups(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"server_id":"N1","account_details":[{"name":"$G","stream_detail":[
+			{"name":"ORDERS","cluster":{"leader":"n1","replicas":[
+				{"name":"n2","current":true},
+				{"name":"n3","current":false,"offline":true,"lag":40}]}}]}]}`)
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"server_id":"N2","account_details":[{"name":"$G","stream_detail":[
+			{"name":"ORDERS","cluster":{"leader":"n1","replicas":[
+				{"name":"n1","current":true},{"name":"n3","current":false}]}}]}]}`)
+	}))
+	defer follower.Close()
+
+	servers := []*CollectedServer{{ID: "n1", URL: leader.URL}, {ID: "n2", URL: follower.URL}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "jsz", "", servers, nil))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			var server, peer string
+			for _, l := range m.Label {
+				switch l.GetName() {
+				case "server_id":
+					server = l.GetValue()
+				case "peer":
+					peer = "/" + l.GetValue()
+				}
+			}
+			values[mf.GetName()+"/"+server+peer] = m.GetGauge().GetValue()
+		}
+	}
+	for key, expected := range map[string]float64{
+		"gnatsd_jetstream_stream_leader/n1":         1,
+		"gnatsd_jetstream_stream_leader/n2":         0,
+		"gnatsd_jetstream_stream_peers/n1":          3,
+		"gnatsd_jetstream_stream_peers/n2":          3,
+		"gnatsd_jetstream_stream_peers_lagging/n1":  1,
+		"gnatsd_jetstream_stream_peers_offline/n1":  1,
+		"gnatsd_jetstream_stream_replica_lag/n1/n2": 0,
+		"gnatsd_jetstream_stream_replica_lag/n1/n3": 40,
+	} {
+		if v, ok := values[key]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v (found: %v)", key, expected, v, ok)
+		}
+	}
+	// Followers do not know how far behind the other peers are.
+	if _, ok := values["gnatsd_jetstream_stream_peers_lagging/n2"]; ok {
+		t.Fatalf("Unexpected lagging peers reported by a follower")
+	}
+}
+
+func TestIpqueuesz(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Routes Send Queue":{"pending":12,"in_progress":3},"JS API Queue":{"pending":0}}`)
+	}))
+	defer ts.Close()
+
+	servers := []*CollectedServer{{ID: "id", URL: ts.URL}}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollectorWithOptions(CoreSystem, "ipqueuesz", "", servers, nil))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "queue" {
+					values[mf.GetName()+"/"+l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	for key, expected := range map[string]float64{
+		"gnatsd_ipqueuesz_pending/Routes Send Queue":     12,
+		"gnatsd_ipqueuesz_in_progress/Routes Send Queue": 3,
+		"gnatsd_ipqueuesz_pending/JS API Queue":          0,
+	} {
+		if v, ok := values[key]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v (found: %v)", key, expected, v, ok)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewBuildInfoCollector("1.2.3", "abc123"))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "nats_exporter_build_info" {
+		t.Fatalf("Expected the build info metric, got %v", families)
+	}
+	m := families[0].Metric[0]
+	labels := make(map[string]string)
+	for _, lp := range m.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	if m.GetGauge().GetValue() != 1 || labels["version"] != "1.2.3" || labels["commit"] != "abc123" ||
+		labels["go_version"] != runtime.Version() {
+		t.Fatalf("Unexpected build info metric: %v", m)
+	}
+}
+
 func TestRegister(t *testing.T) {
 	cs := &CollectedServer{ID: "myid", URL: fmt.Sprintf("http://localhost:%d", pet.MonitorPort)}
 	servers := make([]*CollectedServer, 0)
@@ -382,10 +1702,13 @@ func TestStreamingMetrics(t *testing.T) {
 	cases := map[string]float64{
 		"test_chan_bytes_total":        240,
 		"test_chan_msgs_total":         10,
+		"test_chan_first_seq":          1,
 		"test_chan_last_seq":           10,
+		"test_chan_subscriptions":      1,
 		"test_chan_subs_last_sent":     10,
 		"test_chan_subs_pending_count": 0,
 		"test_chan_subs_max_inflight":  1024,
+		"test_chan_subs_lag":           0,
 	}
 
 	verifyCollector(StreamingSystem, url, "channelsz", cases, t)
@@ -405,6 +1728,21 @@ func TestStreamingMetrics(t *testing.T) {
 	}
 
 	verifyCollector(StreamingSystem, url, "serverz", cases, t)
+
+	cases = map[string]float64{
+		"test_client_subscriptions": 1,
+		"test_client_pending_count": 0,
+	}
+
+	verifyCollector(StreamingSystem, url, "clientsz", cases, t)
+
+	labelValues, err := getLabelValues(StreamingSystem, url, "clientsz", []string{"nss_client_subscriptions"})
+	if err != nil {
+		t.Fatalf("Unexpected error getting labels for nss_client_subscriptions metric: %v", err)
+	}
+	if labelMaps := labelValues["nss_client_subscriptions"]; len(labelMaps) != 1 || labelMaps[0]["client_id"] != stanClientName {
+		t.Fatalf("Expected the subscriptions of client %s, got %v", stanClientName, labelMaps)
+	}
 }
 
 func TestStreamingMetricsCustomPrefix(t *testing.T) {
@@ -492,6 +1830,160 @@ func TestStreamingServerInfoMetricLabels(t *testing.T) {
 	}
 }
 
+func TestStreamingFTState(t *testing.T) {
+	for _, c := range []struct {
+		state, role, mode, ftRole string
+	}{
+		{"STANDALONE", "", "standalone", ""},
+		{"FT_ACTIVE", "", "ft", "active"},
+		{"FT_STANDBY", "", "ft", "standby"},
+		{"CLUSTERED", "Leader", "clustered", "leader"},
+		{"FAILED", "", "failed", ""},
+	} {
+		if mode, role := streamingFTState(c.state, c.role); mode != c.mode || role != c.ftRole {
+			t.Fatalf("Expected %s/%s of %s, got %s/%s", c.mode, c.ftRole, c.state, mode, role)
+		}
+	}
+
+	s := pet.RunStreamingServer()
+	defer s.Shutdown()
+
+	url := fmt.Sprintf("http://localhost:%d/", pet.MonitorPort)
+	labelValues, err := getLabelValues(StreamingSystem, url, "serverz", []string{"nss_server_ft_info"})
+	if err != nil {
+		t.Fatalf("Unexpected error getting labels for nss_server_ft_info metric: %v", err)
+	}
+	labelMaps := labelValues["nss_server_ft_info"]
+	if len(labelMaps) != 1 || labelMaps[0]["mode"] != "standalone" || labelMaps[0]["cluster_id"] != stanClusterName {
+		t.Fatalf("Expected a standalone server, got %v", labelMaps)
+	}
+}
+
+func TestStreamingSubscriptionLag(t *testing.T) {
+	s := pet.RunStreamingServer()
+	defer s.Shutdown()
+
+	sc, err := stan.Connect(stanClusterName, stanClientName,
+		stan.NatsURL(fmt.Sprintf("nats://localhost:%d", pet.ClientPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	// The durable subscription is offline while messages are published.
+	sub, err := sc.Subscribe("foo", func(_ *stan.Msg) {}, stan.DurableName("lagging"))
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	if err := sub.Close(); err != nil {
+		t.Fatalf("Unexpected error closing the subscription: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := sc.Publish("foo", []byte("hello")); err != nil {
+			t.Fatalf("Unexpected error on publish: %v", err)
+		}
+	}
+
+	url := fmt.Sprintf("http://localhost:%d/", pet.MonitorPort)
+	coll := NewCollector(StreamingSystem, "channelsz", "", []*CollectedServer{{ID: "id", URL: url}})
+	ch := make(chan prometheus.Metric)
+	go func() {
+		coll.Collect(ch)
+		close(ch)
+	}()
+	lag := -1.0
+	for m := range ch {
+		if parseDesc(m.Desc().String()) != "nss_chan_subs_lag" {
+			continue
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("Unable to write metric: %v", err)
+		}
+		lag = pb.GetGauge().GetValue()
+	}
+	if lag != 5 {
+		t.Fatalf("Expected a lag of 5 messages, got %v", lag)
+	}
+}
+
+func TestStreamingStoreLimits(t *testing.T) {
+	s := pet.RunStreamingServer()
+	defer s.Shutdown()
+
+	sc, err := stan.Connect(stanClusterName, stanClientName,
+		stan.NatsURL(fmt.Sprintf("nats://localhost:%d", pet.ClientPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	if err := sc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error on publish: %v", err)
+	}
+
+	url := fmt.Sprintf("http://localhost:%d/", pet.MonitorPort)
+	coll := NewCollector(StreamingSystem, "storez", "", []*CollectedServer{{ID: "id", URL: url}})
+	ch := make(chan prometheus.Metric)
+	go func() {
+		coll.Collect(ch)
+		close(ch)
+	}()
+	values := make(map[string]float64)
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("Unable to write metric: %v", err)
+		}
+		values[parseDesc(m.Desc().String())] = pb.GetGauge().GetValue()
+	}
+
+	// The default limits of the store.
+	for name, expected := range map[string]float64{
+		"nss_store_max_channels":     100,
+		"nss_chan_max_msgs":          1000000,
+		"nss_chan_max_bytes":         1000000 * 1024,
+		"nss_chan_max_age_seconds":   0,
+		"nss_chan_max_subscriptions": 1000,
+	} {
+		if v, ok := values[name]; !ok || v != expected {
+			t.Fatalf("Expected %s to be %v, got %v (found: %v)", name, expected, v, ok)
+		}
+	}
+}
+
+func TestStreamingChannelLimits(t *testing.T) {
+	limits := &StoreLimits{
+		MaxChannels: 10,
+		ChannelLimits: ChannelLimits{
+			MaxMsgs:          100,
+			MaxBytes:         1000,
+			MaxAge:           time.Hour,
+			MaxSubscriptions: 10,
+		},
+		PerChannel: map[string]*ChannelLimits{
+			"foo.>":     {MaxMsgs: 50, MaxAge: -1},
+			"foo.*.baz": {MaxBytes: 500},
+			"foo.bar":   {MaxSubscriptions: -1},
+		},
+	}
+
+	for _, c := range []struct {
+		channel  string
+		expected ChannelLimits
+	}{
+		{"bar", ChannelLimits{MaxMsgs: 100, MaxBytes: 1000, MaxAge: time.Hour, MaxSubscriptions: 10}},
+		{"foo", ChannelLimits{MaxMsgs: 100, MaxBytes: 1000, MaxAge: time.Hour, MaxSubscriptions: 10}},
+		{"foo.bar", ChannelLimits{MaxMsgs: 50, MaxBytes: 1000, MaxSubscriptions: 0}},
+		{"foo.qux.baz", ChannelLimits{MaxMsgs: 50, MaxBytes: 500, MaxSubscriptions: 10}},
+		{"foo.qux", ChannelLimits{MaxMsgs: 50, MaxBytes: 1000, MaxSubscriptions: 10}},
+	} {
+		if got := limits.channelLimits(c.channel); got != c.expected {
+			t.Fatalf("Expected the limits of %s to be %+v, got %+v", c.channel, c.expected, got)
+		}
+	}
+}
+
 func TestStreamingSubscriptionsMetricLabels(t *testing.T) {
 	s := pet.RunStreamingServer()
 	defer s.Shutdown()
@@ -546,7 +2038,7 @@ func TestStreamingSubscriptionsMetricLabels(t *testing.T) {
 	url := fmt.Sprintf("http://localhost:%d/", pet.MonitorPort)
 
 	streamingSunscriptionMetrics := []string{"nss_chan_subs_last_sent",
-		"nss_chan_subs_pending_count", "nss_chan_subs_max_inflight"}
+		"nss_chan_subs_pending_count", "nss_chan_subs_max_inflight", "nss_chan_subs_lag"}
 	labelValues, err := getLabelValues(StreamingSystem, url, "channelsz", streamingSunscriptionMetrics)
 	if err != nil {
 		t.Fatalf("Unexpected error getting labels for nss_server_info metric: %v", err)
@@ -617,6 +2109,7 @@ func TestReplicatorMetrics(t *testing.T) {
 		"replicator_connector_connected":      1,
 		"replicator_connector_connects":       1,
 		"replicator_connector_disconnects":    0,
+		"replicator_connector_restarts":       0,
 		"replicator_connector_messages_in":    0,
 		"replicator_connector_messages_out":   0,
 		"replicator_connector_moving_average": 0,
@@ -15,7 +15,9 @@
 package collector
 
 import (
+	"context"
 	"net/http"
+	"net/url"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,17 +32,40 @@ type connzCollector struct {
 
 	httpClient *http.Client
 	servers    []*CollectedServer
+	opts       *CollectorOptions
+	polls      *pollMetrics
 
 	numConnections *prometheus.Desc
 	total          *prometheus.Desc
 	offset         *prometheus.Desc
 	limit          *prometheus.Desc
 	pendingBytes   *prometheus.Desc
+
+	// Of the connections of each account polled apart.
+	accountConnections  *prometheus.Desc
+	accountPendingBytes *prometheus.Desc
+	accountInMsgs       *prometheus.Desc
+	accountOutMsgs      *prometheus.Desc
+	accountInBytes      *prometheus.Desc
+	accountOutBytes     *prometheus.Desc
+}
+
+// newConnzAccountDesc returns the description of a metric of the
+// connections of an account.
+func newConnzAccountDesc(system, endpoint, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(system, endpoint, "account_"+name),
+		help,
+		[]string{"server_id", "account"},
+		nil,
+	)
 }
 
-func newConnzCollector(system, endpoint string, servers []*CollectedServer) prometheus.Collector {
+func newConnzCollector(system, endpoint string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	nc := &connzCollector{
-		httpClient: http.DefaultClient,
+		httpClient: newHTTPClient(opts),
+		opts:       opts,
+		polls:      newPollMetrics(system, endpoint),
 		numConnections: prometheus.NewDesc(
 			prometheus.BuildFQName(system, endpoint, "num_connections"),
 			"num_connections",
@@ -71,13 +96,26 @@ func newConnzCollector(system, endpoint string, servers []*CollectedServer) prom
 			[]string{"server_id"},
 			nil,
 		),
+		accountConnections: newConnzAccountDesc(system, endpoint, "num_connections",
+			"Number of connections of the account"),
+		accountPendingBytes: newConnzAccountDesc(system, endpoint, "pending_bytes",
+			"Bytes pending to the connections of the account"),
+		accountInMsgs: newConnzAccountDesc(system, endpoint, "in_msgs",
+			"Messages received from the open connections of the account"),
+		accountOutMsgs: newConnzAccountDesc(system, endpoint, "out_msgs",
+			"Messages sent to the open connections of the account"),
+		accountInBytes: newConnzAccountDesc(system, endpoint, "in_bytes",
+			"Bytes received from the open connections of the account"),
+		accountOutBytes: newConnzAccountDesc(system, endpoint, "out_bytes",
+			"Bytes sent to the open connections of the account"),
 	}
 
 	nc.servers = make([]*CollectedServer, len(servers))
 	for i, s := range servers {
 		nc.servers[i] = &CollectedServer{
-			ID:  s.ID,
-			URL: s.URL + "/connz",
+			ID:      s.ID,
+			URL:     endpointURL(s.URL, "connz", opts),
+			Headers: s.Headers,
 		}
 	}
 
@@ -85,29 +123,133 @@ func newConnzCollector(system, endpoint string, servers []*CollectedServer) prom
 }
 
 func (nc *connzCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
 	ch <- nc.limit
 }
 
 // Collect gathers the server connz metrics.
 func (nc *connzCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, server := range nc.servers {
-		var resp Connz
-		if err := getMetricURL(nc.httpClient, server.URL, &resp); err != nil {
-			Debugf("ignoring server %s: %v", server.ID, err)
-			continue
-		}
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
 
-		var pendingBytes = 0
-		for _, conn := range resp.Connections {
-			pendingBytes += conn.PendingBytes
+// CollectWithContext gathers the server connz metrics, bounded by ctx.
+func (nc *connzCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
+		resp, totals, err := nc.getConnections(ctx, server, server.URL)
+		if err != nil {
+			return err
+		}
+		// An account whose connections cannot be listed is left out,
+		// without failing the poll of the server.
+		var names []string
+		var accounts []connzTotals
+		for _, account := range connzAccounts(nc.opts) {
+			_, t, err := nc.getConnections(ctx, server, accountURL(server.URL, account))
+			if err != nil {
+				nc.opts.serverLogger("connz", server.ID).Errorf("Unable to get the connections of account %s of server %s: %v",
+					account, server.ID, err)
+				continue
+			}
+			names = append(names, account)
+			accounts = append(accounts, t)
 		}
 
-		ch <- prometheus.MustNewConstMetric(nc.numConnections, prometheus.GaugeValue, float64(resp.NumConnections), server.ID)
+		ch <- prometheus.MustNewConstMetric(nc.numConnections, prometheus.GaugeValue, float64(totals.numConnections), server.ID)
 		ch <- prometheus.MustNewConstMetric(nc.total, prometheus.GaugeValue, float64(resp.Total), server.ID)
 		ch <- prometheus.MustNewConstMetric(nc.offset, prometheus.GaugeValue, float64(resp.Offset), server.ID)
 		ch <- prometheus.MustNewConstMetric(nc.limit, prometheus.GaugeValue, float64(resp.Limit), server.ID)
-		ch <- prometheus.MustNewConstMetric(nc.pendingBytes, prometheus.GaugeValue, float64(pendingBytes), server.ID)
+		ch <- prometheus.MustNewConstMetric(nc.pendingBytes, prometheus.GaugeValue, float64(totals.pendingBytes), server.ID)
+		for i, account := range names {
+			t := accounts[i]
+			ch <- prometheus.MustNewConstMetric(nc.accountConnections, prometheus.GaugeValue, float64(t.numConnections), server.ID, account)
+			ch <- prometheus.MustNewConstMetric(nc.accountPendingBytes, prometheus.GaugeValue, float64(t.pendingBytes), server.ID, account)
+			ch <- prometheus.MustNewConstMetric(nc.accountInMsgs, prometheus.GaugeValue, float64(t.inMsgs), server.ID, account)
+			ch <- prometheus.MustNewConstMetric(nc.accountOutMsgs, prometheus.GaugeValue, float64(t.outMsgs), server.ID, account)
+			ch <- prometheus.MustNewConstMetric(nc.accountInBytes, prometheus.GaugeValue, float64(t.inBytes), server.ID, account)
+			ch <- prometheus.MustNewConstMetric(nc.accountOutBytes, prometheus.GaugeValue, float64(t.outBytes), server.ID, account)
+		}
+		return nil
+	})
+}
+
+// connzTotals are the totals of the connections listed by connz.
+type connzTotals struct {
+	numConnections int
+	pendingBytes   int
+	inMsgs         int64
+	outMsgs        int64
+	inBytes        int64
+	outBytes       int64
+}
+
+// add adds the connections of a page to the totals.
+func (t *connzTotals) add(page *Connz) {
+	t.numConnections += page.NumConnections
+	for _, conn := range page.Connections {
+		t.pendingBytes += conn.PendingBytes
+		t.inMsgs += conn.InMsgs
+		t.outMsgs += conn.OutMsgs
+		t.inBytes += conn.InBytes
+		t.outBytes += conn.OutBytes
+	}
+}
+
+// getConnections gets the first page of connz at u and the totals of its
+// connections, following the pages until all of them are retrieved.
+func (nc *connzCollector) getConnections(ctx context.Context, server *CollectedServer, u string) (Connz, connzTotals, error) {
+	var resp Connz
+	var totals connzTotals
+	if err := getMetricURL(ctx, nc.httpClient, nc.opts, u, server.Headers, &resp); err != nil {
+		nc.opts.serverLogger("connz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+		return resp, totals, err
+	}
+	totals.add(&resp)
+
+	page := resp
+	for pages := 1; page.NumConnections > 0 && resp.Offset+totals.numConnections < page.Total; pages++ {
+		if pages == maxPages(nc.opts) {
+			nc.opts.serverLogger("connz", server.ID).Debugf("connz of server %s truncated to %d pages", server.ID, pages)
+			break
+		}
+		pu, err := pageURL(u, resp.Offset+totals.numConnections)
+		if err != nil {
+			return resp, totals, err
+		}
+		page = Connz{}
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, pu, server.Headers, &page); err != nil {
+			nc.opts.serverLogger("connz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return resp, totals, err
+		}
+		totals.add(&page)
+	}
+	return resp, totals, nil
+}
+
+// connzAccounts returns the accounts connz is polled for apart: those of
+// ConnzAccounts among the accounts selected.
+func connzAccounts(opts *CollectorOptions) []string {
+	var accounts []string
+	for _, account := range opts.ConnzAccounts {
+		if accountSelected(opts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// accountURL returns the connz URL of a server filtered to the
+// connections of an account.
+func accountURL(connzURL, account string) string {
+	u, err := url.Parse(connzURL)
+	if err != nil {
+		return connzURL
 	}
+	q := u.Query()
+	q.Set("acc", account)
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
 // Connz output
@@ -117,6 +259,10 @@ type Connz struct {
 	Offset         int `json:"offset"`
 	Limit          int `json:"limit"`
 	Connections    []struct {
-		PendingBytes int `json:"pending_bytes"`
+		PendingBytes int   `json:"pending_bytes"`
+		InMsgs       int64 `json:"in_msgs"`
+		OutMsgs      int64 `json:"out_msgs"`
+		InBytes      int64 `json:"in_bytes"`
+		OutBytes     int64 `json:"out_bytes"`
 	} `json:"connections"`
 }
@@ -0,0 +1,214 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+
+	nats "github.com/nats-io/go-nats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The subjects of the advisories the servers publish on the system
+// account.
+const (
+	connectEventSubject    = "$SYS.ACCOUNT.*.CONNECT"
+	disconnectEventSubject = "$SYS.ACCOUNT.*.DISCONNECT"
+	authErrorEventSubject  = "$SYS.SERVER.*.CLIENT.AUTH.ERR"
+	statszEventSubject     = "$SYS.SERVER.*.STATSZ"
+)
+
+// serverEvent is the part of an advisory the events are counted by.
+type serverEvent struct {
+	Server struct {
+		ID string `json:"id"`
+	} `json:"server"`
+	Client struct {
+		Account string `json:"acc"`
+	} `json:"client"`
+}
+
+// eventKey is what the count of an event is labeled with.
+type eventKey struct {
+	serverID string
+	account  string
+}
+
+// eventsCollector counts the advisories of the servers as they are
+// published rather than when scraped, so that connections opened and
+// closed between two scrapes are not missed.
+type eventsCollector struct {
+	sync.Mutex
+
+	conn        func() (*nats.Conn, error)
+	opts        *CollectorOptions
+	subs        []*nats.Subscription
+	closed      bool
+	connects    map[eventKey]float64
+	disconnects map[eventKey]float64
+	authErrors  map[eventKey]float64
+	statsz      map[string]float64
+
+	connectsDesc    *prometheus.Desc
+	disconnectsDesc *prometheus.Desc
+	authErrorsDesc  *prometheus.Desc
+	statszDesc      *prometheus.Desc
+}
+
+// NewEventsCollector creates a collector counting the connect, disconnect,
+// authentication error and statsz advisories published on the system
+// account, over the connection returned by conn.  It subscribes right
+// away, and again on collection should that fail, until closed through
+// its io.Closer.
+func NewEventsCollector(prefix string, conn func() (*nats.Conn, error),
+	opts *CollectorOptions) prometheus.Collector {
+	if opts == nil {
+		opts = &CollectorOptions{}
+	}
+	system := getSystem(CoreSystem, prefix)
+	labels := []string{"server_id", "account"}
+	nc := &eventsCollector{
+		conn:        conn,
+		opts:        opts,
+		connects:    make(map[eventKey]float64),
+		disconnects: make(map[eventKey]float64),
+		authErrors:  make(map[eventKey]float64),
+		statsz:      make(map[string]float64),
+		connectsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "events", "connects"),
+			"Client connections advised by the server since the exporter subscribed",
+			labels,
+			nil,
+		),
+		disconnectsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "events", "disconnects"),
+			"Client disconnections advised by the server since the exporter subscribed",
+			labels,
+			nil,
+		),
+		authErrorsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "events", "auth_errors"),
+			"Client authentication errors advised by the server since the exporter subscribed",
+			labels,
+			nil,
+		),
+		statszDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "events", "statsz"),
+			"Statsz advisories published by the server since the exporter subscribed",
+			[]string{"server_id"},
+			nil,
+		),
+	}
+	nc.Lock()
+	nc.subscribe()
+	nc.Unlock()
+	return nc
+}
+
+// subscribe subscribes to the advisories unless already subscribed, or
+// closed.  Caller must lock.
+func (nc *eventsCollector) subscribe() {
+	if len(nc.subs) > 0 || nc.closed {
+		return
+	}
+	conn, err := nc.conn()
+	if err != nil {
+		nc.opts.logger().Errorf("Unable to subscribe to the system account events: %v", err)
+		return
+	}
+	handlers := map[string]func(*serverEvent){
+		connectEventSubject: func(e *serverEvent) {
+			if accountSelected(nc.opts, e.Client.Account) {
+				nc.connects[eventKey{e.Server.ID, e.Client.Account}]++
+			}
+		},
+		disconnectEventSubject: func(e *serverEvent) {
+			if accountSelected(nc.opts, e.Client.Account) {
+				nc.disconnects[eventKey{e.Server.ID, e.Client.Account}]++
+			}
+		},
+		authErrorEventSubject: func(e *serverEvent) {
+			if accountSelected(nc.opts, e.Client.Account) {
+				nc.authErrors[eventKey{e.Server.ID, e.Client.Account}]++
+			}
+		},
+		statszEventSubject: func(e *serverEvent) {
+			nc.statsz[e.Server.ID]++
+		},
+	}
+	for subject, count := range handlers {
+		count := count
+		sub, err := conn.Subscribe(subject, func(m *nats.Msg) {
+			e := &serverEvent{}
+			if err := json.Unmarshal(m.Data, e); err != nil || e.Server.ID == "" {
+				nc.opts.logger().Debugf("Ignoring an event on %s: %v", m.Subject, err)
+				return
+			}
+			nc.Lock()
+			count(e)
+			nc.Unlock()
+		})
+		if err != nil {
+			nc.opts.logger().Errorf("Unable to subscribe to %s: %v", subject, err)
+			nc.unsubscribe()
+			return
+		}
+		nc.subs = append(nc.subs, sub)
+	}
+}
+
+// unsubscribe removes the subscriptions.  Caller must lock.
+func (nc *eventsCollector) unsubscribe() {
+	for _, sub := range nc.subs {
+		sub.Unsubscribe() // nolint
+	}
+	nc.subs = nil
+}
+
+// Close unsubscribes from the advisories.
+func (nc *eventsCollector) Close() error {
+	nc.Lock()
+	defer nc.Unlock()
+	nc.unsubscribe()
+	nc.closed = true
+	return nil
+}
+
+// Describe describes the event counters.
+func (nc *eventsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nc.connectsDesc
+	ch <- nc.disconnectsDesc
+	ch <- nc.authErrorsDesc
+	ch <- nc.statszDesc
+}
+
+// Collect sends the event counters, subscribing first if not subscribed.
+func (nc *eventsCollector) Collect(ch chan<- prometheus.Metric) {
+	nc.Lock()
+	defer nc.Unlock()
+	nc.subscribe()
+	collectEvents(ch, nc.connectsDesc, nc.connects)
+	collectEvents(ch, nc.disconnectsDesc, nc.disconnects)
+	collectEvents(ch, nc.authErrorsDesc, nc.authErrors)
+	for id, n := range nc.statsz {
+		ch <- prometheus.MustNewConstMetric(nc.statszDesc, prometheus.CounterValue, n, id)
+	}
+}
+
+func collectEvents(ch chan<- prometheus.Metric, desc *prometheus.Desc, counts map[eventKey]float64) {
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, n, k.serverID, k.account)
+	}
+}
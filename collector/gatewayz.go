@@ -15,6 +15,7 @@
 package collector
 
 import (
+	"context"
 	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
 	"strconv"
@@ -31,38 +32,51 @@ type gatewayzCollector struct {
 
 	httpClient       *http.Client
 	servers          []*CollectedServer
+	opts             *CollectorOptions
+	polls            *pollMetrics
 	outboundGateways *gateway
 	inboundGateways  *gateway
 }
 
-func newGatewayzCollector(system, endpoint string, servers []*CollectedServer) prometheus.Collector {
+func newGatewayzCollector(system, endpoint string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	nc := &gatewayzCollector{
-		httpClient:       http.DefaultClient,
+		httpClient:       newHTTPClient(opts),
+		opts:             opts,
+		polls:            newPollMetrics(system, endpoint),
 		outboundGateways: newGateway(system, endpoint, "outbound_gateway"),
 		inboundGateways:  newGateway(system, endpoint, "inbound_gateway"),
 	}
 	nc.servers = make([]*CollectedServer, len(servers))
 	for i, s := range servers {
 		nc.servers[i] = &CollectedServer{
-			ID:  s.ID,
-			URL: s.URL + "/gatewayz",
+			ID:      s.ID,
+			URL:     endpointURL(s.URL, "gatewayz", opts),
+			Headers: s.Headers,
 		}
 	}
 	return nc
 }
 
 func (nc *gatewayzCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
 	nc.outboundGateways.Describe(ch)
 	nc.inboundGateways.Describe(ch)
 }
 
 // Collect gathers the server gatewayz metrics.
 func (nc *gatewayzCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, server := range nc.servers {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the server gatewayz metrics, bounded by ctx.
+func (nc *gatewayzCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
 		var resp Gatewayz
-		if err := getMetricURL(nc.httpClient, server.URL, &resp); err != nil {
-			Debugf("ignoring server %s: %v", server.ID, err)
-			continue
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("gatewayz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
 		}
 		for obgwName, obgw := range resp.OutboundGateways {
 			nc.outboundGateways.Collect(server, resp.Name, obgwName, obgw, ch)
@@ -72,7 +86,8 @@ func (nc *gatewayzCollector) Collect(ch chan<- prometheus.Metric) {
 				nc.inboundGateways.Collect(server, resp.Name, ibgwName, ibgw, ch)
 			}
 		}
-	}
+		return nil
+	})
 }
 
 // gateway
@@ -0,0 +1,92 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP client defaults
+var (
+	DefaultConnectTimeout      = 5 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultMaxIdleConnsPerHost = 8
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// NewHTTPClient creates a client to poll the NATS monitor endpoints as
+// configured by the options.  Unless compression is disabled the
+// transport requests gzip encoded responses and transparently
+// decompresses them.
+func NewHTTPClient(opts *CollectorOptions) *http.Client {
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout}
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	tr := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       opts.TLSConfig,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		DisableCompression:    opts.DisableCompression,
+		DisableKeepAlives:     opts.DisableKeepAlives,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+	}
+	return &http.Client{Transport: tr, Timeout: opts.RequestTimeout}
+}
+
+// newHTTPClient returns the client shared through the options, or a new
+// one if there is none.
+func newHTTPClient(opts *CollectorOptions) *http.Client {
+	if opts.HTTPClient != nil {
+		return opts.HTTPClient
+	}
+	return NewHTTPClient(opts)
+}
+
+// setAuthorization adds the configured credentials to a request.
+func setAuthorization(req *http.Request, opts *CollectorOptions) error {
+	if opts.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(opts.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read bearer token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		return nil
+	}
+	if opts.MonitorUser != "" && req.URL.User == nil {
+		req.SetBasicAuth(opts.MonitorUser, opts.MonitorPassword)
+	}
+	return nil
+}
+
+// setHeaders adds a server's headers to a request, overriding any
+// already set.
+func setHeaders(req *http.Request, headers http.Header) {
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Host" && len(v) > 0 {
+			req.Host = v[0]
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+}
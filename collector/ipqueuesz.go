@@ -0,0 +1,109 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func isIpqueueszEndpoint(system, endpoint string) bool {
+	return system == CoreSystem && endpoint == "ipqueuesz"
+}
+
+// ipqueueszCollector collects the internal queues of the servers, labeled
+// by the name of each queue.
+type ipqueueszCollector struct {
+	sync.Mutex
+
+	httpClient *http.Client
+	servers    []*CollectedServer
+	opts       *CollectorOptions
+	polls      *pollMetrics
+	pending    *prometheus.Desc
+	inProgress *prometheus.Desc
+}
+
+func newIpqueueszCollector(system, endpoint string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
+	nc := &ipqueueszCollector{
+		httpClient: newHTTPClient(opts),
+		opts:       opts,
+		polls:      newPollMetrics(system, endpoint),
+		pending: prometheus.NewDesc(
+			prometheus.BuildFQName(system, endpoint, "pending"),
+			"Entries pending in the internal queue",
+			[]string{"server_id", "queue"},
+			nil,
+		),
+		inProgress: prometheus.NewDesc(
+			prometheus.BuildFQName(system, endpoint, "in_progress"),
+			"Entries of the internal queue being processed",
+			[]string{"server_id", "queue"},
+			nil,
+		),
+	}
+	nc.servers = make([]*CollectedServer, len(servers))
+	for i, s := range servers {
+		nc.servers[i] = &CollectedServer{
+			ID:      s.ID,
+			URL:     endpointURL(s.URL, "ipqueuesz", opts),
+			Headers: s.Headers,
+		}
+	}
+	return nc
+}
+
+func (nc *ipqueueszCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
+	ch <- nc.pending
+	ch <- nc.inProgress
+}
+
+// Collect gathers the server ipqueuesz metrics.
+func (nc *ipqueueszCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the server ipqueuesz metrics, bounded by ctx.
+func (nc *ipqueueszCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
+		var resp Ipqueuesz
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("ipqueuesz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
+		}
+		for name, q := range resp {
+			ch <- prometheus.MustNewConstMetric(nc.pending, prometheus.GaugeValue,
+				float64(q.Pending), server.ID, name)
+			ch <- prometheus.MustNewConstMetric(nc.inProgress, prometheus.GaugeValue,
+				float64(q.InProgress), server.ID, name)
+		}
+		return nil
+	})
+}
+
+// Ipqueuesz are the internal queues of a NATS server by name, only those
+// not empty unless all are requested.
+type Ipqueuesz map[string]*IpqueueStatus
+
+// IpqueueStatus is the state of an internal queue.
+type IpqueueStatus struct {
+	Pending    int `json:"pending"`
+	InProgress int `json:"in_progress,omitempty"`
+}
@@ -0,0 +1,424 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// kvStreamPrefix is the prefix of the names of the streams backing the
+	// key-value buckets, e.g. KV_sessions for the bucket sessions.
+	kvStreamPrefix = "KV_"
+
+	// objStreamPrefix is the prefix of the names of the streams backing
+	// the object stores.
+	objStreamPrefix = "OBJ_"
+)
+
+func isJszEndpoint(system, endpoint string) bool {
+	return system == CoreSystem && endpoint == "jsz"
+}
+
+// jszURL returns the URL of the JetStream details of the server, down to
+// the configuration of its streams.
+func jszURL(serverURL string) string {
+	return serverURL + "/jsz?accounts=true&streams=true&config=true"
+}
+
+// jszCollector collects the JetStream metrics of the servers: the
+// statistics of their JetStream API, the health of the raft groups of the
+// streams, and the metrics of the key-value buckets and object stores,
+// found by the names of their streams.
+type jszCollector struct {
+	sync.Mutex
+
+	httpClient *http.Client
+	servers    []*CollectedServer
+	opts       *CollectorOptions
+	polls      *pollMetrics
+
+	apiRequests *prometheus.Desc
+	apiErrors   *prometheus.Desc
+	apiInflight *prometheus.Desc
+	metaPending *prometheus.Desc
+
+	streamLeader       *prometheus.Desc
+	streamPeers        *prometheus.Desc
+	streamPeersLagging *prometheus.Desc
+	streamPeersOffline *prometheus.Desc
+	streamReplicaLag   *prometheus.Desc
+
+	kvValues  *prometheus.Desc
+	kvKeys    *prometheus.Desc
+	kvBytes   *prometheus.Desc
+	kvHistory *prometheus.Desc
+	kvTTL     *prometheus.Desc
+
+	objBytes    *prometheus.Desc
+	objChunks   *prometheus.Desc
+	objMaxBytes *prometheus.Desc
+}
+
+func newJszCollector(system, endpoint string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
+	labels := []string{"server_id", "account", "bucket"}
+	streamLabels := []string{"server_id", "account", "stream"}
+	nc := &jszCollector{
+		httpClient: newHTTPClient(opts),
+		opts:       opts,
+		polls:      newPollMetrics(system, endpoint),
+		apiRequests: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "api_requests"),
+			"JetStream API requests handled by the server",
+			[]string{"server_id"},
+			nil,
+		),
+		apiErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "api_errors"),
+			"JetStream API requests answered with an error",
+			[]string{"server_id"},
+			nil,
+		),
+		apiInflight: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "api_inflight"),
+			"JetStream API requests being handled, reported by servers from 2.10",
+			[]string{"server_id"},
+			nil,
+		),
+		metaPending: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "meta_pending"),
+			"Proposals pending in the meta group of the cluster, reported by servers from 2.10",
+			[]string{"server_id"},
+			nil,
+		),
+		streamLeader: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "stream_leader"),
+			"Whether the server is the leader of the raft group of the stream",
+			streamLabels,
+			nil,
+		),
+		streamPeers: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "stream_peers"),
+			"Peers of the raft group of the stream, the server included",
+			streamLabels,
+			nil,
+		),
+		streamPeersLagging: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "stream_peers_lagging"),
+			"Peers of the raft group not current with the leader, reported by the leader",
+			streamLabels,
+			nil,
+		),
+		streamPeersOffline: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "stream_peers_offline"),
+			"Peers of the raft group offline, reported by the leader",
+			streamLabels,
+			nil,
+		),
+		streamReplicaLag: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "jetstream", "stream_replica_lag"),
+			"Entries the replica of the peer is behind the leader, reported by the leader",
+			[]string{"server_id", "account", "stream", "peer"},
+			nil,
+		),
+		kvValues: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "kv", "bucket_values"),
+			"Values stored in the bucket, including the history of the keys",
+			labels,
+			nil,
+		),
+		kvKeys: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "kv", "bucket_keys"),
+			"Keys of the bucket, including deleted ones until purged",
+			labels,
+			nil,
+		),
+		kvBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "kv", "bucket_bytes"),
+			"Bytes stored in the bucket",
+			labels,
+			nil,
+		),
+		kvHistory: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "kv", "bucket_history"),
+			"Values kept for each key",
+			labels,
+			nil,
+		),
+		kvTTL: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "kv", "bucket_ttl_seconds"),
+			"Time the values are kept, 0 if forever",
+			labels,
+			nil,
+		),
+		objBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "obj", "bucket_bytes"),
+			"Bytes stored in the object store",
+			labels,
+			nil,
+		),
+		objChunks: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "obj", "bucket_chunks"),
+			"Chunks of the objects stored, along with the metadata of each object",
+			labels,
+			nil,
+		),
+		objMaxBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "obj", "bucket_max_bytes"),
+			"Maximum size of the object store, -1 if unlimited",
+			labels,
+			nil,
+		),
+	}
+	nc.servers = make([]*CollectedServer, len(servers))
+	for i, s := range servers {
+		nc.servers[i] = &CollectedServer{
+			ID:      s.ID,
+			URL:     jszURL(s.URL),
+			Headers: s.Headers,
+		}
+	}
+	return nc
+}
+
+func (nc *jszCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
+	ch <- nc.apiRequests
+	ch <- nc.apiErrors
+	ch <- nc.apiInflight
+	ch <- nc.metaPending
+	ch <- nc.streamLeader
+	ch <- nc.streamPeers
+	ch <- nc.streamPeersLagging
+	ch <- nc.streamPeersOffline
+	ch <- nc.streamReplicaLag
+	ch <- nc.kvValues
+	ch <- nc.kvKeys
+	ch <- nc.kvBytes
+	ch <- nc.kvHistory
+	ch <- nc.kvTTL
+	ch <- nc.objBytes
+	ch <- nc.objChunks
+	ch <- nc.objMaxBytes
+}
+
+// Collect gathers the server jsz metrics.
+func (nc *jszCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the server jsz metrics, bounded by ctx.
+func (nc *jszCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
+		var resp Jsz
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("jsz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(nc.apiRequests, prometheus.CounterValue,
+			float64(resp.API.Total), server.ID)
+		ch <- prometheus.MustNewConstMetric(nc.apiErrors, prometheus.CounterValue,
+			float64(resp.API.Errors), server.ID)
+		ch <- prometheus.MustNewConstMetric(nc.apiInflight, prometheus.GaugeValue,
+			float64(resp.API.Inflight), server.ID)
+		if resp.Meta != nil {
+			ch <- prometheus.MustNewConstMetric(nc.metaPending, prometheus.GaugeValue,
+				float64(resp.Meta.Pending), server.ID)
+		}
+		for _, acc := range resp.AccountDetails {
+			if !accountSelected(nc.opts, acc.Name) {
+				continue
+			}
+			for _, stream := range acc.Streams {
+				if stream.Cluster != nil {
+					nc.collectRaftGroup(server, acc.Name, stream, ch)
+				}
+				switch {
+				case strings.HasPrefix(stream.Name, kvStreamPrefix):
+					nc.collectBucket(server, acc.Name, stream, ch)
+				case strings.HasPrefix(stream.Name, objStreamPrefix):
+					nc.collectObjectStore(server, acc.Name, stream, ch)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// collectRaftGroup sends the health of the raft group of the replicas of
+// the stream.  Only the leader knows how far behind the other peers are.
+func (nc *jszCollector) collectRaftGroup(server *CollectedServer, account string, stream *StreamDetail,
+	ch chan<- prometheus.Metric) {
+	cluster := stream.Cluster
+	leader := cluster.isLeader()
+	ch <- prometheus.MustNewConstMetric(nc.streamLeader, prometheus.GaugeValue,
+		boolToFloat(leader), server.ID, account, stream.Name)
+	ch <- prometheus.MustNewConstMetric(nc.streamPeers, prometheus.GaugeValue,
+		float64(len(cluster.Replicas)+1), server.ID, account, stream.Name)
+	if !leader {
+		return
+	}
+	var lagging, offline int
+	for _, peer := range cluster.Replicas {
+		if !peer.Current {
+			lagging++
+		}
+		if peer.Offline {
+			offline++
+		}
+		ch <- prometheus.MustNewConstMetric(nc.streamReplicaLag, prometheus.GaugeValue,
+			float64(peer.Lag), server.ID, account, stream.Name, peer.Name)
+	}
+	ch <- prometheus.MustNewConstMetric(nc.streamPeersLagging, prometheus.GaugeValue,
+		float64(lagging), server.ID, account, stream.Name)
+	ch <- prometheus.MustNewConstMetric(nc.streamPeersOffline, prometheus.GaugeValue,
+		float64(offline), server.ID, account, stream.Name)
+}
+
+// collectBucket sends the metrics of the key-value bucket backed by the
+// stream.
+func (nc *jszCollector) collectBucket(server *CollectedServer, account string, stream *StreamDetail,
+	ch chan<- prometheus.Metric) {
+	bucket := strings.TrimPrefix(stream.Name, kvStreamPrefix)
+	ch <- prometheus.MustNewConstMetric(nc.kvValues, prometheus.GaugeValue,
+		float64(stream.State.Msgs), server.ID, account, bucket)
+	ch <- prometheus.MustNewConstMetric(nc.kvKeys, prometheus.GaugeValue,
+		float64(stream.State.NumSubjects), server.ID, account, bucket)
+	ch <- prometheus.MustNewConstMetric(nc.kvBytes, prometheus.GaugeValue,
+		float64(stream.State.Bytes), server.ID, account, bucket)
+	if stream.Config != nil {
+		ch <- prometheus.MustNewConstMetric(nc.kvHistory, prometheus.GaugeValue,
+			float64(stream.Config.MaxMsgsPerSubject), server.ID, account, bucket)
+		ch <- prometheus.MustNewConstMetric(nc.kvTTL, prometheus.GaugeValue,
+			stream.Config.MaxAge.Seconds(), server.ID, account, bucket)
+	}
+}
+
+// collectObjectStore sends the metrics of the object store backed by the
+// stream.
+func (nc *jszCollector) collectObjectStore(server *CollectedServer, account string, stream *StreamDetail,
+	ch chan<- prometheus.Metric) {
+	bucket := strings.TrimPrefix(stream.Name, objStreamPrefix)
+	ch <- prometheus.MustNewConstMetric(nc.objBytes, prometheus.GaugeValue,
+		float64(stream.State.Bytes), server.ID, account, bucket)
+	ch <- prometheus.MustNewConstMetric(nc.objChunks, prometheus.GaugeValue,
+		float64(stream.State.Msgs), server.ID, account, bucket)
+	if stream.Config != nil {
+		ch <- prometheus.MustNewConstMetric(nc.objMaxBytes, prometheus.GaugeValue,
+			float64(stream.Config.MaxBytes), server.ID, account, bucket)
+	}
+}
+
+// Jsz is the JetStream detail of a NATS server, as far as collected.
+type Jsz struct {
+	ServerID       string            `json:"server_id"`
+	Now            time.Time         `json:"now"`
+	API            JetStreamAPIStats `json:"api"`
+	Meta           *MetaClusterInfo  `json:"meta_cluster,omitempty"`
+	AccountDetails []*AccountDetail  `json:"account_details,omitempty"`
+}
+
+// JetStreamAPIStats are the statistics of the JetStream API of a server.
+type JetStreamAPIStats struct {
+	Total    uint64 `json:"total"`
+	Errors   uint64 `json:"errors"`
+	Inflight uint64 `json:"inflight,omitempty"`
+}
+
+// MetaClusterInfo is the state of the meta group of a JetStream cluster, as
+// seen by a server.
+type MetaClusterInfo struct {
+	Name    string `json:"name,omitempty"`
+	Leader  string `json:"leader,omitempty"`
+	Size    int    `json:"cluster_size"`
+	Pending int    `json:"pending"`
+}
+
+// AccountDetail is the JetStream detail of an account.
+type AccountDetail struct {
+	Name    string          `json:"name"`
+	ID      string          `json:"id"`
+	Streams []*StreamDetail `json:"stream_detail,omitempty"`
+}
+
+// StreamDetail is the detail of a stream, its configuration only given
+// when requested.
+type StreamDetail struct {
+	Name    string        `json:"name"`
+	Created time.Time     `json:"created"`
+	Config  *StreamConfig `json:"config,omitempty"`
+	State   StreamState   `json:"state,omitempty"`
+	Cluster *ClusterInfo  `json:"cluster,omitempty"`
+}
+
+// ClusterInfo is the raft group of the replicas of a stream, as seen by a
+// server: its peers other than the server itself.
+type ClusterInfo struct {
+	Name     string      `json:"name,omitempty"`
+	Leader   string      `json:"leader,omitempty"`
+	Replicas []*PeerInfo `json:"replicas,omitempty"`
+}
+
+// isLeader returns whether the server reporting the raft group leads it,
+// the leader being among the peers of the others.
+func (ci *ClusterInfo) isLeader() bool {
+	if ci.Leader == "" {
+		return false
+	}
+	for _, peer := range ci.Replicas {
+		if peer.Name == ci.Leader {
+			return false
+		}
+	}
+	return true
+}
+
+// PeerInfo is a peer of a raft group.
+type PeerInfo struct {
+	Name    string        `json:"name"`
+	Current bool          `json:"current"`
+	Offline bool          `json:"offline,omitempty"`
+	Active  time.Duration `json:"active"`
+	Lag     uint64        `json:"lag,omitempty"`
+}
+
+// StreamConfig is the configuration of a stream.
+type StreamConfig struct {
+	Name              string        `json:"name"`
+	Subjects          []string      `json:"subjects,omitempty"`
+	MaxMsgs           int64         `json:"max_msgs"`
+	MaxBytes          int64         `json:"max_bytes"`
+	MaxAge            time.Duration `json:"max_age"`
+	MaxMsgsPerSubject int64         `json:"max_msgs_per_subject"`
+	Storage           string        `json:"storage"`
+	Replicas          int           `json:"num_replicas"`
+}
+
+// StreamState is the state of a stream.
+type StreamState struct {
+	Msgs        uint64 `json:"messages"`
+	Bytes       uint64 `json:"bytes"`
+	FirstSeq    uint64 `json:"first_seq"`
+	LastSeq     uint64 `json:"last_seq"`
+	NumSubjects int    `json:"num_subjects,omitempty"`
+	Consumers   int    `json:"consumer_count"`
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// Server name label modes
+const (
+	ServerNameLabelAdd     = "add"
+	ServerNameLabelReplace = "replace"
+)
+
+// DefaultServerNameRefresh is how often the name of a server is looked
+// up again.
+var DefaultServerNameRefresh = time.Minute
+
+// CheckServerLabels returns an error if the name of a static label is
+// invalid or reserved for a label the exporter sets itself.
+func CheckServerLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		switch name {
+		case "server_id", "server_name", "cluster":
+			return fmt.Errorf("label name %q is reserved", name)
+		}
+		if strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("label name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// serverLabels returns the static labels added to the metrics of a server.
+func serverLabels(s *CollectedServer) map[string]string {
+	if s.Cluster == "" && len(s.Labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(s.Labels)+1)
+	for name, value := range s.Labels {
+		labels[name] = value
+	}
+	if s.Cluster != "" {
+		labels["cluster"] = s.Cluster
+	}
+	return labels
+}
+
+// serverName caches the server_name of a server from its /varz.
+type serverName struct {
+	sync.Mutex
+	name    string
+	updated time.Time
+}
+
+// get returns the name of the server, looking it up again once refresh
+// has passed.  The last name known is kept if the lookup fails.
+func (sn *serverName) get(ctx context.Context, httpClient *http.Client, opts *CollectorOptions,
+	s *CollectedServer, refresh time.Duration) string {
+	sn.Lock()
+	defer sn.Unlock()
+
+	if !sn.updated.IsZero() && time.Since(sn.updated) < refresh {
+		return sn.name
+	}
+	var varz struct {
+		ServerName string `json:"server_name"`
+	}
+	if err := fetchMetricURL(ctx, httpClient, opts, s.URL+"/varz", s.Headers, &varz); err != nil {
+		opts.serverLogger("varz", s.ID).Debugf("Could not get the server name of %s: %v", s.ID, err)
+		return sn.name
+	}
+	sn.name = varz.ServerName
+	sn.updated = time.Now()
+	return sn.name
+}
+
+// serverID caches the server_id a server reports in its /varz, looked up
+// in the background so that polls never wait for it.
+type serverID struct {
+	sync.Mutex
+	id        string
+	updated   time.Time
+	resolving bool
+}
+
+// get returns the ID the server last reported, empty until it first
+// responds, starting to look it up again once refresh has passed.
+func (si *serverID) get(httpClient *http.Client, opts *CollectorOptions, s *CollectedServer,
+	refresh time.Duration) string {
+	si.Lock()
+	defer si.Unlock()
+
+	if !si.resolving && (si.updated.IsZero() || time.Since(si.updated) >= refresh) {
+		si.resolving = true
+		go si.resolve(httpClient, opts, s)
+	}
+	return si.id
+}
+
+// set sets the ID of the server, e.g. as it restarted, until it is looked
+// up again after refresh.
+func (si *serverID) set(id string) {
+	si.Lock()
+	defer si.Unlock()
+	si.id = id
+	si.updated = time.Now()
+}
+
+// resolve looks up the ID of the server, keeping the last ID known if the
+// server does not respond.
+func (si *serverID) resolve(httpClient *http.Client, opts *CollectorOptions, s *CollectedServer) {
+	ctx, cancel := collectContext(opts)
+	defer cancel()
+
+	var varz struct {
+		ServerID string `json:"server_id"`
+	}
+	err := fetchMetricURL(ctx, httpClient, opts, s.URL+"/varz", s.Headers, &varz)
+
+	si.Lock()
+	defer si.Unlock()
+	si.resolving = false
+	if err != nil || varz.ServerID == "" {
+		opts.serverLogger("varz", s.ID).Debugf("Could not get the server id of %s: %v", s.ID, err)
+		return
+	}
+	if varz.ServerID != si.id {
+		opts.serverLogger("varz", s.ID).Debugf("Server %s reports the id %s", s.ID, varz.ServerID)
+	}
+	si.id = varz.ServerID
+	si.updated = time.Now()
+}
+
+// labeledCollector adds the labels of each server to the metrics of a
+// collector, matching them to the server by their server_id label.
+type labeledCollector struct {
+	ContextCollector
+	opts       *CollectorOptions
+	httpClient *http.Client
+	servers    []*CollectedServer
+	names      []string // Static label names.
+}
+
+// newLabeledCollector wraps the collector if any of the servers has
+// static labels, or their names, internal IDs or URLs are to label their
+// metrics.  Servers without one of the static labels get it empty, so
+// every metric has the same label names.
+func newLabeledCollector(c prometheus.Collector, servers []*CollectedServer,
+	opts *CollectorOptions) prometheus.Collector {
+	names := make(map[string]bool)
+	for _, s := range servers {
+		for name := range serverLabels(s) {
+			names[name] = true
+		}
+	}
+	cc, ok := c.(ContextCollector)
+	if (len(names) == 0 && opts.ServerNameLabel == "" && !opts.InternalServerIDLabel &&
+		!opts.UseServerURLLabel) || !ok {
+		return c
+	}
+
+	lc := &labeledCollector{
+		ContextCollector: cc,
+		opts:             opts,
+		servers:          servers,
+	}
+	if opts.ServerNameLabel != "" || opts.InternalServerIDLabel {
+		lc.httpClient = newHTTPClient(opts)
+	}
+	for name := range names {
+		lc.names = append(lc.names, name)
+	}
+	sort.Strings(lc.names)
+	return lc
+}
+
+// Collect collects the metrics of the underlying collector.
+func (lc *labeledCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(lc.opts)
+	defer cancel()
+	lc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext collects the metrics of the underlying collector,
+// adding the labels of their server.
+func (lc *labeledCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	labels := lc.serverLabels(ctx)
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range metrics {
+			ch <- label(m, labels)
+		}
+		close(done)
+	}()
+	lc.ContextCollector.CollectWithContext(ctx, metrics)
+	close(metrics)
+	<-done
+}
+
+// serverLabels returns the labels of each server by its ID.
+func (lc *labeledCollector) serverLabels(ctx context.Context) map[string]*labeledMetric {
+	refresh := lc.opts.ServerNameRefresh
+	if refresh <= 0 {
+		refresh = DefaultServerNameRefresh
+	}
+	names := make([]string, len(lc.servers))
+	if lc.opts.ServerNameLabel != "" {
+		var wg sync.WaitGroup
+		for i, s := range lc.servers {
+			wg.Add(1)
+			go func(i int, s *CollectedServer) {
+				defer wg.Done()
+				names[i] = s.name.get(ctx, lc.httpClient, lc.opts, s, refresh)
+			}(i, s)
+		}
+		wg.Wait()
+	}
+
+	labels := make(map[string]*labeledMetric, len(lc.servers))
+	for i, s := range lc.servers {
+		static := serverLabels(s)
+		lm := &labeledMetric{}
+		for _, name := range lc.names {
+			lm.labels = append(lm.labels, &dto.LabelPair{
+				Name:  proto.String(name),
+				Value: proto.String(static[name]),
+			})
+		}
+		if lc.opts.InternalServerIDLabel {
+			lm.serverID = s.id.get(lc.httpClient, lc.opts, s, refresh)
+		}
+		switch lc.opts.ServerNameLabel {
+		case ServerNameLabelAdd:
+			lm.labels = append(lm.labels, &dto.LabelPair{
+				Name:  proto.String("server_name"),
+				Value: proto.String(names[i]),
+			})
+		case ServerNameLabelReplace:
+			lm.serverID = names[i]
+		}
+		if lc.opts.UseServerURLLabel {
+			if u, err := url.Parse(s.URL); err == nil && u.Host != "" {
+				lm.serverID = u.Host
+			}
+		}
+		labels[s.ID] = lm
+	}
+	return labels
+}
+
+// label returns the metric with the labels of its server.
+func label(m prometheus.Metric, labels map[string]*labeledMetric) prometheus.Metric {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return m
+	}
+	for _, lp := range pb.Label {
+		if lp.GetName() == "server_id" {
+			if lm, ok := labels[lp.GetValue()]; ok {
+				return &labeledMetric{Metric: m, labels: lm.labels, serverID: lm.serverID}
+			}
+			break
+		}
+	}
+	return m
+}
+
+// labeledMetric is a metric with additional labels, and possibly another
+// server_id.
+type labeledMetric struct {
+	prometheus.Metric
+	labels   []*dto.LabelPair
+	serverID string
+}
+
+// Write writes the metric with its additional labels, leaving out any
+// the metric already has.
+func (lm *labeledMetric) Write(out *dto.Metric) error {
+	if err := lm.Metric.Write(out); err != nil {
+		return err
+	}
+	// The label pairs written may be shared with the metric, so they are
+	// copied rather than modified.
+	pairs := make([]*dto.LabelPair, 0, len(out.Label)+len(lm.labels))
+	have := make(map[string]bool, len(out.Label))
+	for _, lp := range out.Label {
+		have[lp.GetName()] = true
+		if lp.GetName() == "server_id" && lm.serverID != "" {
+			lp = &dto.LabelPair{Name: lp.Name, Value: proto.String(lm.serverID)}
+		}
+		pairs = append(pairs, lp)
+	}
+	for _, lp := range lm.labels {
+		if !have[lp.GetName()] {
+			pairs = append(pairs, lp)
+		}
+	}
+	sort.Sort(prometheus.LabelPairSorter(pairs))
+	out.Label = pairs
+	return nil
+}
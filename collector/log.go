@@ -14,9 +14,15 @@
 package collector
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/gnatsd/logger"
 )
@@ -63,6 +69,12 @@ const (
 	FileLogType
 )
 
+// Log Formats
+const (
+	TextLogFormat = "text"
+	JSONLogFormat = "json"
+)
+
 // LoggerOptions configure the logger
 type LoggerOptions struct {
 	Debug        bool
@@ -71,6 +83,47 @@ type LoggerOptions struct {
 	LogFile      string
 	LogType      int
 	RemoteSyslog string
+
+	// LogFormat is the format of the console and file logs, text by
+	// default, or json for a JSON object per statement.
+	LogFormat string
+
+	// SyslogFacility is the facility of the statements logged to the
+	// syslog, DefaultSyslogFacility if empty.
+	SyslogFacility string
+
+	// The log file is rotated once it would exceed LogSizeLimit bytes or
+	// is LogMaxAge old, keeping the LogMaxFiles last files rotated.  Zero
+	// values disable the limits.
+	LogSizeLimit int64
+	LogMaxAge    time.Duration
+	LogMaxFiles  int
+}
+
+// CheckLoggerOptions checks the logs can be written as configured.
+func CheckLoggerOptions(opts *LoggerOptions) error {
+	if err := CheckLogFormat(opts.LogFormat, opts.LogType); err != nil {
+		return err
+	}
+	if opts.LogSizeLimit < 0 || opts.LogMaxAge < 0 || opts.LogMaxFiles < 0 {
+		return fmt.Errorf("the log rotation limits cannot be negative")
+	}
+	return checkSyslog(opts)
+}
+
+// CheckLogFormat checks the log format is known and can be written to the
+// log type.
+func CheckLogFormat(format string, logType int) error {
+	switch format {
+	case "", TextLogFormat:
+		return nil
+	case JSONLogFormat:
+		if logType == SysLogType || logType == RemoteSysLogType {
+			return fmt.Errorf("json logs cannot be written to the syslog")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q, text or json expected", format)
 }
 
 // ConfigureLogger configures logging for the NATS exporter.
@@ -87,12 +140,26 @@ func ConfigureLogger(lOpts *LoggerOptions) {
 	// always log time
 	opts.Logtime = true
 
+	// The loggers log every level, filtered by the package as the level
+	// can be changed at runtime.
 	switch opts.LogType {
 	case FileLogType:
-		newLogger = logger.NewFileLogger(opts.LogFile, opts.Logtime, opts.Debug, opts.Trace, true)
-	case RemoteSysLogType:
-		newLogger = logger.NewRemoteSysLogger(opts.RemoteSyslog, opts.Debug, opts.Trace)
+		w, err := openRotatingFile(opts.LogFile, opts.LogSizeLimit, opts.LogMaxAge, opts.LogMaxFiles)
+		if err != nil {
+			newLogger = logger.NewStdLogger(opts.Logtime, true, true, false, true)
+			newLogger.Errorf("Unable to open the log file: %v", err)
+		} else if opts.LogFormat == JSONLogFormat {
+			newLogger = NewJSONLogger(w)
+		} else {
+			newLogger = newTextLogger(w)
+		}
+	case SysLogType, RemoteSysLogType:
+		newLogger = newConfiguredSyslogLogger(opts)
 	case ConsoleLogType:
+		if opts.LogFormat == JSONLogFormat {
+			newLogger = NewJSONLogger(os.Stderr)
+			break
+		}
 		colors := true
 		// Check to see if stderr is being redirected and if so turn off color
 		// Also turn off colors if we're running on Windows where os.Stderr.Stat() returns an invalid handle-error
@@ -100,9 +167,7 @@ func ConfigureLogger(lOpts *LoggerOptions) {
 		if err != nil || (stat.Mode()&os.ModeCharDevice) == 0 {
 			colors = false
 		}
-		newLogger = logger.NewStdLogger(opts.Logtime, opts.Debug, opts.Trace, colors, true)
-	case SysLogType:
-		newLogger = logger.NewSysLogger(opts.Debug, opts.Trace)
+		newLogger = logger.NewStdLogger(opts.Logtime, true, true, colors, true)
 	}
 	if opts.Debug {
 		atomic.StoreInt32(&debug, 1)
@@ -116,6 +181,67 @@ func ConfigureLogger(lOpts *LoggerOptions) {
 	collectorLog.Unlock()
 }
 
+// Log Levels, each logging the statements of the levels below it.
+const (
+	InfoLogLevel  = "info"
+	DebugLogLevel = "debug"
+	TraceLogLevel = "trace"
+)
+
+// LogLevels are the log levels, from the least to the most verbose.
+var LogLevels = []string{InfoLogLevel, DebugLogLevel, TraceLogLevel}
+
+// LogLevel returns the level logged.
+func LogLevel() string {
+	switch {
+	case atomic.LoadInt32(&trace) != 0:
+		return TraceLogLevel
+	case atomic.LoadInt32(&debug) != 0:
+		return DebugLogLevel
+	}
+	return InfoLogLevel
+}
+
+// SetLogLevel changes the level logged at runtime.
+func SetLogLevel(level string) error {
+	var d, t int32
+	switch level {
+	case InfoLogLevel:
+	case DebugLogLevel:
+		d = 1
+	case TraceLogLevel:
+		d, t = 1, 1
+	default:
+		return fmt.Errorf("invalid log level %q, info, debug or trace expected", level)
+	}
+	atomic.StoreInt32(&debug, d)
+	atomic.StoreInt32(&trace, t)
+	return nil
+}
+
+// newConfiguredSyslogLogger returns the logger writing to the local or
+// remote syslog configured, or to the console if it cannot connect.  The
+// local syslog of Windows is its event log.
+func newConfiguredSyslogLogger(opts *LoggerOptions) Logger {
+	if opts.LogType == SysLogType && runtime.GOOS == "windows" {
+		return logger.NewSysLogger(true, true)
+	}
+	var network, addr string
+	facility, err := syslogFacility(opts.SyslogFacility)
+	if err == nil && opts.LogType == RemoteSysLogType {
+		network, addr, err = parseSyslogURL(opts.RemoteSyslog)
+	}
+	var l Logger
+	if err == nil {
+		if l, err = newSyslogLogger(network, addr, facility); err == nil {
+			return l
+		}
+	}
+	l = logger.NewStdLogger(true, true, true, false, true)
+	l.Errorf("Unable to log to the syslog: %v", err)
+	return l
+}
+
 // RemoveLogger clears the logger instance and debug/trace flags.
 // Used for testing.
 func RemoveLogger() {
@@ -176,3 +302,129 @@ func executeLogCall(f func(logger Logger, format string, v ...interface{}), form
 	}
 	f(collectorLog.logger, format, args...)
 }
+
+// logFields are the fields of the statements of a collector, logged as
+// such by the JSON logger and left out by the others.
+type logFields struct {
+	component string
+	serverID  string
+	endpoint  string
+}
+
+// fieldLogger is a Logger logging fields along with the statements.
+type fieldLogger interface {
+	withFields(f logFields) Logger
+}
+
+// withFields returns the logger logging the fields, if it can.
+func withFields(log Logger, f logFields) Logger {
+	if fl, ok := log.(fieldLogger); ok {
+		return fl.withFields(f)
+	}
+	return log
+}
+
+// packageLogger logs through the package logger, filtering the debug and
+// trace statements as it is configured to.
+type packageLogger struct {
+	fields logFields
+}
+
+func (l packageLogger) withFields(f logFields) Logger {
+	return packageLogger{fields: f}
+}
+
+func (l packageLogger) Noticef(format string, v ...interface{}) {
+	l.log(func(log Logger) { log.Noticef(format, v...) })
+}
+
+func (l packageLogger) Fatalf(format string, v ...interface{}) {
+	l.log(func(log Logger) { log.Fatalf(format, v...) })
+}
+
+func (l packageLogger) Errorf(format string, v ...interface{}) {
+	l.log(func(log Logger) { log.Errorf(format, v...) })
+}
+
+func (l packageLogger) Debugf(format string, v ...interface{}) {
+	if atomic.LoadInt32(&debug) != 0 {
+		l.log(func(log Logger) { log.Debugf(format, v...) })
+	}
+}
+
+func (l packageLogger) Tracef(format string, v ...interface{}) {
+	if atomic.LoadInt32(&trace) != 0 {
+		l.log(func(log Logger) { log.Tracef(format, v...) })
+	}
+}
+
+func (l packageLogger) log(f func(log Logger)) {
+	collectorLog.Lock()
+	defer collectorLog.Unlock()
+	if collectorLog.logger == nil {
+		return
+	}
+	f(withFields(collectorLog.logger, l.fields))
+}
+
+// jsonLogEntry is a statement logged as JSON.
+type jsonLogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Component string `json:"component"`
+	ServerID  string `json:"server_id,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Message   string `json:"msg"`
+}
+
+// jsonLogger writes a JSON object per statement, for log pipelines to
+// ingest without parsing the text.
+type jsonLogger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	fields logFields
+}
+
+// NewJSONLogger returns a logger writing each statement to w as a JSON
+// object holding its timestamp, level, component and message, along with
+// the server_id and endpoint of the statements of a collector polling a
+// server.  The component is exporter, or collector for the statements of
+// the collectors.
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{mu: &sync.Mutex{}, w: w}
+}
+
+func (l *jsonLogger) withFields(f logFields) Logger {
+	return &jsonLogger{mu: l.mu, w: l.w, fields: f}
+}
+
+func (l *jsonLogger) Noticef(format string, v ...interface{}) { l.log("info", format, v...) }
+func (l *jsonLogger) Errorf(format string, v ...interface{})  { l.log("error", format, v...) }
+func (l *jsonLogger) Debugf(format string, v ...interface{})  { l.log("debug", format, v...) }
+func (l *jsonLogger) Tracef(format string, v ...interface{})  { l.log("trace", format, v...) }
+
+func (l *jsonLogger) Fatalf(format string, v ...interface{}) {
+	l.log("fatal", format, v...)
+	os.Exit(1)
+}
+
+func (l *jsonLogger) log(level, format string, v ...interface{}) {
+	e := jsonLogEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     level,
+		Component: l.fields.component,
+		ServerID:  l.fields.serverID,
+		Endpoint:  l.fields.endpoint,
+		Message:   strings.TrimRight(fmt.Sprintf(format, v...), "\n"),
+	}
+	if e.Component == "" {
+		e.Component = "exporter"
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	l.w.Write(append(b, '\n'))
+	l.mu.Unlock()
+}
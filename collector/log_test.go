@@ -14,10 +14,17 @@
 package collector
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfigureLogger(t *testing.T) {
@@ -178,3 +185,169 @@ func TestLogOutput(t *testing.T) {
 	Tracef("foo")
 	checkLogger("foo")
 }
+
+func TestJSONLogger(t *testing.T) {
+	defer RemoveLogger()
+
+	f, err := ioutil.TempFile("", "exporter-json-log")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	ConfigureLogger(&LoggerOptions{LogType: FileLogType, LogFile: f.Name(), LogFormat: JSONLogFormat, Debug: true})
+	Noticef("started on %s\n", ":7777")
+	opts := &CollectorOptions{}
+	opts.serverLogger("connz", "nats-0").Debugf("ignoring server %s", "nats-0")
+	opts.logger().Tracef("not traced")
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 statements, got %q", lines)
+	}
+	var entries [2]map[string]string
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+			t.Fatalf("Unexpected error decoding %q: %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, entries[i]["timestamp"]); err != nil {
+			t.Fatalf("Unexpected timestamp: %v", err)
+		}
+		delete(entries[i], "timestamp")
+	}
+	expected := [2]map[string]string{
+		{"level": "info", "component": "exporter", "msg": "started on :7777"},
+		{"level": "debug", "component": "collector", "server_id": "nats-0", "endpoint": "connz", "msg": "ignoring server nats-0"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected %v, got %v", expected, entries)
+	}
+}
+
+func TestCheckLogFormat(t *testing.T) {
+	for _, format := range []string{"", TextLogFormat, JSONLogFormat} {
+		if err := CheckLogFormat(format, FileLogType); err != nil {
+			t.Fatalf("Unexpected error for %q: %v", format, err)
+		}
+	}
+	if err := CheckLogFormat("logfmt", ConsoleLogType); err == nil {
+		t.Fatalf("Expected an error for an unknown format")
+	}
+	if err := CheckLogFormat(JSONLogFormat, RemoteSysLogType); err == nil {
+		t.Fatalf("Expected an error for json logs to the syslog")
+	}
+}
+
+func TestSyslogLogger(t *testing.T) {
+	defer RemoveLogger()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer pc.Close()
+
+	ConfigureLogger(&LoggerOptions{
+		LogType:        RemoteSysLogType,
+		RemoteSyslog:   "udp://" + pc.LocalAddr().String(),
+		SyslogFacility: "local3",
+	})
+	Errorf("unable to poll %s\n", "nats-0")
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// local3 is facility 19, and errors have severity 3.
+	parts := strings.SplitN(string(buf[:n]), " ", 8)
+	if len(parts) != 8 || parts[0] != "<155>1" || parts[4] != strconv.Itoa(os.Getpid()) ||
+		parts[5] != "-" || parts[6] != "-" || parts[7] != "unable to poll nats-0" {
+		t.Fatalf("Unexpected syslog message %q", buf[:n])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, parts[1]); err != nil {
+		t.Fatalf("Unexpected timestamp: %v", err)
+	}
+}
+
+func TestCheckLoggerOptions(t *testing.T) {
+	for _, opts := range []LoggerOptions{
+		{},
+		{LogType: SysLogType, SyslogFacility: "local0"},
+		{LogType: RemoteSysLogType, RemoteSyslog: "tcp://localhost:601"},
+		{LogType: RemoteSysLogType, RemoteSyslog: "unix:///dev/log"},
+	} {
+		if err := CheckLoggerOptions(&opts); err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", opts, err)
+		}
+	}
+	for _, opts := range []LoggerOptions{
+		{LogType: SysLogType, SyslogFacility: "local8"},
+		{LogType: RemoteSysLogType, RemoteSyslog: "http://localhost:601"},
+		{LogType: RemoteSysLogType, RemoteSyslog: "udp://"},
+		{LogType: SysLogType, LogFormat: JSONLogFormat},
+	} {
+		if err := CheckLoggerOptions(&opts); err == nil {
+			t.Fatalf("Expected an error for %+v", opts)
+		}
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	defer RemoveLogger()
+
+	dir, err := ioutil.TempDir("", "exporter-logs")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "exporter.log")
+
+	ConfigureLogger(&LoggerOptions{LogType: FileLogType, LogFile: path, LogSizeLimit: 100, LogMaxFiles: 2})
+	for i := 0; i < 5; i++ {
+		// Each statement is over half the size limit, filling a file.
+		Noticef("statement %d %s", i, strings.Repeat("x", 40))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected the log file and 2 rotated files, got %d", len(files))
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(string(b), "[INF] statement 4 ") || strings.Contains(string(b), "statement 3") {
+		t.Fatalf("Expected the last statement alone in the log file, got %q", b)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, files[2].Name()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(string(b), "statement 3") {
+		t.Fatalf("Expected the previous statement in the last file rotated, got %q", b)
+	}
+
+	r, err := openRotatingFile(path, 0, time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := r.Write([]byte("aged\n")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r.f.Close()
+	if b, _ := ioutil.ReadFile(path); string(b) != "aged\n" {
+		t.Fatalf("Expected the file to be rotated once aged, got %q", b)
+	}
+}
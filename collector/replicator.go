@@ -15,6 +15,7 @@
 package collector
 
 import (
+	"context"
 	"net/http"
 	"sync"
 
@@ -26,6 +27,8 @@ type replicatorCollector struct {
 
 	httpClient *http.Client
 	servers    []*CollectedServer
+	opts       *CollectorOptions
+	polls      *pollMetrics
 
 	// Replicator metrics
 	startTime    *prometheus.Desc
@@ -37,6 +40,7 @@ type replicatorCollector struct {
 	connected     *prometheus.Desc
 	connects      *prometheus.Desc
 	disconnects   *prometheus.Desc
+	restarts      *prometheus.Desc
 	bytesIn       *prometheus.Desc
 	bytesOut      *prometheus.Desc
 	messagesIn    *prometheus.Desc
@@ -80,9 +84,11 @@ func isReplicatorEndpoint(system, endpoint string) bool {
 	return system == ReplicatorSystem && endpoint == "varz"
 }
 
-func newReplicatorCollector(system string, servers []*CollectedServer) prometheus.Collector {
+func newReplicatorCollector(system string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	nc := &replicatorCollector{
-		httpClient: http.DefaultClient,
+		httpClient: newHTTPClient(opts),
+		opts:       opts,
+		polls:      newPollMetrics(system, "varz"),
 		startTime: prometheus.NewDesc(
 			prometheus.BuildFQName(system, "server", "start_time"),
 			"Start Time",
@@ -125,6 +131,12 @@ func newReplicatorCollector(system string, servers []*CollectedServer) prometheu
 			[]string{"server_id", "connector_id", "name"},
 			nil,
 		),
+		restarts: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "connector", "restarts"),
+			"Restarts after the connector lost its connection",
+			[]string{"server_id", "connector_id", "name"},
+			nil,
+		),
 		bytesIn: prometheus.NewDesc(
 			prometheus.BuildFQName(system, "connector", "bytes_in"),
 			"Bytes In",
@@ -190,8 +202,9 @@ func newReplicatorCollector(system string, servers []*CollectedServer) prometheu
 	nc.servers = make([]*CollectedServer, len(servers))
 	for i, s := range servers {
 		nc.servers[i] = &CollectedServer{
-			ID:  s.ID,
-			URL: s.URL + "/varz",
+			ID:      s.ID,
+			URL:     s.URL + "/varz",
+			Headers: s.Headers,
 		}
 	}
 
@@ -199,6 +212,7 @@ func newReplicatorCollector(system string, servers []*CollectedServer) prometheu
 }
 
 func (nc *replicatorCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
 	ch <- nc.startTime
 	ch <- nc.currentTime
 	ch <- nc.requestCount
@@ -206,6 +220,7 @@ func (nc *replicatorCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- nc.connected
 	ch <- nc.connects
 	ch <- nc.disconnects
+	ch <- nc.restarts
 	ch <- nc.bytesIn
 	ch <- nc.bytesOut
 	ch <- nc.messagesIn
@@ -220,11 +235,18 @@ func (nc *replicatorCollector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect gathers the streaming server serverz metrics.
 func (nc *replicatorCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, server := range nc.servers {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the replicator varz metrics, bounded by ctx.
+func (nc *replicatorCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
 		var resp replicatorVarz
-		if err := getMetricURL(nc.httpClient, server.URL, &resp); err != nil {
-			Debugf("ignoring server %s: %v\n", server.ID, err)
-			continue
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("varz", server.ID).Debugf("ignoring server %s: %v\n", server.ID, err)
+			return err
 		}
 
 		ch <- prometheus.MustNewConstMetric(nc.requestCount, prometheus.CounterValue, float64(resp.RequestCount), server.ID)
@@ -240,8 +262,14 @@ func (nc *replicatorCollector) Collect(ch chan<- prometheus.Metric) {
 				connected = float64(1)
 			}
 			ch <- prometheus.MustNewConstMetric(nc.connected, prometheus.GaugeValue, connected, labelValues...)
-			ch <- prometheus.MustNewConstMetric(nc.connects, prometheus.GaugeValue, float64(c.Connects), labelValues...)
-			ch <- prometheus.MustNewConstMetric(nc.disconnects, prometheus.GaugeValue, float64(c.Disconnects), labelValues...)
+			ch <- prometheus.MustNewConstMetric(nc.connects, prometheus.CounterValue, float64(c.Connects), labelValues...)
+			ch <- prometheus.MustNewConstMetric(nc.disconnects, prometheus.CounterValue, float64(c.Disconnects), labelValues...)
+			// Every connect but the first is the connector restarted.
+			restarts := c.Connects - 1
+			if restarts < 0 {
+				restarts = 0
+			}
+			ch <- prometheus.MustNewConstMetric(nc.restarts, prometheus.CounterValue, float64(restarts), labelValues...)
 			ch <- prometheus.MustNewConstMetric(nc.bytesIn, prometheus.GaugeValue, float64(c.BytesIn), labelValues...)
 			ch <- prometheus.MustNewConstMetric(nc.bytesOut, prometheus.GaugeValue, float64(c.BytesOut), labelValues...)
 			ch <- prometheus.MustNewConstMetric(nc.messagesIn, prometheus.GaugeValue, float64(c.MessagesIn), labelValues...)
@@ -253,5 +281,6 @@ func (nc *replicatorCollector) Collect(ch chan<- prometheus.Metric) {
 			ch <- prometheus.MustNewConstMetric(nc.quintile90, prometheus.GaugeValue, c.Quintile90, labelValues...)
 			ch <- prometheus.MustNewConstMetric(nc.quintile95, prometheus.GaugeValue, c.Quintile95, labelValues...)
 		}
-	}
+		return nil
+	})
 }
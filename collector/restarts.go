@@ -0,0 +1,134 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// restartsMetricName is the name of the restarts metric, whatever the
+// prefix.
+const restartsMetricName = "nats_server_restarts_total"
+
+// IsFixedMetricName reports whether a metric of the collectors is named the
+// same whatever the prefix, rather than after their system.
+func IsFixedMetricName(name string) bool {
+	return name == restartsMetricName
+}
+
+// serverRestarts tracks the server_id and start time a server reports in
+// its /varz, counting the restarts they reveal.
+type serverRestarts struct {
+	sync.Mutex
+	id    string
+	start string
+	count uint64
+}
+
+// observe records the server_id and start time reported, returning
+// whether they differ from those last reported, the server having
+// restarted since.
+func (sr *serverRestarts) observe(id, start string) bool {
+	sr.Lock()
+	defer sr.Unlock()
+
+	restarted := (sr.id != "" && id != sr.id) || (sr.start != "" && start != sr.start)
+	if restarted {
+		sr.count++
+	}
+	sr.id, sr.start = id, start
+	return restarted
+}
+
+// restarts returns the number of restarts counted.
+func (sr *serverRestarts) restarts() uint64 {
+	sr.Lock()
+	defer sr.Unlock()
+	return sr.count
+}
+
+// restartTracker detects the restarts of the servers polled at their
+// /varz, matched to them by ID.
+type restartTracker struct {
+	desc    *prometheus.Desc
+	servers map[string]*CollectedServer
+	opts    *CollectorOptions
+}
+
+func newRestartTracker(servers []*CollectedServer, opts *CollectorOptions) *restartTracker {
+	rt := &restartTracker{
+		desc: prometheus.NewDesc(restartsMetricName,
+			"Number of restarts of the server, detected by its server_id or start time changing",
+			[]string{"url"}, nil),
+		servers: make(map[string]*CollectedServer, len(servers)),
+		opts:    opts,
+	}
+	for _, s := range servers {
+		rt.servers[s.ID] = s
+	}
+	return rt
+}
+
+// observe records the /varz response of a server.  On a restart, the ID
+// the server now reports labels its metrics from the next poll on, if the
+// internal IDs are to.
+func (rt *restartTracker) observe(serverID string, varz map[string]interface{}) {
+	s, ok := rt.servers[serverID]
+	if !ok {
+		return
+	}
+	id, _ := varz["server_id"].(string)
+	start, _ := varz["start"].(string)
+	if !s.restarts.observe(id, start) {
+		return
+	}
+	rt.opts.serverLogger("varz", serverID).Noticef("Server %s at %s restarted as %s", serverID, s.URL, id)
+	if rt.opts.InternalServerIDLabel && id != "" {
+		s.id.set(id)
+	}
+}
+
+// Describe describes the restarts metric.
+func (rt *restartTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rt.desc
+}
+
+// Collect sends the restarts of each server by its URL, once for servers
+// given the same URL.
+func (rt *restartTracker) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]bool, len(rt.servers))
+	for _, s := range rt.servers {
+		u := restartsURL(s.URL)
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		ch <- prometheus.MustNewConstMetric(rt.desc, prometheus.CounterValue,
+			float64(s.restarts.restarts()), u)
+	}
+}
+
+// restartsURL returns the URL of a server without its credentials, to
+// label its restarts by.
+func restartsURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Retry defaults
+var (
+	DefaultRetryBackoff    = 500 * time.Millisecond
+	DefaultRetryMaxBackoff = 30 * time.Second
+	DefaultRetryJitter     = 0.2
+)
+
+// RetryPolicy configures how failed requests to a NATS server are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made.  Zero makes a
+	// single attempt, and a negative value retries until the request's
+	// context is done.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry.  It doubles on each
+	// following retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it.
+	Jitter float64
+}
+
+// permanentError wraps an error that retrying will not resolve.
+type permanentError struct {
+	error
+}
+
+// delay returns the backoff with jitter applied.
+func (p *RetryPolicy) delay(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return backoff
+	}
+	j := (rand.Float64()*2 - 1) * p.Jitter
+	return time.Duration(float64(backoff) * (1 + j))
+}
+
+// retry calls f until it succeeds, the attempts are exhausted, or ctx is
+// done, returning the last error.
+func (p *RetryPolicy) retry(ctx context.Context, log Logger, f func() error) error {
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if pe, ok := err.(permanentError); ok {
+			return pe.error
+		}
+		if p.MaxAttempts >= 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+		log.Debugf("Attempt %d failed, retrying: %v", attempt, err)
+		t := time.NewTimer(p.delay(backoff))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
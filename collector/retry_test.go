@@ -0,0 +1,88 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryAttempts(t *testing.T) {
+	errFail := errors.New("fail")
+
+	for _, tc := range []struct {
+		maxAttempts int
+		expected    int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	} {
+		p := &RetryPolicy{MaxAttempts: tc.maxAttempts, Backoff: time.Millisecond}
+		attempts := 0
+		err := p.retry(context.Background(), packageLogger{}, func() error {
+			attempts++
+			return errFail
+		})
+		if err != errFail {
+			t.Fatalf("Expected the last error, got %v", err)
+		}
+		if attempts != tc.expected {
+			t.Fatalf("MaxAttempts %d: expected %d attempts, got %d",
+				tc.maxAttempts, tc.expected, attempts)
+		}
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond, Jitter: 0.5}
+	attempts := 0
+	err := p.retry(context.Background(), packageLogger{}, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryUntilContextDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	p := &RetryPolicy{MaxAttempts: -1, Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	attempts := 0
+	start := time.Now()
+	err := p.retry(ctx, packageLogger{}, func() error {
+		attempts++
+		return errors.New("fail")
+	})
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Retries were not bounded by the context")
+	}
+	if attempts < 2 {
+		t.Fatalf("Expected several attempts, got %d", attempts)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffixFormat is the format of the time a log file was rotated,
+// appended to its name.
+const rotatedSuffixFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a log file renamed aside, and replaced by a new one,
+// once it reaches its size limit or maximum age, keeping up to a number
+// of the files rotated.
+type rotatingFile struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	f        *os.File
+	size     int64
+	opened   time.Time
+}
+
+// openRotatingFile opens the log file, appending to it.  Zero limits
+// disable the rotation they bound.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the log file.  Caller must lock.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// Write writes to the log file, rotating it first if it would exceed its
+// size limit or has reached its maximum age.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+		(r.maxAge > 0 && time.Since(r.opened) >= r.maxAge)) {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to rotate the log file: %v\n", err)
+		}
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the log file aside, opens a new one, and removes the
+// oldest files rotated beyond the number kept.  Caller must lock.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+	if err := os.Rename(r.path, r.path+"."+time.Now().Format(rotatedSuffixFormat)); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	if r.maxFiles <= 0 {
+		return nil
+	}
+	rotated := r.rotated()
+	for len(rotated) > r.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// rotated returns the files rotated, from the oldest.
+func (r *rotatingFile) rotated() []string {
+	matches, _ := filepath.Glob(r.path + ".*")
+	var rotated []string
+	for _, m := range matches {
+		if _, err := time.Parse(rotatedSuffixFormat, strings.TrimPrefix(m, r.path+".")); err == nil {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated
+}
+
+// textLogger logs as the file logger of the NATS server does, to a
+// writer of its own.
+type textLogger struct {
+	logger *log.Logger
+}
+
+// newTextLogger returns a logger writing the statements to w, prefixed by
+// the pid, time and level.
+func newTextLogger(w io.Writer) Logger {
+	return &textLogger{logger: log.New(w, fmt.Sprintf("[%d] ", os.Getpid()), log.LstdFlags|log.Lmicroseconds)}
+}
+
+func (l *textLogger) Noticef(format string, v ...interface{}) { l.logger.Printf("[INF] "+format, v...) }
+func (l *textLogger) Errorf(format string, v ...interface{})  { l.logger.Printf("[ERR] "+format, v...) }
+func (l *textLogger) Fatalf(format string, v ...interface{})  { l.logger.Fatalf("[FTL] "+format, v...) }
+func (l *textLogger) Debugf(format string, v ...interface{})  { l.logger.Printf("[DBG] "+format, v...) }
+func (l *textLogger) Tracef(format string, v ...interface{})  { l.logger.Printf("[TRC] "+format, v...) }
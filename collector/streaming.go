@@ -15,7 +15,9 @@
 package collector
 
 import (
+	"context"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,22 +29,29 @@ import (
 const (
 	ChannelszSuffix = "/streaming/channelsz?subs=1"
 	ServerzSuffix   = "/streaming/serverz"
+	ClientszSuffix  = "/streaming/clientsz?subs=1"
+	StorezSuffix    = "/streaming/storez"
 )
 
-// newStreamingCollector collects channelsz and serversz metrics of
-// streaming servers.
-func newStreamingCollector(system, endpoint string, servers []*CollectedServer) prometheus.Collector {
+// newStreamingCollector collects channelsz, serversz, clientsz and storez
+// metrics of streaming servers.
+func newStreamingCollector(system, endpoint string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	switch endpoint {
 	case "channelsz":
-		return newChannelsCollector(system, servers)
+		return newChannelsCollector(system, servers, opts)
 	case "serverz":
-		return newServerzCollector(system, servers)
+		return newServerzCollector(system, servers, opts)
+	case "clientsz":
+		return newClientsCollector(system, servers, opts)
+	case "storez":
+		return newStoreCollector(system, servers, opts)
 	}
 	return nil
 }
 
 func isStreamingEndpoint(system, endpoint string) bool {
-	return system == StreamingSystem && (endpoint == "channelsz" || endpoint == "serverz")
+	return system == StreamingSystem && (endpoint == "channelsz" || endpoint == "serverz" ||
+		endpoint == "clientsz" || endpoint == "storez")
 }
 
 type serverzCollector struct {
@@ -51,6 +60,8 @@ type serverzCollector struct {
 	httpClient *http.Client
 	servers    []*CollectedServer
 	system     string
+	opts       *CollectorOptions
+	polls      *pollMetrics
 
 	bytesTotal *prometheus.Desc
 	bytesIn    *prometheus.Desc
@@ -63,12 +74,15 @@ type serverzCollector struct {
 	clients    *prometheus.Desc
 	active     *prometheus.Desc
 	info       *prometheus.Desc
+	ftInfo     *prometheus.Desc
 }
 
-func newServerzCollector(system string, servers []*CollectedServer) prometheus.Collector {
+func newServerzCollector(system string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	nc := &serverzCollector{
-		httpClient: http.DefaultClient,
+		httpClient: newHTTPClient(opts),
 		system:     system,
+		opts:       opts,
+		polls:      newPollMetrics(system, "serverz"),
 		bytesTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(system, "server", "bytes_total"),
 			"Total of bytes",
@@ -135,13 +149,20 @@ func newServerzCollector(system string, servers []*CollectedServer) prometheus.C
 			[]string{"server_id", "cluster_id", "version", "go_version", "state", "role", "start_time"},
 			nil,
 		),
+		ftInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "server", "ft_info"),
+			"Fault tolerance mode and role of the server",
+			[]string{"server_id", "cluster_id", "mode", "role"},
+			nil,
+		),
 	}
 
 	nc.servers = make([]*CollectedServer, len(servers))
 	for i, s := range servers {
 		nc.servers[i] = &CollectedServer{
-			ID:  s.ID,
-			URL: s.URL + ServerzSuffix,
+			ID:      s.ID,
+			URL:     s.URL + ServerzSuffix,
+			Headers: s.Headers,
 		}
 	}
 
@@ -149,6 +170,7 @@ func newServerzCollector(system string, servers []*CollectedServer) prometheus.C
 }
 
 func (nc *serverzCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
 	ch <- nc.bytesTotal
 	ch <- nc.bytesIn
 	ch <- nc.bytesOut
@@ -160,6 +182,7 @@ func (nc *serverzCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- nc.clients
 	ch <- nc.active
 	ch <- nc.info
+	ch <- nc.ftInfo
 }
 
 // StreamingServerz represents the metrics from streaming/serverz.
@@ -184,11 +207,19 @@ type StreamingServerz struct {
 
 // Collect gathers the streaming server serverz metrics.
 func (nc *serverzCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, server := range nc.servers {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the streaming server serverz metrics, bounded
+// by ctx.
+func (nc *serverzCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
 		var resp StreamingServerz
-		if err := getMetricURL(nc.httpClient, server.URL, &resp); err != nil {
-			Debugf("ignoring server %s: %v", server.ID, err)
-			continue
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("serverz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
 		}
 
 		ch <- prometheus.MustNewConstMetric(nc.bytesTotal, prometheus.CounterValue,
@@ -213,7 +244,28 @@ func (nc *serverzCollector) Collect(ch chan<- prometheus.Metric) {
 			boolToFloat(resp.State == "FT_ACTIVE"), server.ID)
 		ch <- prometheus.MustNewConstMetric(nc.info, prometheus.GaugeValue,
 			1, server.ID, resp.ClusterID, resp.Version, resp.GoVersion, resp.State, resp.Role, resp.StartTime)
+		mode, role := streamingFTState(resp.State, resp.Role)
+		ch <- prometheus.MustNewConstMetric(nc.ftInfo, prometheus.GaugeValue,
+			1, server.ID, resp.ClusterID, mode, role)
+		return nil
+	})
+}
+
+// streamingFTState returns the fault tolerance mode of a streaming server,
+// standalone, ft or clustered, and its role in it: active or standby in a
+// fault tolerance group, or its raft role, lower cased, in a cluster.
+func streamingFTState(state, role string) (string, string) {
+	switch state {
+	case "FT_ACTIVE":
+		return "ft", "active"
+	case "FT_STANDBY":
+		return "ft", "standby"
+	case "CLUSTERED":
+		return "clustered", strings.ToLower(role)
+	case "STANDALONE":
+		return "standalone", ""
 	}
+	return strings.ToLower(state), strings.ToLower(role)
 }
 
 type channelsCollector struct {
@@ -222,23 +274,30 @@ type channelsCollector struct {
 	httpClient *http.Client
 	servers    []*CollectedServer
 	system     string
+	opts       *CollectorOptions
+	polls      *pollMetrics
 
 	chanBytesTotal   *prometheus.Desc
 	chanMsgsTotal    *prometheus.Desc
+	chanFirstSeq     *prometheus.Desc
 	chanLastSeq      *prometheus.Desc
+	chanSubs         *prometheus.Desc
 	subsLastSent     *prometheus.Desc
 	subsPendingCount *prometheus.Desc
 	subsMaxInFlight  *prometheus.Desc
+	subsLag          *prometheus.Desc
 }
 
-func newChannelsCollector(system string, servers []*CollectedServer) prometheus.Collector {
+func newChannelsCollector(system string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
 	subsVariableLabels := []string{
 		"server_id", "server_role", "channel", "client_id", "inbox", "queue_name",
 		"is_durable", "is_offline", "durable_name",
 	}
 	nc := &channelsCollector{
-		httpClient: http.DefaultClient,
+		httpClient: newHTTPClient(opts),
 		system:     system,
+		opts:       opts,
+		polls:      newPollMetrics(system, "channelsz"),
 		chanBytesTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(system, "chan", "bytes_total"),
 			"Total of bytes",
@@ -251,12 +310,24 @@ func newChannelsCollector(system string, servers []*CollectedServer) prometheus.
 			[]string{"server_id", "server_role", "channel"},
 			nil,
 		),
+		chanFirstSeq: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "first_seq"),
+			"First seq",
+			[]string{"server_id", "server_role", "channel"},
+			nil,
+		),
 		chanLastSeq: prometheus.NewDesc(
 			prometheus.BuildFQName(system, "chan", "last_seq"),
 			"Last seq",
 			[]string{"server_id", "server_role", "channel"},
 			nil,
 		),
+		chanSubs: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "subscriptions"),
+			"Number of subscriptions",
+			[]string{"server_id", "server_role", "channel"},
+			nil,
+		),
 		subsLastSent: prometheus.NewDesc(
 			prometheus.BuildFQName(system, "chan", "subs_last_sent"),
 			"Last message sent",
@@ -275,6 +346,12 @@ func newChannelsCollector(system string, servers []*CollectedServer) prometheus.
 			subsVariableLabels,
 			nil,
 		),
+		subsLag: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "subs_lag"),
+			"Messages of the channel not sent to the subscription yet",
+			subsVariableLabels,
+			nil,
+		),
 	}
 
 	// create our own deep copy, and tweak the urls to be polled
@@ -282,8 +359,9 @@ func newChannelsCollector(system string, servers []*CollectedServer) prometheus.
 	nc.servers = make([]*CollectedServer, len(servers))
 	for i, s := range servers {
 		nc.servers[i] = &CollectedServer{
-			ID:  s.ID,
-			URL: s.URL + ChannelszSuffix,
+			ID:      s.ID,
+			URL:     s.URL + ChannelszSuffix,
+			Headers: s.Headers,
 		}
 	}
 
@@ -291,37 +369,51 @@ func newChannelsCollector(system string, servers []*CollectedServer) prometheus.
 }
 
 func (nc *channelsCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
 	ch <- nc.chanBytesTotal
 	ch <- nc.chanMsgsTotal
+	ch <- nc.chanFirstSeq
 	ch <- nc.chanLastSeq
+	ch <- nc.chanSubs
 	ch <- nc.subsLastSent
 	ch <- nc.subsPendingCount
 	ch <- nc.subsMaxInFlight
+	ch <- nc.subsLag
 }
 
-func getRoleFromChannelszURL(client *http.Client, url string) (string, error) {
+func getRoleFromChannelszURL(ctx context.Context, client *http.Client, opts *CollectorOptions,
+	url string, headers http.Header) (string, error) {
 	if !strings.HasSuffix(url, ChannelszSuffix) {
 		return "", nil
 	}
 
 	var newURL = (strings.TrimSuffix(url, ChannelszSuffix) + ServerzSuffix)
 	var serverResp StreamingServerz
-	if err := getMetricURL(client, newURL, &serverResp); err != nil {
+	if err := getMetricURL(ctx, client, opts, newURL, headers, &serverResp); err != nil {
 		return "", err
 	}
 	return serverResp.Role, nil
 }
 
+// Collect gathers the streaming server channelsz metrics.
 func (nc *channelsCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, server := range nc.servers {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the streaming server channelsz metrics, bounded
+// by ctx.
+func (nc *channelsCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
 		var resp Channelsz
-		if err := getMetricURL(nc.httpClient, server.URL, &resp); err != nil {
-			Debugf("ignoring server %s: %v", server.ID, err)
-			continue
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("channelsz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
 		}
-		serverRole, err := getRoleFromChannelszURL(nc.httpClient, server.URL)
+		serverRole, err := getRoleFromChannelszURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers)
 		if err != nil {
-			Debugf("error getting server role %s: %v", server.ID, err)
+			nc.opts.serverLogger("channelsz", server.ID).Debugf("error getting server role %s: %v", server.ID, err)
 		}
 
 		for _, channel := range resp.Channels {
@@ -329,8 +421,12 @@ func (nc *channelsCollector) Collect(ch chan<- prometheus.Metric) {
 				float64(channel.Bytes), server.ID, serverRole, channel.Name)
 			ch <- prometheus.MustNewConstMetric(nc.chanMsgsTotal, prometheus.GaugeValue,
 				float64(channel.Msgs), server.ID, serverRole, channel.Name)
+			ch <- prometheus.MustNewConstMetric(nc.chanFirstSeq, prometheus.GaugeValue,
+				float64(channel.FirstSeq), server.ID, serverRole, channel.Name)
 			ch <- prometheus.MustNewConstMetric(nc.chanLastSeq, prometheus.GaugeValue,
 				float64(channel.LastSeq), server.ID, serverRole, channel.Name)
+			ch <- prometheus.MustNewConstMetric(nc.chanSubs, prometheus.GaugeValue,
+				float64(len(channel.Subscriptions)), server.ID, serverRole, channel.Name)
 
 			for _, sub := range channel.Subscriptions {
 
@@ -351,9 +447,16 @@ func (nc *channelsCollector) Collect(ch chan<- prometheus.Metric) {
 					float64(sub.PendingCount), labelValues...)
 				ch <- prometheus.MustNewConstMetric(nc.subsMaxInFlight, prometheus.GaugeValue,
 					float64(sub.MaxInflight), labelValues...)
+				var lag uint64
+				if channel.LastSeq > sub.LastSent {
+					lag = channel.LastSeq - sub.LastSent
+				}
+				ch <- prometheus.MustNewConstMetric(nc.subsLag, prometheus.GaugeValue,
+					float64(lag), labelValues...)
 			}
 		}
-	}
+		return nil
+	})
 }
 
 // Channelsz lists the name of all NATS Streaming Channelsz
@@ -393,3 +496,330 @@ type Subscriptionz struct {
 	PendingCount int    `json:"pending_count"`
 	IsStalled    bool   `json:"is_stalled"`
 }
+
+type clientsCollector struct {
+	sync.Mutex
+
+	httpClient *http.Client
+	servers    []*CollectedServer
+	system     string
+	opts       *CollectorOptions
+	polls      *pollMetrics
+
+	clientSubs         *prometheus.Desc
+	clientPendingCount *prometheus.Desc
+}
+
+func newClientsCollector(system string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
+	nc := &clientsCollector{
+		httpClient: newHTTPClient(opts),
+		system:     system,
+		opts:       opts,
+		polls:      newPollMetrics(system, "clientsz"),
+		clientSubs: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "client", "subscriptions"),
+			"Number of subscriptions",
+			[]string{"server_id", "client_id"},
+			nil,
+		),
+		clientPendingCount: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "client", "pending_count"),
+			"Pending message count of all the subscriptions",
+			[]string{"server_id", "client_id"},
+			nil,
+		),
+	}
+
+	nc.servers = make([]*CollectedServer, len(servers))
+	for i, s := range servers {
+		nc.servers[i] = &CollectedServer{
+			ID:      s.ID,
+			URL:     s.URL + ClientszSuffix,
+			Headers: s.Headers,
+		}
+	}
+
+	return nc
+}
+
+func (nc *clientsCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
+	ch <- nc.clientSubs
+	ch <- nc.clientPendingCount
+}
+
+// Collect gathers the streaming server clientsz metrics.
+func (nc *clientsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the streaming server clientsz metrics, bounded
+// by ctx.
+func (nc *clientsCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
+		var resp Clientsz
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("clientsz", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
+		}
+
+		for _, client := range resp.Clients {
+			subs, pending := 0, 0
+			for _, channelSubs := range client.Subscriptions {
+				subs += len(channelSubs)
+				for _, sub := range channelSubs {
+					pending += sub.PendingCount
+				}
+			}
+			ch <- prometheus.MustNewConstMetric(nc.clientSubs, prometheus.GaugeValue,
+				float64(subs), server.ID, client.ID)
+			ch <- prometheus.MustNewConstMetric(nc.clientPendingCount, prometheus.GaugeValue,
+				float64(pending), server.ID, client.ID)
+		}
+		return nil
+	})
+}
+
+// Clientsz lists the clients of a NATS Streaming server
+type Clientsz struct {
+	ClusterID string     `json:"cluster_id"`
+	ServerID  string     `json:"server_id"`
+	Now       time.Time  `json:"now"`
+	Offset    int        `json:"offset"`
+	Limit     int        `json:"limit"`
+	Count     int        `json:"count"`
+	Total     int        `json:"total"`
+	Clients   []*Clientz `json:"clients"`
+}
+
+// Clientz describes a NATS Streaming client, and its subscriptions by
+// channel
+type Clientz struct {
+	ID            string                      `json:"id"`
+	HBInbox       string                      `json:"hb_inbox"`
+	Subscriptions map[string][]*Subscriptionz `json:"subscriptions,omitempty"`
+}
+
+type storeCollector struct {
+	sync.Mutex
+
+	httpClient *http.Client
+	servers    []*CollectedServer
+	system     string
+	opts       *CollectorOptions
+	polls      *pollMetrics
+
+	maxChannels  *prometheus.Desc
+	chanMaxMsgs  *prometheus.Desc
+	chanMaxBytes *prometheus.Desc
+	chanMaxAge   *prometheus.Desc
+	chanMaxSubs  *prometheus.Desc
+}
+
+func newStoreCollector(system string, servers []*CollectedServer, opts *CollectorOptions) prometheus.Collector {
+	nc := &storeCollector{
+		httpClient: newHTTPClient(opts),
+		system:     system,
+		opts:       opts,
+		polls:      newPollMetrics(system, "storez"),
+		maxChannels: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "store", "max_channels"),
+			"Maximum number of channels, 0 if unlimited",
+			[]string{"server_id", "type"},
+			nil,
+		),
+		chanMaxMsgs: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "max_msgs"),
+			"Maximum number of messages stored, 0 if unlimited",
+			[]string{"server_id", "channel"},
+			nil,
+		),
+		chanMaxBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "max_bytes"),
+			"Maximum number of bytes stored, 0 if unlimited",
+			[]string{"server_id", "channel"},
+			nil,
+		),
+		chanMaxAge: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "max_age_seconds"),
+			"Maximum age of the messages stored, 0 if unlimited",
+			[]string{"server_id", "channel"},
+			nil,
+		),
+		chanMaxSubs: prometheus.NewDesc(
+			prometheus.BuildFQName(system, "chan", "max_subscriptions"),
+			"Maximum number of subscriptions, 0 if unlimited",
+			[]string{"server_id", "channel"},
+			nil,
+		),
+	}
+
+	nc.servers = make([]*CollectedServer, len(servers))
+	for i, s := range servers {
+		nc.servers[i] = &CollectedServer{
+			ID:      s.ID,
+			URL:     s.URL + StorezSuffix,
+			Headers: s.Headers,
+		}
+	}
+
+	return nc
+}
+
+func (nc *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.polls.Describe(ch)
+	ch <- nc.maxChannels
+	ch <- nc.chanMaxMsgs
+	ch <- nc.chanMaxBytes
+	ch <- nc.chanMaxAge
+	ch <- nc.chanMaxSubs
+}
+
+// Collect gathers the streaming server storez metrics.
+func (nc *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext gathers the streaming server storez metrics, bounded
+// by ctx.  The limits are exported for each channel of the server, along
+// with its usage by the channelsz collector.
+func (nc *storeCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pollServers(ctx, nc.servers, nc.opts, nc.polls, ch, func(ctx context.Context, server *CollectedServer) error {
+		var resp Storez
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, server.URL, server.Headers, &resp); err != nil {
+			nc.opts.serverLogger("storez", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
+		}
+		var channels Channelsz
+		channelszURL := strings.TrimSuffix(server.URL, StorezSuffix) + "/streaming/channelsz"
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, channelszURL, server.Headers, &channels); err != nil {
+			nc.opts.serverLogger("storez", server.ID).Debugf("ignoring server %s: %v", server.ID, err)
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(nc.maxChannels, prometheus.GaugeValue,
+			float64(resp.Limits.MaxChannels), server.ID, resp.Type)
+		for _, name := range channels.Names {
+			limits := resp.Limits.channelLimits(name)
+			ch <- prometheus.MustNewConstMetric(nc.chanMaxMsgs, prometheus.GaugeValue,
+				float64(limits.MaxMsgs), server.ID, name)
+			ch <- prometheus.MustNewConstMetric(nc.chanMaxBytes, prometheus.GaugeValue,
+				float64(limits.MaxBytes), server.ID, name)
+			ch <- prometheus.MustNewConstMetric(nc.chanMaxAge, prometheus.GaugeValue,
+				limits.MaxAge.Seconds(), server.ID, name)
+			ch <- prometheus.MustNewConstMetric(nc.chanMaxSubs, prometheus.GaugeValue,
+				float64(limits.MaxSubscriptions), server.ID, name)
+		}
+		return nil
+	})
+}
+
+// Storez describes the store of a NATS Streaming server
+type Storez struct {
+	ClusterID  string      `json:"cluster_id"`
+	ServerID   string      `json:"server_id"`
+	Now        time.Time   `json:"now"`
+	Type       string      `json:"type"`
+	Limits     StoreLimits `json:"limits"`
+	TotalMsgs  int         `json:"total_msgs"`
+	TotalBytes uint64      `json:"total_bytes"`
+}
+
+// StoreLimits are the limits of a NATS Streaming store, global and by
+// channel.  The channels of the per-channel limits may be wildcards.
+type StoreLimits struct {
+	MaxChannels int `json:"max_channels"`
+	ChannelLimits
+	PerChannel map[string]*ChannelLimits `json:"channels,omitempty"`
+}
+
+// ChannelLimits are the limits of a NATS Streaming channel.  A global limit
+// of 0 is unlimited, while a per-channel limit of 0 inherits the limit of
+// the channel's wider match and one below 0 is unlimited.
+type ChannelLimits struct {
+	MaxMsgs          int           `json:"max_msgs"`
+	MaxBytes         int64         `json:"max_bytes"`
+	MaxAge           time.Duration `json:"max_age"`
+	MaxSubscriptions int           `json:"max_subscriptions"`
+	MaxInactivity    time.Duration `json:"max_inactivity"`
+}
+
+// channelLimits returns the limits applied to a channel, the per-channel
+// limits matching it inheriting from the widest to the narrowest, as the
+// server does.
+func (sl *StoreLimits) channelLimits(channel string) ChannelLimits {
+	var matches []string
+	for name := range sl.PerChannel {
+		if subjectMatches(name, channel) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return subjectNarrower(matches[j], matches[i])
+	})
+
+	limits := sl.ChannelLimits
+	for _, name := range matches {
+		cl := sl.PerChannel[name]
+		if cl == nil {
+			continue
+		}
+		limits.MaxMsgs = int(inheritLimit(int64(cl.MaxMsgs), int64(limits.MaxMsgs)))
+		limits.MaxBytes = inheritLimit(cl.MaxBytes, limits.MaxBytes)
+		limits.MaxAge = time.Duration(inheritLimit(int64(cl.MaxAge), int64(limits.MaxAge)))
+		limits.MaxSubscriptions = int(inheritLimit(int64(cl.MaxSubscriptions), int64(limits.MaxSubscriptions)))
+		limits.MaxInactivity = time.Duration(inheritLimit(int64(cl.MaxInactivity), int64(limits.MaxInactivity)))
+	}
+	return limits
+}
+
+// inheritLimit returns a per-channel limit, inherited if 0 and unlimited if
+// below 0.
+func inheritLimit(limit, inherited int64) int64 {
+	switch {
+	case limit < 0:
+		return 0
+	case limit == 0:
+		return inherited
+	}
+	return limit
+}
+
+// subjectMatches returns whether the literal subject matches pattern,
+// possibly a wildcard.
+func subjectMatches(pattern, subject string) bool {
+	pt, st := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, t := range pt {
+		if t == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) || (t != "*" && t != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// subjectNarrower returns whether, of two subjects matching the same
+// channel, a is the narrower: without a full wildcard when b has one, or
+// with more literal tokens.
+func subjectNarrower(a, b string) bool {
+	if fa, fb := strings.HasSuffix(a, ">"), strings.HasSuffix(b, ">"); fa != fb {
+		return fb
+	}
+	return literalTokens(a) > literalTokens(b)
+}
+
+func literalTokens(subject string) int {
+	n := 0
+	for _, t := range strings.Split(subject, ".") {
+		if t != "*" && t != ">" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// subszDetail reports whether subsz is polled with the list of the
+// subscriptions, enabled by its subs query parameter.
+func subszDetail(opts *CollectorOptions) bool {
+	subs, _ := strconv.ParseBool(opts.EndpointParams["subsz"].Get("subs"))
+	return subs
+}
+
+// getSubszPages follows the pages of the subscriptions listed by subsz
+// after the first, given by response, until all are retrieved, adding
+// them to its subscriptions_list.
+func (nc *NATSCollector) getSubszPages(ctx context.Context, server *CollectedServer,
+	response map[string]interface{}) error {
+	subs, _ := response["subscriptions_list"].([]interface{})
+	total, _ := response["total"].(float64)
+	offset, _ := response["offset"].(float64)
+
+	listed := len(subs)
+	for pages := 1; listed > 0 && int(offset)+len(subs) < int(total); pages++ {
+		if pages == maxPages(nc.opts) {
+			nc.opts.serverLogger(nc.endpoint, server.ID).Debugf("subsz of server %s truncated to %d pages", server.ID, pages)
+			break
+		}
+		u, err := pageURL(server.URL, int(offset)+len(subs))
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Subs []interface{} `json:"subscriptions_list"`
+		}
+		if err := getMetricURL(ctx, nc.httpClient, nc.opts, u, server.Headers, &page); err != nil {
+			return err
+		}
+		listed = len(page.Subs)
+		subs = append(subs, page.Subs...)
+	}
+	response["subscriptions_list"] = subs
+	return nil
+}
+
+// CheckSubjectAggregations returns an error if one of the subject patterns
+// the subscriptions are counted by is not a valid subject, where * stands
+// for a token and a final > for one or more.
+func CheckSubjectAggregations(patterns []string) error {
+	for _, pattern := range patterns {
+		tokens := strings.Split(pattern, ".")
+		for i, t := range tokens {
+			if t == "" || strings.ContainsAny(t, " \t") || (t == ">" && i != len(tokens)-1) ||
+				(len(t) > 1 && strings.ContainsAny(t, "*>")) {
+				return fmt.Errorf("invalid subject pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// otherSubject counts the subscriptions to subjects matching none of the
+// patterns, whose number is unbounded.
+const otherSubject = "other"
+
+// aggregateSubject returns the first of the patterns the subject matches,
+// or otherSubject if none.
+func aggregateSubject(subject string, patterns []string) string {
+	for _, pattern := range patterns {
+		if subjectMatches(pattern, subject) {
+			return pattern
+		}
+	}
+	return otherSubject
+}
+
+// collectSubjects sends the number of subscriptions of a server listed by
+// subsz by the SubjectAggregations pattern their subject matches.
+func (nc *NATSCollector) collectSubjects(serverID string, response map[string]interface{}, ch chan<- prometheus.Metric) {
+	subs, _ := response["subscriptions_list"].([]interface{})
+	counts := make(map[string]int)
+	for _, s := range subs {
+		sub, _ := s.(map[string]interface{})
+		subject, _ := sub["subject"].(string)
+		if subject == "" {
+			continue
+		}
+		counts[aggregateSubject(subject, nc.opts.SubjectAggregations)]++
+	}
+	for subject, n := range counts {
+		ch <- prometheus.MustNewConstMetric(nc.subjects, prometheus.GaugeValue, float64(n), serverID, subject)
+	}
+}
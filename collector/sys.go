@@ -0,0 +1,271 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/go-nats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// sysPingSubject is the subject the servers of a system account
+	// answer with their statsz.  The other endpoints are requested on a
+	// token of their own, e.g. $SYS.REQ.SERVER.PING.VARZ.
+	sysPingSubject = "$SYS.REQ.SERVER.PING"
+
+	// DefaultSysResponseWait is how long the responses of the servers to a
+	// system account request are waited for, there being no telling how
+	// many servers will answer.
+	DefaultSysResponseWait = time.Second
+)
+
+// IsSysEndpoint returns whether the endpoint can be requested from the
+// servers of the system account rather than polled over HTTP.
+func IsSysEndpoint(endpoint string) bool {
+	switch endpoint {
+	case "statsz", "varz", "connz":
+		return true
+	}
+	return false
+}
+
+// sysSubject returns the subject the servers are requested an endpoint on.
+func sysSubject(endpoint string) string {
+	if endpoint == "statsz" {
+		return sysPingSubject
+	}
+	return sysPingSubject + "." + strings.ToUpper(endpoint)
+}
+
+// sysResponse is the response of a server to a system account request:
+// the statsz of the server, or the data of the endpoint requested.
+type sysResponse struct {
+	Server struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"server"`
+	Statsz map[string]interface{} `json:"statsz"`
+	Data   map[string]interface{} `json:"data"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// sysCollector collects an endpoint of all the servers answering a request
+// on the system account, the servers discovered by their responses rather
+// than configured.
+type sysCollector struct {
+	sync.Mutex
+
+	conn     func() (*nats.Conn, error)
+	system   string
+	endpoint string
+	opts     *CollectorOptions
+	wait     time.Duration
+	up       *prometheus.Desc
+	descs    map[string]*prometheus.Desc
+}
+
+// NewSysCollector creates a collector requesting an endpoint, statsz, varz
+// or connz, from the servers over the connection to the system account
+// returned by conn, rather than polling their HTTP monitor port.  The
+// numeric fields of the responses are exported as the collector of the
+// endpoint would, labeled by the ID of each server answering.
+func NewSysCollector(endpoint, prefix string, conn func() (*nats.Conn, error),
+	opts *CollectorOptions) prometheus.Collector {
+	if opts == nil {
+		opts = &CollectorOptions{}
+	}
+	system := getSystem(CoreSystem, prefix)
+	return &sysCollector{
+		conn:     conn,
+		system:   system,
+		endpoint: endpoint,
+		opts:     opts,
+		wait:     DefaultSysResponseWait,
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(system, endpoint, "up"),
+			"Whether the server answered the last request",
+			[]string{"server_id"},
+			nil,
+		),
+		descs: make(map[string]*prometheus.Desc),
+	}
+}
+
+// Describe describes the up metric, and those of the fields of the
+// responses so far.
+func (nc *sysCollector) Describe(ch chan<- *prometheus.Desc) {
+	nc.Lock()
+	defer nc.Unlock()
+	ch <- nc.up
+	for _, d := range nc.descs {
+		ch <- d
+	}
+}
+
+// Collect requests the endpoint from the servers.
+func (nc *sysCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := collectContext(nc.opts)
+	defer cancel()
+	nc.CollectWithContext(ctx, ch)
+}
+
+// CollectWithContext requests the endpoint from the servers, waiting for
+// their responses until ctx is done at the latest.
+func (nc *sysCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	nc.Lock()
+	defer nc.Unlock()
+
+	ctx, span := StartSpan(ctx, "collect "+nc.endpoint)
+	defer span.Finish(nil)
+	span.SetAttribute("nats.system", nc.system)
+	span.SetAttribute("nats.endpoint", nc.endpoint)
+
+	start := time.Now()
+	resps, err := nc.request(ctx)
+	if err != nil {
+		nc.opts.serverLogger(nc.endpoint, "").Errorf("Unable to request %s from the system account: %v", nc.endpoint, err)
+		return
+	}
+	for _, r := range resps {
+		id := r.Server.ID
+		var err error
+		if r.Error != nil {
+			err = fmt.Errorf("%s (%d)", r.Error.Description, r.Error.Code)
+			nc.opts.serverLogger(nc.endpoint, id).Debugf("ignoring server %s: %v", id, err)
+		}
+		if nc.opts.OnPoll != nil {
+			nc.opts.OnPoll(id, err)
+		}
+		if nc.opts.OnPollStatus != nil {
+			nc.opts.OnPollStatus(PollStatus{ServerID: id, Endpoint: nc.endpoint, Time: start,
+				Duration: time.Since(start), Err: err})
+		}
+		ch <- prometheus.MustNewConstMetric(nc.up, prometheus.GaugeValue, boolToFloat(err == nil), id)
+		if err != nil {
+			continue
+		}
+		fields := r.Data
+		if nc.endpoint == "statsz" {
+			fields = r.Statsz
+		}
+		nc.collectFields("", fields, id, ch)
+	}
+}
+
+// request publishes the request of the endpoint and returns the responses
+// received within the wait.
+func (nc *sysCollector) request(ctx context.Context) ([]*sysResponse, error) {
+	conn, err := nc.conn()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make(chan *nats.Msg, 64)
+	inbox := nats.NewInbox()
+	sub, err := conn.ChanSubscribe(inbox, msgs)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	if err := conn.PublishRequest(sysSubject(nc.endpoint), inbox, nil); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(nc.wait)
+	defer timer.Stop()
+	var resps []*sysResponse
+	seen := make(map[string]bool)
+	for {
+		select {
+		case m := <-msgs:
+			r := &sysResponse{}
+			if err := json.Unmarshal(m.Data, r); err != nil {
+				nc.opts.logger().Debugf("Ignoring a %s response: %v", nc.endpoint, err)
+				continue
+			}
+			// A server answering twice, e.g. through two routes, is only
+			// exported once.
+			if r.Server.ID == "" || seen[r.Server.ID] {
+				continue
+			}
+			seen[r.Server.ID] = true
+			if nc.opts.OnResponse != nil {
+				nc.opts.OnResponse(r.Server.ID, nc.endpoint, m.Data)
+			}
+			resps = append(resps, r)
+		case <-timer.C:
+			return resps, nil
+		case <-ctx.Done():
+			return resps, nil
+		}
+	}
+}
+
+// collectFields sends the numeric fields of a response as gauges, those of
+// nested objects named after the object, e.g. statsz_sent_msgs.
+func (nc *sysCollector) collectFields(prefix string, fields map[string]interface{}, id string,
+	ch chan<- prometheus.Metric) {
+	for k, v := range fields {
+		// Keys of nested maps are not always names, e.g. the paths of
+		// varz http_req_stats.
+		if k = sysFieldName(k); k == "" {
+			continue
+		}
+		switch v := v.(type) {
+		case float64:
+			ch <- prometheus.MustNewConstMetric(nc.desc(prefix+k), prometheus.GaugeValue, v, id)
+		case map[string]interface{}:
+			nc.collectFields(prefix+k+"_", v, id, ch)
+		}
+	}
+}
+
+// sysFieldName replaces the characters of a key invalid in a metric name,
+// trimming those at either end, e.g. /varz is named varz.
+func sysFieldName(k string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, k), "_")
+}
+
+// desc returns the description of the metric of a field, renamed as
+// configured.  Caller must lock.
+func (nc *sysCollector) desc(field string) *prometheus.Desc {
+	if d, ok := nc.descs[field]; ok {
+		return d
+	}
+	name, help := prometheus.BuildFQName(nc.system, nc.endpoint, field), field
+	if r, ok := nc.opts.MetricRenames[nc.endpoint+"."+field]; ok {
+		name = r.Name
+		if r.Help != "" {
+			help = r.Help
+		}
+	}
+	d := prometheus.NewDesc(name, help, []string{"server_id"}, nil)
+	nc.descs[field] = d
+	return d
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSyslogFacility is the facility of the statements logged to the
+// syslog.
+const DefaultSyslogFacility = "daemon"
+
+// syslogFacilities are the codes of the syslog facilities, by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities
+const (
+	syslogCrit   = 2
+	syslogErr    = 3
+	syslogNotice = 5
+	syslogDebug  = 7
+)
+
+// localSyslogPaths are the sockets of the local syslog daemon.
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogFacility returns the code of the facility, the default one if
+// empty.
+func syslogFacility(name string) (int, error) {
+	if name == "" {
+		name = DefaultSyslogFacility
+	}
+	f, ok := syslogFacilities[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid syslog facility %q", name)
+	}
+	return f, nil
+}
+
+// parseSyslogURL returns the network and address of a remote syslog, a
+// udp, tcp or unix URL.
+func parseSyslogURL(s string) (string, string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid remote syslog %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid remote syslog %q: the host is missing", s)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		return "unix", u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid remote syslog %q, udp, tcp or unix expected", s)
+}
+
+// checkSyslog checks the syslog options.
+func checkSyslog(opts *LoggerOptions) error {
+	if _, err := syslogFacility(opts.SyslogFacility); err != nil {
+		return err
+	}
+	if opts.LogType == RemoteSysLogType {
+		if _, _, err := parseSyslogURL(opts.RemoteSyslog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syslogLogger writes the statements to a syslog daemon in the RFC 5424
+// format, connecting again after a write fails.
+type syslogLogger struct {
+	sync.Mutex
+	network  string // Empty for the local syslog.
+	addr     string
+	facility int
+	hostname string
+	tag      string
+	conn     net.Conn
+	stream   bool
+}
+
+// newSyslogLogger returns a logger writing to the syslog at addr, on the
+// udp, tcp or unix network, or to the local syslog if network is empty.
+func newSyslogLogger(network, addr string, facility int) (*syslogLogger, error) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	l := &syslogLogger{
+		network:  network,
+		addr:     addr,
+		facility: facility,
+		hostname: hostname,
+		tag:      filepath.Base(os.Args[0]),
+	}
+	if err := l.connect(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// connect connects to the syslog.  Caller must lock.
+func (l *syslogLogger) connect() error {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	if l.network == "udp" || l.network == "tcp" {
+		c, err := net.DialTimeout(l.network, l.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		l.conn, l.stream = c, l.network == "tcp"
+		return nil
+	}
+	paths := localSyslogPaths
+	if l.network == "unix" {
+		paths = []string{l.addr}
+	}
+	for _, path := range paths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if c, err := net.Dial(network, path); err == nil {
+				l.conn, l.stream = c, network == "unix"
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unable to connect to the syslog at %s", strings.Join(paths, ", "))
+}
+
+// message returns the RFC 5424 message of a statement, framed by its
+// length on TCP as RFC 6587 requires, or by a newline on a local stream
+// socket.  Caller must lock.
+func (l *syslogLogger) message(severity int, msg string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - %s", l.facility*8+severity,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"), l.hostname, l.tag, os.Getpid(),
+		strings.TrimRight(msg, "\n"))
+	switch {
+	case l.network == "tcp":
+		return append([]byte(strconv.Itoa(b.Len())+" "), b.Bytes()...)
+	case l.stream:
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+func (l *syslogLogger) write(severity int, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	l.Lock()
+	defer l.Unlock()
+	if l.conn != nil {
+		if _, err := l.conn.Write(l.message(severity, msg)); err == nil {
+			return
+		}
+	}
+	if err := l.connect(); err != nil {
+		return
+	}
+	l.conn.Write(l.message(severity, msg))
+}
+
+func (l *syslogLogger) Noticef(format string, v ...interface{}) { l.write(syslogNotice, format, v...) }
+func (l *syslogLogger) Fatalf(format string, v ...interface{})  { l.write(syslogCrit, format, v...) }
+func (l *syslogLogger) Errorf(format string, v ...interface{})  { l.write(syslogErr, format, v...) }
+func (l *syslogLogger) Debugf(format string, v ...interface{})  { l.write(syslogDebug, format, v...) }
+func (l *syslogLogger) Tracef(format string, v ...interface{})  { l.write(syslogDebug, format, v...) }
@@ -0,0 +1,128 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation of a trace, e.g. a scrape, the collection of
+// an endpoint or a request to a NATS server.  Spans are only recorded
+// within a trace started by StartTrace, and all the methods of a nil span
+// do nothing.
+type Span struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte // Zero for the root span of the trace.
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Err          error // The error the operation failed with, if any.
+
+	trace *spanTrace
+}
+
+// spanTrace holds the spans ended within a trace until its root span ends.
+type spanTrace struct {
+	sync.Mutex
+	spans  []*Span
+	export func(spans []*Span)
+	ended  bool
+}
+
+type spanContextKey struct{}
+
+// StartTrace starts the root span of a new trace.  Once it ends, export is
+// called with the spans of the trace ended by then, the root span last.
+func StartTrace(ctx context.Context, name string, export func(spans []*Span)) (context.Context, *Span) {
+	s := &Span{Name: name, Start: time.Now(), trace: &spanTrace{export: export}}
+	rand.Read(s.TraceID[:])
+	rand.Read(s.SpanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// StartSpan starts a span, the child of the span of ctx.  It returns a nil
+// span, recording nothing, if ctx has none.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent, _ := ctx.Value(spanContextKey{}).(*Span)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		TraceID:      parent.TraceID,
+		ParentSpanID: parent.SpanID,
+		Name:         name,
+		Start:        time.Now(),
+		trace:        parent.trace,
+	}
+	rand.Read(s.SpanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span, failed with err if not nil.  Spans ended after the
+// root span of their trace are dropped.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End, s.Err = time.Now(), err
+
+	t := s.trace
+	t.Lock()
+	if t.ended {
+		t.Unlock()
+		return
+	}
+	t.spans = append(t.spans, s)
+	root := s.ParentSpanID == [8]byte{}
+	if root {
+		t.ended = true
+	}
+	spans := t.spans
+	t.Unlock()
+
+	if root && t.export != nil {
+		t.export(spans)
+	}
+}
+
+// traceParent returns the W3C traceparent header of the span.
+func (s *Span) traceParent() string {
+	return "00-" + hex.EncodeToString(s.TraceID[:]) + "-" + hex.EncodeToString(s.SpanID[:]) + "-01"
+}
+
+// setTraceParent propagates the span of the request's context to the
+// server, if any.
+func setTraceParent(req *http.Request, s *Span) {
+	if s != nil {
+		req.Header.Set("traceparent", s.traceParent())
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unitField is the unit normalized metric of a monitor field.
+type unitField struct {
+	// name of the metric, with its unit suffix.
+	name string
+
+	// help of the metric.
+	help string
+
+	// value converts the field to the base unit.
+	value func(v interface{}) (float64, bool)
+}
+
+// unitFields are the monitor fields with a unit, given by the endpoint and
+// field name, and their metrics in the base units of Prometheus.
+var unitFields = map[string]unitField{
+	"varz.mem":              {"mem_bytes", "Resident memory in bytes", unitNumber},
+	"varz.cpu":              {"cpu_ratio", "CPU usage as a ratio of one core", unitPercent},
+	"varz.uptime":           {"uptime_seconds", "Time since the server started in seconds", unitUptime},
+	"varz.start":            {"start_time_seconds", "Start time of the server in seconds since the epoch", unitTime},
+	"varz.max_payload":      {"max_payload_bytes", "Maximum message payload in bytes", unitNumber},
+	"varz.max_pending":      {"max_pending_bytes", "Maximum pending bytes of a connection", unitNumber},
+	"varz.max_control_line": {"max_control_line_bytes", "Maximum control line in bytes", unitNumber},
+	"varz.ping_interval":    {"ping_interval_seconds", "Interval between pings in seconds", unitNanoseconds},
+	"varz.write_deadline":   {"write_deadline_seconds", "Write deadline of a connection in seconds", unitNanoseconds},
+	"varz.auth_timeout":     {"auth_timeout_seconds", "Authorization timeout in seconds", unitNumber},
+	"varz.tls_timeout":      {"tls_timeout_seconds", "TLS handshake timeout in seconds", unitNumber},
+	"subsz.cache_hit_rate":  {"cache_hit_ratio", "Ratio of subscription matches found in the cache", unitNumber},
+}
+
+func unitNumber(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func unitPercent(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f / 100, ok
+}
+
+func unitNanoseconds(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f / float64(time.Second), ok
+}
+
+func unitTime(v interface{}) (float64, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, false
+	}
+	return float64(t.UnixNano()) / float64(time.Second), true
+}
+
+// uptimeRegex matches the uptime reported by the server, e.g. 1y2d3h4m5s.
+var uptimeRegex = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$`)
+
+// uptimeUnits are the seconds in each unit of uptimeRegex.
+var uptimeUnits = []float64{365 * 24 * 3600, 24 * 3600, 3600, 60, 1}
+
+func unitUptime(v interface{}) (float64, bool) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return 0, false
+	}
+	m := uptimeRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	var secs float64
+	for i, n := range m[1:] {
+		if n == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, false
+		}
+		secs += f * uptimeUnits[i]
+	}
+	return secs, true
+}
+
+// unitGaugeVec is the gauge of a monitor field normalized to its base
+// unit, also serving the legacy gauge of the field if set.
+type unitGaugeVec struct {
+	*prometheus.GaugeVec
+	value  func(v interface{}) (float64, bool)
+	legacy *prometheus.GaugeVec
+}
+
+// newUnitGaugeVec creates the normalized gauge of a monitor field, named
+// after the rename of the field if it has one.
+func (nc *NATSCollector) newUnitGaugeVec(field, namespace string, u unitField, value interface{}) *unitGaugeVec {
+	ug := &unitGaugeVec{value: u.value}
+	if r, ok := nc.opts.MetricRenames[nc.endpoint+"."+field]; ok {
+		ug.GaugeVec = newRenamedGaugeVec(field, r, nc.opts.logger())
+	} else {
+		ug.GaugeVec = newPrometheusGaugeVec(nc.system, nc.endpoint, u.name, u.help, namespace, nc.opts.logger())
+	}
+	if _, isNumber := value.(float64); isNumber && nc.opts.LegacyMetricNames {
+		ug.legacy = newPrometheusGaugeVec(nc.system, nc.endpoint, field, "", namespace, nc.opts.logger())
+	}
+	return ug
+}
+
+// Describe describes the normalized and legacy gauges.
+func (ug *unitGaugeVec) Describe(ch chan<- *prometheus.Desc) {
+	ug.GaugeVec.Describe(ch)
+	if ug.legacy != nil {
+		ug.legacy.Describe(ch)
+	}
+}
+
+// Collect collects the normalized and legacy gauges.
+func (ug *unitGaugeVec) Collect(ch chan<- prometheus.Metric) {
+	ug.GaugeVec.Collect(ch)
+	if ug.legacy != nil {
+		ug.legacy.Collect(ch)
+	}
+}
@@ -0,0 +1,455 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/nats-io/gnatsd/conf"
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/nats-io/prometheus-nats-exporter/exporter"
+	"github.com/prometheus/common/model"
+)
+
+// fileConfig holds the settings of a configuration file that are not
+// flags.
+type fileConfig struct {
+	servers        []configServer
+	metricNames    map[string]collector.MetricRename
+	relabelConfigs []exporter.RelabelConfig
+	endpointParams map[string]url.Values
+	basicAuthUsers map[string]string
+	seriesLimits   map[string]int
+	pollIntervals  map[string]time.Duration
+	subjectAggs    []string
+}
+
+// configServer is a NATS server to poll.
+type configServer struct {
+	id      string
+	url     string
+	headers http.Header
+	cluster string
+	labels  map[string]string
+}
+
+// envPrefix prefixes the names of the environment variables setting flags.
+const envPrefix = "NATS_EXPORTER_"
+
+// setFlags returns the names of the flags that have been set, along with
+// their aliases, the flags sharing their value, e.g. -p for -port.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		fs.VisitAll(func(a *flag.Flag) {
+			if sameFlagValue(a.Value, f.Value) {
+				set[a.Name] = true
+			}
+		})
+	})
+	return set
+}
+
+// sameFlagValue reports whether two flags set the same variable.
+func sameFlagValue(a, b flag.Value) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() || va.Kind() != reflect.Ptr {
+		return false
+	}
+	return va.Pointer() == vb.Pointer()
+}
+
+// loadEnv sets the flags not given on the command line from environment
+// variables named after them, e.g. NATS_EXPORTER_VARZ for -varz.
+func loadEnv(fs *flag.FlagSet) error {
+	set := setFlags(fs)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		name := envPrefix + strings.ToUpper(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("invalid value for %s: %v", name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// checkConfig validates the configuration file at path, loaded as the
+// exporter would load it, without polling any server.
+func checkConfig(path string) error {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	o, err := parseOptions(fs, []string{"-config", path})
+	if err != nil {
+		return err
+	}
+	for _, s := range o.servers {
+		if err := collector.CheckServerLabels(s.labels); err != nil {
+			return fmt.Errorf("server %s: %v", s.id, err)
+		}
+	}
+	if o.dnsName != "" {
+		if err := checkDNSType(o.dnsType); err != nil {
+			return err
+		}
+	}
+	return exporter.CheckOptions(o.exporter)
+}
+
+// loadConfigFile sets flags from a configuration file in the NATS server
+// configuration format, and returns the other settings it holds.  Each
+// key of the file is the name of a flag, except for servers, clusters,
+// metric_names, relabel_configs, endpoint_params, basic_auth_users,
+// series_limits and poll_intervals.
+// Flags already set, on the command line or from the environment, take
+// precedence over the file.
+func loadConfigFile(fs *flag.FlagSet, path string) (*fileConfig, error) {
+	m, err := conf.ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := setFlags(fs)
+	fc := &fileConfig{}
+	for k, v := range m {
+		switch k {
+		case "servers":
+			s, err := parseConfigServers(v, "")
+			if err != nil {
+				return nil, err
+			}
+			fc.servers = append(fc.servers, s...)
+			continue
+		case "clusters":
+			s, err := parseConfigClusters(v)
+			if err != nil {
+				return nil, err
+			}
+			fc.servers = append(fc.servers, s...)
+			continue
+		case "metric_names":
+			if fc.metricNames, err = parseConfigMetricNames(v); err != nil {
+				return nil, err
+			}
+			continue
+		case "relabel_configs":
+			if fc.relabelConfigs, err = parseConfigRelabelConfigs(v); err != nil {
+				return nil, err
+			}
+			continue
+		case "endpoint_params":
+			if fc.endpointParams, err = parseConfigEndpointParams(v); err != nil {
+				return nil, err
+			}
+			continue
+		case "basic_auth_users":
+			if fc.basicAuthUsers, err = parseConfigBasicAuthUsers(v); err != nil {
+				return nil, err
+			}
+			continue
+		case "series_limits":
+			if fc.seriesLimits, err = parseConfigSeriesLimits(v); err != nil {
+				return nil, err
+			}
+			continue
+		case "poll_intervals":
+			if fc.pollIntervals, err = parseConfigPollIntervals(v); err != nil {
+				return nil, err
+			}
+			continue
+		case "subject_aggregations":
+			if fc.subjectAggs, err = parseConfigSubjectAggregations(v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if fs.Lookup(k) == nil {
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+		if set[k] {
+			continue
+		}
+		if err := fs.Set(k, fmt.Sprint(v)); err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %v", k, err)
+		}
+	}
+	return fc, nil
+}
+
+// parseConfigMetricNames parses the renamed metrics of a configuration
+// file, a map from the endpoint and name of a monitor field, e.g.
+// varz.mem, to either the name of its metric or a map of its name and
+// help.
+func parseConfigMetricNames(v interface{}) (map[string]collector.MetricRename, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("metric_names must be a map")
+	}
+	renames := make(map[string]collector.MetricRename, len(m))
+	for field, v := range m {
+		var r collector.MetricRename
+		switch v := v.(type) {
+		case string:
+			r.Name = v
+		case map[string]interface{}:
+			r.Name, _ = v["name"].(string)
+			r.Help, _ = v["help"].(string)
+		default:
+			return nil, fmt.Errorf("invalid metric name for %q", field)
+		}
+		if !model.IsValidMetricName(model.LabelValue(r.Name)) {
+			return nil, fmt.Errorf("invalid metric name %q for %q", r.Name, field)
+		}
+		renames[field] = r
+	}
+	return renames, nil
+}
+
+// parseConfigBasicAuthUsers parses the users allowed to scrape, mapped to
+// their passwords or, preferably, bcrypt hashes of them.
+func parseConfigBasicAuthUsers(v interface{}) (map[string]string, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("basic_auth_users must be a map")
+	}
+	users := make(map[string]string, len(m))
+	for user, p := range m {
+		password, ok := p.(string)
+		if !ok || user == "" || password == "" {
+			return nil, fmt.Errorf("invalid password for user %q", user)
+		}
+		users[user] = password
+	}
+	return users, nil
+}
+
+// parseConfigSeriesLimits parses the series limits of the collectors,
+// mapped from their names, e.g. connz, to the series served of each of
+// their metrics.
+func parseConfigSeriesLimits(v interface{}) (map[string]int, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("series_limits must be a map")
+	}
+	limits := make(map[string]int, len(m))
+	for name, l := range m {
+		limit, ok := l.(int64)
+		if !ok || limit < 1 {
+			return nil, fmt.Errorf("invalid series limit for %q", name)
+		}
+		limits[name] = int(limit)
+	}
+	return limits, nil
+}
+
+// parseConfigPollIntervals parses the poll intervals of the collectors,
+// mapped from their names, e.g. jsz, to a duration such as "1m".
+func parseConfigPollIntervals(v interface{}) (map[string]time.Duration, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("poll_intervals must be a map")
+	}
+	intervals := make(map[string]time.Duration, len(m))
+	for name, i := range m {
+		s, _ := i.(string)
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid poll interval for %q", name)
+		}
+		intervals[name] = interval
+	}
+	return intervals, nil
+}
+
+// parseConfigSubjectAggregations parses the subject patterns the
+// subscriptions listed by subsz are counted by, a list of strings.
+func parseConfigSubjectAggregations(v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("subject_aggregations must be a list")
+	}
+	patterns := make([]string, 0, len(list))
+	for _, p := range list {
+		pattern, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid subject pattern %v", p)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// parseConfigEndpointParams parses the query parameters of each endpoint,
+// given as a query string, e.g. "limit=4096&sort=pending", or as a map of
+// the parameters.
+func parseConfigEndpointParams(v interface{}) (map[string]url.Values, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("endpoint_params must be a map")
+	}
+	params := make(map[string]url.Values, len(m))
+	for endpoint, p := range m {
+		switch p := p.(type) {
+		case string:
+			values, err := url.ParseQuery(strings.TrimPrefix(p, "?"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid query parameters for %q: %v", endpoint, err)
+			}
+			params[endpoint] = values
+		case map[string]interface{}:
+			values := url.Values{}
+			for name, value := range p {
+				values.Set(name, fmt.Sprint(value))
+			}
+			params[endpoint] = values
+		default:
+			return nil, fmt.Errorf("invalid query parameters for %q", endpoint)
+		}
+	}
+	return params, nil
+}
+
+// parseConfigClusters parses the list of clusters in a configuration file,
+// each a map with the name of the cluster and its servers.
+func parseConfigClusters(v interface{}) ([]configServer, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("clusters must be a list")
+	}
+	var servers []configServer
+	for _, item := range list {
+		cluster, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid cluster %v", item)
+		}
+		name, _ := cluster["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("cluster without a name")
+		}
+		s, err := parseConfigServers(cluster["servers"], name)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %v", name, err)
+		}
+		servers = append(servers, s...)
+	}
+	return servers, nil
+}
+
+// parseConfigServers parses a list of servers in a configuration file.
+// Each is either a url argument, or a map with an optional id, a url, the
+// headers sent to the server, its static labels and its cluster, which
+// defaults to cluster.
+func parseConfigServers(v interface{}, cluster string) ([]configServer, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("servers must be a list")
+	}
+	servers := make([]configServer, 0, len(list))
+	for _, item := range list {
+		s := configServer{cluster: cluster}
+		switch item := item.(type) {
+		case string:
+			id, url, err := parseServerIDAndURL(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid server %q: %v", item, err)
+			}
+			s.id, s.url = id, url
+		case map[string]interface{}:
+			url, _ := item["url"].(string)
+			id, url, err := parseServerIDAndURL(url)
+			if err != nil {
+				return nil, fmt.Errorf("invalid server url %q: %v", url, err)
+			}
+			s.url = url
+			s.id, _ = item["id"].(string)
+			if s.id == "" {
+				s.id = id
+			}
+			if c, ok := item["cluster"].(string); ok {
+				s.cluster = c
+			}
+			if headers, ok := item["headers"].(map[string]interface{}); ok {
+				s.headers = make(http.Header)
+				for k, v := range headers {
+					s.headers.Set(k, fmt.Sprint(v))
+				}
+			}
+			if labels, ok := item["labels"].(map[string]interface{}); ok {
+				s.labels = make(map[string]string, len(labels))
+				for k, v := range labels {
+					s.labels[k] = fmt.Sprint(v)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid server %v", item)
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// parseConfigRelabelConfigs parses the relabeling rules of a
+// configuration file, in the format of the Prometheus relabel_config.
+func parseConfigRelabelConfigs(v interface{}) ([]exporter.RelabelConfig, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("relabel_configs must be a list")
+	}
+	configs := make([]exporter.RelabelConfig, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid relabel config %v", item)
+		}
+		c := exporter.DefaultRelabelConfig
+		for k, v := range m {
+			switch k {
+			case "source_labels":
+				labels, ok := v.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("source_labels must be a list")
+				}
+				for _, l := range labels {
+					c.SourceLabels = append(c.SourceLabels, fmt.Sprint(l))
+				}
+			case "separator":
+				c.Separator = fmt.Sprint(v)
+			case "regex":
+				c.Regex = fmt.Sprint(v)
+			case "target_label":
+				c.TargetLabel = fmt.Sprint(v)
+			case "replacement":
+				c.Replacement = fmt.Sprint(v)
+			case "action":
+				c.Action = strings.ToLower(fmt.Sprint(v))
+			default:
+				return nil, fmt.Errorf("unknown relabel config key %q", k)
+			}
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
@@ -0,0 +1,567 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "exporter-config")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 8888
+varz: true
+poll_interval: "15s"
+servers: [
+  "http://localhost:8222"
+  { id: "other", url: "http://other:8222", headers: { X-Scope-OrgID: "tenant" } }
+]
+`)
+	defer os.Remove(path)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 7777, "")
+	varz := fs.Bool("varz", false, "")
+	poll := fs.Duration("poll_interval", 0, "")
+	if err := fs.Parse([]string{"-port", "9999"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	fc, err := loadConfigFile(fs, path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	servers := fc.servers
+	if *port != 9999 {
+		t.Fatalf("Expected the port flag to override the file, got %d", *port)
+	}
+	if !*varz || *poll != 15*time.Second {
+		t.Fatalf("Options not set from the file: varz=%v poll_interval=%v", *varz, *poll)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].id != "http://localhost:8222" || servers[0].url != "http://localhost:8222" {
+		t.Fatalf("Unexpected server: %+v", servers[0])
+	}
+	if servers[1].id != "other" || servers[1].headers.Get("X-Scope-OrgID") != "tenant" {
+		t.Fatalf("Unexpected server: %+v", servers[1])
+	}
+}
+
+func TestLoadConfigFileClusters(t *testing.T) {
+	path := writeConfigFile(t, `
+servers: [
+  { url: "http://single:8222" }
+  { url: "http://west1:8222", cluster: "west" }
+]
+clusters: [
+  { name: "east", servers: ["http://east1:8222", { id: "e2", url: "http://east2:8222", labels: { region: "us-east-1" } }] }
+]
+`)
+	defer os.Remove(path)
+
+	fc, err := loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	servers := fc.servers
+	clusters := make(map[string]string)
+	for _, s := range servers {
+		clusters[s.id] = s.cluster
+		if s.id == "e2" && s.labels["region"] != "us-east-1" {
+			t.Fatalf("Unexpected labels: %v", s.labels)
+		}
+	}
+	expected := map[string]string{
+		"http://single:8222": "",
+		"http://west1:8222":  "west",
+		"http://east1:8222":  "east",
+		"e2":                 "east",
+	}
+	if len(clusters) != len(expected) {
+		t.Fatalf("Expected %d servers, got %v", len(expected), clusters)
+	}
+	for id, cluster := range expected {
+		if c, ok := clusters[id]; !ok || c != cluster {
+			t.Fatalf("Expected server %q in cluster %q, got %v", id, cluster, clusters)
+		}
+	}
+}
+
+func TestLoadConfigFileMetricNames(t *testing.T) {
+	path := writeConfigFile(t, `
+metric_names: {
+  "varz.mem": { name: "gnatsd_varz_memory_bytes", help: "Resident memory in bytes" }
+  "varz.cpu": "gnatsd_varz_cpu_percent"
+}
+`)
+	defer os.Remove(path)
+
+	fc, err := loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := fc.metricNames["varz.mem"]; r.Name != "gnatsd_varz_memory_bytes" || r.Help != "Resident memory in bytes" {
+		t.Fatalf("Unexpected rename: %+v", r)
+	}
+	if r := fc.metricNames["varz.cpu"]; r.Name != "gnatsd_varz_cpu_percent" || r.Help != "" {
+		t.Fatalf("Unexpected rename: %+v", r)
+	}
+}
+
+func TestLoadConfigFileRelabelConfigs(t *testing.T) {
+	path := writeConfigFile(t, `
+relabel_configs: [
+  { source_labels: ["server_id"], regex: "http://(.*):8222", target_label: "alias" }
+  { source_labels: ["__name__"], regex: "gnatsd_subsz_.*", action: "drop" }
+]
+`)
+	defer os.Remove(path)
+
+	fc, err := loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fc.relabelConfigs) != 2 {
+		t.Fatalf("Expected 2 relabel configs, got %d", len(fc.relabelConfigs))
+	}
+	c := fc.relabelConfigs[0]
+	if c.Action != "replace" || c.Replacement != "$1" || c.Separator != ";" || c.TargetLabel != "alias" {
+		t.Fatalf("Expected the defaults for the fields not given, got %+v", c)
+	}
+	if fc.relabelConfigs[1].Action != "drop" {
+		t.Fatalf("Unexpected relabel config: %+v", fc.relabelConfigs[1])
+	}
+}
+
+func TestLoadConfigFileEndpointParams(t *testing.T) {
+	path := writeConfigFile(t, `
+endpoint_params: {
+  connz: "limit=4096&sort=pending"
+  subsz: { subs: true }
+}
+`)
+	defer os.Remove(path)
+
+	fc, err := loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if q := fc.endpointParams["connz"].Encode(); q != "limit=4096&sort=pending" {
+		t.Fatalf("Unexpected connz parameters: %q", q)
+	}
+	if q := fc.endpointParams["subsz"].Encode(); q != "subs=true" {
+		t.Fatalf("Unexpected subsz parameters: %q", q)
+	}
+}
+
+func TestLoadConfigFileBasicAuthUsers(t *testing.T) {
+	path := writeConfigFile(t, `
+basic_auth_users: {
+  prometheus: "$2y$04$z5SLb8XKfPJyT5uvmNq.NOf4G4SUrFoHDNHz9rw7XJxK.tGu0BKF2"
+}
+`)
+	defer os.Remove(path)
+
+	fc, err := loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fc.basicAuthUsers) != 1 || !strings.HasPrefix(fc.basicAuthUsers["prometheus"], "$2y$04$") {
+		t.Fatalf("Unexpected users: %v", fc.basicAuthUsers)
+	}
+}
+
+func TestLoadConfigFileSeriesLimits(t *testing.T) {
+	path := writeConfigFile(t, `
+series_limits: {
+  connz: 1000
+  subz: 500
+}
+`)
+	defer os.Remove(path)
+
+	fc, err := loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(fc.seriesLimits, map[string]int{"connz": 1000, "subz": 500}) {
+		t.Fatalf("Unexpected series limits: %v", fc.seriesLimits)
+	}
+}
+
+func TestLoadConfigFilePollIntervals(t *testing.T) {
+	path := writeConfigFile(t, `
+poll_interval: "10s"
+poll_intervals: {
+  jsz: "1m"
+}
+`)
+	defer os.Remove(path)
+
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if o.exporter.PollInterval != 10*time.Second ||
+		!reflect.DeepEqual(o.exporter.PollIntervals, map[string]time.Duration{"jsz": time.Minute}) {
+		t.Fatalf("Unexpected poll intervals: %v, %v", o.exporter.PollInterval, o.exporter.PollIntervals)
+	}
+}
+
+func TestLoadConfigFileSubjectAggregations(t *testing.T) {
+	path := writeConfigFile(t, `
+subz: true
+endpoint_params: {
+  subsz: { subs: true }
+}
+subject_aggregations: ["orders.*.eu.>", "orders.>"]
+`)
+	defer os.Remove(path)
+
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if patterns := o.exporter.SubjectAggregations; !reflect.DeepEqual(patterns, []string{"orders.*.eu.>", "orders.>"}) {
+		t.Fatalf("Unexpected subject aggregations: %v", patterns)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	path := writeConfigFile(t, `
+varz: true
+tlscert: "server-cert.pem"
+tlskey: "server-key.pem"
+servers: [ { url: "http://localhost:8222", labels: { region: "us-east" } } ]
+`)
+	defer os.Remove(path)
+	if err := checkConfig(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, cfg := range []string{
+		"varz: true\nunknown: 1",
+		"varz: true\ntlscert: \"server-cert.pem\"",
+		"varz: true\nhttp_pass: \"secret\"",
+		"varz: true\ntlscert: \"c.pem\"\ntlskey: \"k.pem\"\ntlsverify: true",
+		"varz: true\ntlscert: \"c.pem\"\ntlskey: \"k.pem\"\ntlscacert: \"ca.pem\"\ntlscrl: \"crl.pem\"",
+		"varz: true\nadmin_api: true",
+		"varz: true\nhttp_bearer_token: \"a\"\nhttp_bearer_token_file: \"token\"",
+		"varz: true\nbasic_auth_users: { prometheus: 1 }",
+		"varz: true\nmetrics_include: \"(\"",
+		"varz: true\ndns_name: \"nats\"\ndns_type: \"MX\"",
+		"varz: true\nrelabel_configs: [ { action: \"replace\" } ]",
+		"servers: [ { url: \"http://localhost:8222\", labels: { server_id: \"x\" } } ]",
+		"varz: true\nreplicatorVarz: true",
+		"varz: true\nendpoint_params: { serverz: \"subs=1\" }",
+	} {
+		path := writeConfigFile(t, cfg)
+		err := checkConfig(path)
+		os.Remove(path)
+		if err == nil {
+			t.Fatalf("Expected an error checking %q", cfg)
+		}
+	}
+	if err := checkConfig("missing.conf"); err == nil {
+		t.Fatalf("Expected an error checking a missing file")
+	}
+}
+
+func TestCollectFlag(t *testing.T) {
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-routez", "-collect", "varz, connz,subsz", "http://localhost:8222"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	opts := o.exporter
+	if !opts.GetVarz || !opts.GetConnz || !opts.GetSubz || !opts.GetRoutez || opts.GetGatewayz {
+		t.Fatalf("Unexpected collectors: %+v", opts)
+	}
+
+	if _, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-collect", "varz,leafz"}); err == nil {
+		t.Fatalf("Expected an error for an unknown collector")
+	}
+}
+
+func TestLocalFlag(t *testing.T) {
+	os.Setenv("NATS_EXPORTER_LOCAL_PORTS", "7777, 8222")
+	defer os.Unsetenv("NATS_EXPORTER_LOCAL_PORTS")
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-local"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !o.local || !o.discovers() || !reflect.DeepEqual(o.localPorts, []int{7777, 8222}) {
+		t.Fatalf("Unexpected local discovery: %v, %v", o.local, o.localPorts)
+	}
+
+	if _, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-local", "-local_ports", "nats"}); err == nil {
+		t.Fatalf("Expected an error for an invalid port")
+	}
+}
+
+func TestConnzAccountsFlag(t *testing.T) {
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-connz", "-connz_accounts", "orders, payments,", "-accounts", "orders", "http://localhost:8222"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if accounts := o.exporter.ConnzAccounts; !reflect.DeepEqual(accounts, []string{"orders", "payments"}) {
+		t.Fatalf("Unexpected accounts: %v", accounts)
+	}
+	if accounts := o.exporter.Accounts; !reflect.DeepEqual(accounts, []string{"orders"}) {
+		t.Fatalf("Unexpected accounts: %v", accounts)
+	}
+}
+
+func TestDisableRuntimeMetrics(t *testing.T) {
+	o, err := parseOptions(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-disable_go_metrics", "-disable_process_metrics", "http://localhost:8222"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	unregisterRuntimeCollectors(o)
+	defer prometheus.MustRegister(prometheus.NewGoCollector())
+	defer prometheus.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), "go_") || strings.HasPrefix(mf.GetName(), "process_") {
+			t.Fatalf("Unexpected metric %s", mf.GetName())
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	os.Setenv("NATS_EXPORTER_PORT", "8888")
+	os.Setenv("NATS_EXPORTER_VARZ", "true")
+	defer os.Unsetenv("NATS_EXPORTER_PORT")
+	defer os.Unsetenv("NATS_EXPORTER_VARZ")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 7777, "")
+	varz := fs.Bool("varz", false, "")
+	connz := fs.Bool("connz", false, "")
+	if err := fs.Parse([]string{"-port", "9999"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := loadEnv(fs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *port != 9999 {
+		t.Fatalf("Expected the port flag to override the environment, got %d", *port)
+	}
+	if !*varz || *connz {
+		t.Fatalf("Options not set from the environment: varz=%v connz=%v", *varz, *connz)
+	}
+
+	// An alias given on the command line overrides the environment too.
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	port = fs.Int("port", 7777, "")
+	fs.IntVar(port, "p", 7777, "")
+	if err := fs.Parse([]string{"-p", "9999"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := loadEnv(fs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *port != 9999 {
+		t.Fatalf("Expected the alias flag to override the environment, got %d", *port)
+	}
+
+	os.Setenv("NATS_EXPORTER_VARZ", "maybe")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("varz", false, "")
+	if err := loadEnv(fs); err == nil {
+		t.Fatalf("Expected an error for an invalid value")
+	}
+}
+
+func TestLoadConfigFileAliases(t *testing.T) {
+	path := writeConfigFile(t, "port: 8888\nlog: \"file.log\"")
+	defer os.Remove(path)
+
+	os.Setenv("NATS_EXPORTER_L", "env.log")
+	defer os.Unsetenv("NATS_EXPORTER_L")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 7777, "")
+	fs.IntVar(port, "p", 7777, "")
+	log := fs.String("log", "", "")
+	fs.StringVar(log, "l", "", "")
+	if err := fs.Parse([]string{"-p", "9999"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := loadEnv(fs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := loadConfigFile(fs, path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The command line and the environment take precedence over the file
+	// whichever alias they set.
+	if *port != 9999 || *log != "env.log" {
+		t.Fatalf("Expected the aliases to override the file, got %d and %q", *port, *log)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for _, content := range []string{
+		"unknown: true",
+		"port: \"abc\"",
+		"servers: \"http://localhost:8222\"",
+		"servers: [ \"not a url\" ]",
+		"port: {",
+		"metric_names: { \"varz.mem\": \"not valid\" }",
+		"relabel_configs: [ { unknown: true } ]",
+		"series_limits: { connz: 0 }",
+		"poll_intervals: { jsz: 60 }",
+		"subject_aggregations: { orders: 1 }",
+		"subject_aggregations: [ 1 ]",
+	} {
+		path := writeConfigFile(t, content)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("port", 7777, "")
+		if _, err := loadConfigFile(fs, path); err == nil {
+			t.Fatalf("Expected an error loading %q", content)
+		}
+		os.Remove(path)
+	}
+}
+
+func TestGenDashboard(t *testing.T) {
+	path := writeConfigFile(t, `
+prefix: "nats"
+varz: true
+connz: true
+unit_metric_names: true
+metric_names: {
+  "varz.connections": { name: "nats_open_connections" }
+}
+`)
+	defer os.Remove(path)
+	var out strings.Builder
+	if err := genDashboard([]string{"-config", path}, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	d := out.String()
+	for _, expr := range []string{
+		`nats_open_connections{server_id=~\"$server\"}`,
+		`rate(nats_varz_in_msgs{server_id=~\"$server\"}[5m])`,
+		`nats_varz_mem_bytes{server_id=~\"$server\"}`,
+		`nats_connz_num_connections{server_id=~\"$server\"}`,
+		`label_values(nats_open_connections, server_id)`,
+	} {
+		if !strings.Contains(d, expr) {
+			t.Fatalf("Expected %s in the dashboard:\n%s", expr, d)
+		}
+	}
+	if strings.Contains(d, "gnatsd_") || strings.Contains(d, "subsz") {
+		t.Fatalf("Unexpected metrics in the dashboard:\n%s", d)
+	}
+
+	if err := genDashboard([]string{"-serverz", "-channelz"}, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := genDashboard([]string{"-gatewayz"}, &out); err == nil {
+		t.Fatalf("Expected an error without any panels")
+	}
+}
+
+func TestGenAlerts(t *testing.T) {
+	var out strings.Builder
+	if err := genAlerts([]string{"-prefix", "nats", "-connz", "-routez", "-varz", "-serverz"}, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rules := out.String()
+	for _, s := range []string{
+		"- alert: NATSServerDown\n    expr: \"nats_varz_up == 0\"\n    for: 5m\n",
+		"- alert: NATSStreamingServerDown\n    expr: \"nats_serverz_up == 0\"\n",
+		"- alert: NATSStreamingFailover\n    expr: \"changes(nats_server_active[10m]) > 0\"\n",
+		"expr: \"increase(nats_varz_slow_consumers[5m]) > 0\"",
+		"expr: \"changes(nats_routez_num_routes[15m]) > 4\"",
+		"expr: \"increase(nats_server_restarts_total[15m]) > 0\"",
+		"summary: \"NATS server {{ $labels.server_id }} is down\"",
+	} {
+		if !strings.Contains(rules, s) {
+			t.Fatalf("Expected %q in the rules:\n%s", s, rules)
+		}
+	}
+	if strings.Contains(rules, "Replicator") {
+		t.Fatalf("Unexpected rules:\n%s", rules)
+	}
+
+	out.Reset()
+	if err := genAlerts([]string{"-replicatorVarz"}, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rules := out.String(); !strings.Contains(rules, "replicator_varz_up == 0") ||
+		!strings.Contains(rules, "expr: \"increase(replicator_connector_disconnects[15m]) > 3\"") {
+		t.Fatalf("Unexpected rules:\n%s", rules)
+	}
+
+	out.Reset()
+	if err := genAlerts([]string{"-connz"}, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rules := out.String(); !strings.Contains(rules, "gnatsd_connz_up == 0") ||
+		strings.Contains(rules, "NATSSlowConsumersIncreasing") || strings.Contains(rules, "NATSRouteFlapping") {
+		t.Fatalf("Unexpected rules:\n%s", rules)
+	}
+}
+
+func TestShiftLogLevel(t *testing.T) {
+	defer collector.SetLogLevel(collector.LogLevel())
+	collector.SetLogLevel(collector.InfoLogLevel)
+
+	for _, tc := range []struct {
+		delta int
+		level string
+	}{
+		{1, collector.DebugLogLevel},
+		{1, collector.TraceLogLevel},
+		{1, collector.TraceLogLevel},
+		{-1, collector.DebugLogLevel},
+		{-5, collector.InfoLogLevel},
+	} {
+		if level := shiftLogLevel(tc.delta); level != tc.level || collector.LogLevel() != tc.level {
+			t.Fatalf("Expected level %s, got %s", tc.level, level)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/nats-io/prometheus-nats-exporter/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dashboardPanel is a graph of a metric of an endpoint.
+type dashboardPanel struct {
+	title    string
+	system   string
+	endpoint string
+	// field is the monitor field of the endpoints polled by the generic
+	// collector, name the metric, less its namespace, of the others.
+	field string
+	name  string
+	rate  bool // Graph the per-second rate of a counter.
+	unit  string
+}
+
+// dashboardPanels are the panels of the dashboard, by endpoint.
+var dashboardPanels = []dashboardPanel{
+	{title: "Connections", system: collector.CoreSystem, endpoint: "varz", field: "connections", unit: "short"},
+	{title: "Subscriptions", system: collector.CoreSystem, endpoint: "varz", field: "subscriptions", unit: "short"},
+	{title: "Messages in", system: collector.CoreSystem, endpoint: "varz", field: "in_msgs", rate: true, unit: "ops"},
+	{title: "Messages out", system: collector.CoreSystem, endpoint: "varz", field: "out_msgs", rate: true, unit: "ops"},
+	{title: "Bytes in", system: collector.CoreSystem, endpoint: "varz", field: "in_bytes", rate: true, unit: "Bps"},
+	{title: "Bytes out", system: collector.CoreSystem, endpoint: "varz", field: "out_bytes", rate: true, unit: "Bps"},
+	{title: "Slow consumers", system: collector.CoreSystem, endpoint: "varz", field: "slow_consumers", rate: true, unit: "short"},
+	{title: "Memory", system: collector.CoreSystem, endpoint: "varz", field: "mem", unit: "bytes"},
+	{title: "CPU", system: collector.CoreSystem, endpoint: "varz", field: "cpu", unit: "percent"},
+	{title: "Routes", system: collector.CoreSystem, endpoint: "varz", field: "routes", unit: "short"},
+	{title: "Open connections", system: collector.CoreSystem, endpoint: "connz", name: "connz_num_connections", unit: "short"},
+	{title: "Total connections", system: collector.CoreSystem, endpoint: "connz", name: "connz_total", unit: "short"},
+	{title: "Routed servers", system: collector.CoreSystem, endpoint: "routez", field: "num_routes", unit: "short"},
+	{title: "Subscriptions in the sublist", system: collector.CoreSystem, endpoint: "subsz", field: "num_subscriptions", unit: "short"},
+	{title: "Sublist cache hit rate", system: collector.CoreSystem, endpoint: "subsz", field: "cache_hit_rate", unit: "percentunit"},
+	{title: "Streaming clients", system: collector.StreamingSystem, endpoint: "serverz", name: "server_clients", unit: "short"},
+	{title: "Streaming channels", system: collector.StreamingSystem, endpoint: "serverz", name: "server_channels", unit: "short"},
+	{title: "Streaming messages in", system: collector.StreamingSystem, endpoint: "serverz", name: "server_msgs_in", rate: true, unit: "ops"},
+	{title: "Streaming bytes in", system: collector.StreamingSystem, endpoint: "serverz", name: "server_bytes_in", rate: true, unit: "Bps"},
+	{title: "Channel messages", system: collector.StreamingSystem, endpoint: "channelz", name: "chan_msgs_total", unit: "short"},
+	{title: "Channel pending messages", system: collector.StreamingSystem, endpoint: "channelz", name: "chan_subs_pending_count", unit: "short"},
+	{title: "Replicator connectors connected", system: collector.ReplicatorSystem, endpoint: "replicatorVarz", name: "connector_connected", unit: "short"},
+	{title: "Replicator messages in", system: collector.ReplicatorSystem, endpoint: "replicatorVarz", name: "connector_messages_in", rate: true, unit: "ops"},
+}
+
+// metric returns the name of the metric of the panel.
+func (p *dashboardPanel) metric(opts *exporter.NATSExporterOptions) string {
+	if p.field != "" {
+		return collector.MetricName(p.system, p.endpoint, p.field, opts.Prefix, &opts.CollectorOptions)
+	}
+	system := p.system
+	if opts.Prefix != "" {
+		system = opts.Prefix
+	}
+	return prometheus.BuildFQName(system, "", p.name)
+}
+
+// grafanaUnit returns the Grafana unit of the panel, a ratio rather than a
+// percentage when unit metric names are used.
+func (p *dashboardPanel) grafanaUnit(opts *exporter.NATSExporterOptions) string {
+	if p.unit == "percent" && opts.UnitMetricNames {
+		return "percentunit"
+	}
+	return p.unit
+}
+
+// endpointEnabled reports whether the collector of an endpoint is enabled.
+func endpointEnabled(opts *exporter.NATSExporterOptions, endpoint string) bool {
+	switch endpoint {
+	case "varz":
+		return opts.GetVarz
+	case "connz":
+		return opts.GetConnz
+	case "routez":
+		return opts.GetRoutez
+	case "subsz":
+		return opts.GetSubz
+	case "serverz":
+		return opts.GetStreamingServerz
+	case "channelz":
+		return opts.GetStreamingChannelz
+	case "replicatorVarz":
+		return opts.GetReplicatorVarz
+	}
+	return false
+}
+
+// dashboard returns the Grafana dashboard of the metrics of the collectors
+// enabled, named after the prefix and renames configured.
+func dashboard(opts *exporter.NATSExporterOptions) (map[string]interface{}, error) {
+	var panels []interface{}
+	var serverMetric string
+	for i := range dashboardPanels {
+		p := &dashboardPanels[i]
+		if !endpointEnabled(opts, p.endpoint) {
+			continue
+		}
+		metric := p.metric(opts)
+		if serverMetric == "" {
+			serverMetric = metric
+		}
+		expr := fmt.Sprintf(`%s{server_id=~"$server"}`, metric)
+		if p.rate {
+			expr = fmt.Sprintf("rate(%s[5m])", expr)
+		}
+		n := len(panels)
+		panels = append(panels, map[string]interface{}{
+			"id":         n + 1,
+			"type":       "graph",
+			"title":      p.title,
+			"datasource": "$datasource",
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": 12 * (n % 2), "y": 8 * (n / 2)},
+			"targets": []map[string]string{{
+				"expr":         expr,
+				"legendFormat": "{{server_id}}",
+				"refId":        "A",
+			}},
+			"yaxes": []map[string]interface{}{
+				{"format": p.grafanaUnit(opts), "show": true},
+				{"format": "short", "show": false},
+			},
+			"lines":     true,
+			"linewidth": 1,
+			"legend":    map[string]bool{"show": true},
+		})
+	}
+	if len(panels) == 0 {
+		return nil, fmt.Errorf("no collectors enabled with panels in the dashboard")
+	}
+
+	return map[string]interface{}{
+		"title":         "NATS",
+		"tags":          []string{"nats"},
+		"schemaVersion": 16,
+		"editable":      true,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"panels":        panels,
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				{
+					"name":       "server",
+					"label":      "Server",
+					"type":       "query",
+					"datasource": "$datasource",
+					"query":      fmt.Sprintf("label_values(%s, server_id)", serverMetric),
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"current":    map[string]interface{}{"text": "All", "value": []string{"$__all"}},
+				},
+			},
+		},
+	}, nil
+}
+
+// genDashboard writes the Grafana dashboard of the exporter run with the
+// flags given.
+func genDashboard(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("gen-dashboard", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	o, err := parseOptions(fs, args)
+	if err != nil {
+		return err
+	}
+	d, err := dashboard(o.exporter)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DefaultConsulAddress is the address of the local Consul agent.
+var DefaultConsulAddress = "http://127.0.0.1:8500"
+
+// consulMonitorPortMeta is the service metadata key that overrides the
+// monitor port of an instance.
+const consulMonitorPortMeta = "nats_monitor_port"
+
+// Consul discovers the instances of a service in the Consul catalog,
+// through the health endpoint of the Consul HTTP API.
+type Consul struct {
+	// Address of the Consul HTTP API, DefaultConsulAddress if empty.
+	Address string
+
+	// Service is the name of the NATS service.
+	Service string
+
+	// Tag, if set, only selects the instances with this tag.
+	Tag string
+
+	// Datacenter of the service, that of the agent if empty.
+	Datacenter string
+
+	// Token is the ACL token sent to Consul.
+	Token string
+
+	// Passing only selects the instances passing all their health checks.
+	Passing bool
+
+	// MonitorPort is the monitor port of the instances, unless set by
+	// their nats_monitor_port metadata.  Zero uses DefaultMonitorPort.
+	MonitorPort int
+
+	// Scheme of the monitor URLs, http unless set.
+	Scheme string
+
+	// Client is the HTTP client of the API.
+	Client *http.Client
+}
+
+// consulServiceEntry is the part of a Consul service health entry the
+// discovery uses.
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		ID      string
+		Address string
+		Meta    map[string]string
+	}
+}
+
+// Discover queries Consul for the instances of the service, returning a
+// server for each, with the ID of the instance as its ID.
+func (c *Consul) Discover(ctx context.Context) ([]*collector.CollectedServer, error) {
+	address := c.Address
+	if address == "" {
+		address = DefaultConsulAddress
+	}
+	query := url.Values{}
+	if c.Tag != "" {
+		query.Set("tag", c.Tag)
+	}
+	if c.Datacenter != "" {
+		query.Set("dc", c.Datacenter)
+	}
+	if c.Passing {
+		query.Set("passing", "true")
+	}
+	u := fmt.Sprintf("%s/v1/health/service/%s?%s", strings.TrimSuffix(address, "/"),
+		url.PathEscape(c.Service), query.Encode())
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("querying consul: %s", resp.Status)
+	}
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("querying consul: %v", err)
+	}
+
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	servers := make([]*collector.CollectedServer, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		port := c.MonitorPort
+		if p, err := strconv.Atoi(e.Service.Meta[consulMonitorPortMeta]); err == nil {
+			port = p
+		}
+		if port <= 0 {
+			port = DefaultMonitorPort
+		}
+		servers = append(servers, &collector.CollectedServer{
+			ID:  e.Service.ID,
+			URL: scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)),
+		})
+	}
+	return servers, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsulDiscover(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/v1/health/service/nats" || q.Get("tag") != "edge" ||
+			q.Get("passing") != "true" || r.Header.Get("X-Consul-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `[
+  {"Node": {"Address": "10.0.0.1"}, "Service": {"ID": "nats-a", "Address": ""}},
+  {"Node": {"Address": "10.0.0.2"}, "Service": {"ID": "nats-b", "Address": "10.1.0.2",
+    "Meta": {"nats_monitor_port": "9222"}}}
+]`)
+	}))
+	defer api.Close()
+
+	c := &Consul{Address: api.URL, Service: "nats", Tag: "edge", Token: "secret", Passing: true}
+	servers, err := c.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].ID != "nats-a" || servers[0].URL != "http://10.0.0.1:8222" {
+		t.Fatalf("Unexpected server: %+v", servers[0])
+	}
+	if servers[1].ID != "nats-b" || servers[1].URL != "http://10.1.0.2:9222" {
+		t.Fatalf("Unexpected server: %+v", servers[1])
+	}
+
+	c.Passing = false
+	if _, err := c.Discover(context.Background()); err == nil {
+		t.Fatalf("Expected an error from the query")
+	}
+}
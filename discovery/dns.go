@@ -0,0 +1,97 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DNS record types looked up
+const (
+	DNSTypeA   = "A"
+	DNSTypeSRV = "SRV"
+)
+
+// DNS discovers the servers behind a DNS name, e.g. the headless service
+// of a NATS cluster, resolving it each time it is queried.
+type DNS struct {
+	// Name looked up.
+	Name string
+
+	// Type is DNSTypeSRV to look up the SRV records of the name, whose
+	// targets and ports are the monitor endpoints, or DNSTypeA to look up
+	// its A and AAAA records.
+	Type string
+
+	// MonitorPort is the monitor port of the addresses of A records.
+	// Zero uses DefaultMonitorPort.
+	MonitorPort int
+
+	// Scheme of the monitor URLs, http unless set.
+	Scheme string
+
+	// Resolver looks up the name, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// Discover resolves the name, returning a server for each address, with
+// the address as its ID.
+func (d *DNS) Discover(ctx context.Context) ([]*collector.CollectedServer, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var hosts []string
+	switch strings.ToUpper(d.Type) {
+	case DNSTypeSRV:
+		_, records, err := resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			target := strings.TrimSuffix(r.Target, ".")
+			hosts = append(hosts, net.JoinHostPort(target, strconv.Itoa(int(r.Port))))
+		}
+	case DNSTypeA, "":
+		addrs, err := resolver.LookupIPAddr(ctx, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		port := d.MonitorPort
+		if port <= 0 {
+			port = DefaultMonitorPort
+		}
+		for _, addr := range addrs {
+			hosts = append(hosts, net.JoinHostPort(addr.IP.String(), strconv.Itoa(port)))
+		}
+	default:
+		return nil, fmt.Errorf("invalid DNS record type %q", d.Type)
+	}
+
+	scheme := d.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	servers := make([]*collector.CollectedServer, 0, len(hosts))
+	for _, host := range hosts {
+		servers = append(servers, &collector.CollectedServer{ID: host, URL: scheme + "://" + host})
+	}
+	return servers, nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDNSDiscover(t *testing.T) {
+	d := &DNS{Name: "localhost", Type: DNSTypeA, MonitorPort: 8333}
+	servers, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	found := false
+	for _, s := range servers {
+		if s.ID == "127.0.0.1:8333" && s.URL == "http://127.0.0.1:8333" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected localhost to be discovered, got %+v", servers)
+	}
+
+	d.Type = "MX"
+	if _, err := d.Discover(context.Background()); err == nil {
+		t.Fatalf("Expected an error for an invalid record type")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DefaultFileWatchInterval is how often a target file is checked for
+// changes.
+var DefaultFileWatchInterval = time.Second
+
+// fileTargetGroup is a group of targets in a Prometheus file_sd file.
+type fileTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// File discovers the servers listed in a JSON target file in the format
+// of the Prometheus file_sd, so that they can be managed by other tools.
+// Each target is the host:port or the URL of a monitor endpoint.  A
+// cluster label sets the cluster of the servers, and labels starting
+// with __ are ignored.
+type File struct {
+	// Path of the target file.
+	Path string
+
+	// WatchInterval is how often the file is checked for changes.  Zero
+	// uses DefaultFileWatchInterval.
+	WatchInterval time.Duration
+
+	// Scheme of the monitor URLs of host:port targets, http unless set.
+	Scheme string
+}
+
+// Discover reads the target file, returning a server for each target,
+// with the target as its ID.
+func (f *File) Discover(ctx context.Context) ([]*collector.CollectedServer, error) {
+	content, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	var groups []fileTargetGroup
+	if err := json.Unmarshal(content, &groups); err != nil {
+		return nil, fmt.Errorf("invalid target file %s: %v", f.Path, err)
+	}
+
+	scheme := f.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	var servers []*collector.CollectedServer
+	for _, g := range groups {
+		var cluster string
+		labels := make(map[string]string)
+		for name, value := range g.Labels {
+			switch {
+			case name == "cluster":
+				cluster = value
+			case !strings.HasPrefix(name, "__"):
+				labels[name] = value
+			}
+		}
+		if err := collector.CheckServerLabels(labels); err != nil {
+			return nil, fmt.Errorf("invalid target file %s: %v", f.Path, err)
+		}
+		for _, target := range g.Targets {
+			url := target
+			if !strings.Contains(target, "://") {
+				url = scheme + "://" + target
+			}
+			servers = append(servers, &collector.CollectedServer{
+				ID:      target,
+				URL:     url,
+				Cluster: cluster,
+				Labels:  labels,
+			})
+		}
+	}
+	return servers, nil
+}
+
+// Watch signals when the modification time or size of the target file
+// changes, checking it every WatchInterval until done is closed.
+func (f *File) Watch(done <-chan struct{}) <-chan struct{} {
+	interval := f.WatchInterval
+	if interval <= 0 {
+		interval = DefaultFileWatchInterval
+	}
+	changed := make(chan struct{}, 1)
+
+	// The file is stated before returning, so that changes made once Watch
+	// returns are signaled.
+	var modTime time.Time
+	var size int64
+	if fi, err := os.Stat(f.Path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			fi, err := os.Stat(f.Path)
+			if err != nil || (fi.ModTime().Equal(modTime) && fi.Size() == size) {
+				continue
+			}
+			modTime, size = fi.ModTime(), fi.Size()
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed
+}
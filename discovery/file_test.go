@@ -0,0 +1,76 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileDiscover(t *testing.T) {
+	tf, err := ioutil.TempFile("", "targets")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	content := `[
+  {"targets": ["nats-0:8222", "https://nats-1:8222"],
+   "labels": {"cluster": "east", "region": "us-east-1", "__meta_ignored": "x"}}
+]`
+	if err := ioutil.WriteFile(tf.Name(), []byte(content), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	f := &File{Path: tf.Name(), WatchInterval: 10 * time.Millisecond}
+	servers, err := f.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].ID != "nats-0:8222" || servers[0].URL != "http://nats-0:8222" {
+		t.Fatalf("Unexpected server: %+v", servers[0])
+	}
+	if servers[1].URL != "https://nats-1:8222" || servers[1].Cluster != "east" {
+		t.Fatalf("Unexpected server: %+v", servers[1])
+	}
+	if len(servers[1].Labels) != 1 || servers[1].Labels["region"] != "us-east-1" {
+		t.Fatalf("Unexpected labels: %v", servers[1].Labels)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	changed := f.Watch(done)
+	if err := ioutil.WriteFile(tf.Name(), []byte(`[]`), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the change to the file to be signaled")
+	}
+
+	if err := ioutil.WriteFile(tf.Name(), []byte(`{`), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := f.Discover(context.Background()); err == nil {
+		t.Fatalf("Expected an error for an invalid file")
+	}
+}
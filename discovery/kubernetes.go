@@ -0,0 +1,227 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery finds the NATS servers polled by the exporter.
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// In cluster service account files.
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	serviceAccountToken     = serviceAccountDir + "token"
+	serviceAccountCA        = serviceAccountDir + "ca.crt"
+	serviceAccountNamespace = serviceAccountDir + "namespace"
+)
+
+// DefaultMonitorPort is the default monitor port of discovered servers.
+var DefaultMonitorPort = 8222
+
+// CollectAnnotation is the annotation of a NATS pod listing the collectors
+// polling it, e.g. varz,jsz, rather than all those of the exporter.
+const CollectAnnotation = "nats-exporter/collect"
+
+// Kubernetes discovers the ready NATS pods matching a label selector
+// through the Kubernetes API, listing them each time it is queried.
+type Kubernetes struct {
+	// APIServer is the URL of the Kubernetes API.
+	APIServer string
+
+	// Namespace of the pods.
+	Namespace string
+
+	// LabelSelector selects the NATS pods, e.g. app=nats.
+	LabelSelector string
+
+	// MonitorPort is the monitor port of the pods.  Zero uses
+	// DefaultMonitorPort.
+	MonitorPort int
+
+	// Scheme of the monitor URLs, http unless set.
+	Scheme string
+
+	// TokenFile holds the bearer token sent to the API, read on every
+	// request so that it can be rotated.
+	TokenFile string
+
+	// Client is the HTTP client of the API.
+	Client *http.Client
+}
+
+// NewKubernetes returns a discoverer of the pods matching selector, from
+// within the cluster, using the service account of the exporter's pod.
+// An empty namespace is the namespace of the pod.
+func NewKubernetes(namespace, selector string, port int) (*Kubernetes, error) {
+	k, err := inCluster(namespace)
+	if err != nil {
+		return nil, err
+	}
+	k.LabelSelector, k.MonitorPort = selector, port
+	return k, nil
+}
+
+// inCluster returns the API of the cluster the exporter's pod runs in,
+// authenticated as its service account.  An empty namespace is the
+// namespace of the pod.
+func inCluster(namespace string) (*Kubernetes, error) {
+	host, p := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || p == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccountNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	ca, err := ioutil.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the cluster CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid cluster CA in %s", serviceAccountCA)
+	}
+	return &Kubernetes{
+		APIServer: "https://" + net.JoinHostPort(host, p),
+		Namespace: namespace,
+		TokenFile: serviceAccountToken,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		},
+	}, nil
+}
+
+// podList is the part of a Kubernetes pod list the discovery uses.
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Status struct {
+			Phase      string `json:"phase"`
+			PodIP      string `json:"podIP"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// Discover lists the pods, returning a server for each ready one, with
+// the name of the pod as its ID, polled by the collectors of its
+// CollectAnnotation if any.
+func (k *Kubernetes) Discover(ctx context.Context) ([]*collector.CollectedServer, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?labelSelector=%s",
+		strings.TrimSuffix(k.APIServer, "/"), url.PathEscape(k.Namespace),
+		url.QueryEscape(k.LabelSelector))
+	resp, err := k.do(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing pods: %s", resp.Status)
+	}
+	var pods podList
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("listing pods: %v", err)
+	}
+
+	port := k.MonitorPort
+	if port <= 0 {
+		port = DefaultMonitorPort
+	}
+	scheme := k.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	var servers []*collector.CollectedServer
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != "Running" || pod.Status.PodIP == "" {
+			continue
+		}
+		ready := false
+		for _, c := range pod.Status.Conditions {
+			if c.Type == "Ready" {
+				ready = c.Status == "True"
+			}
+		}
+		if !ready {
+			continue
+		}
+		servers = append(servers, &collector.CollectedServer{
+			ID:         pod.Metadata.Name,
+			URL:        scheme + "://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)),
+			Collectors: splitList(pod.Metadata.Annotations[CollectAnnotation]),
+		})
+	}
+	return servers, nil
+}
+
+// splitList splits a comma-separated list, leaving out empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// do sends a request to the API, with the bearer token if any.
+func (k *Kubernetes) do(ctx context.Context, method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if k.TokenFile != "" {
+		token, err := ioutil.ReadFile(k.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
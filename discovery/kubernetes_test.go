@@ -0,0 +1,62 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestKubernetesDiscover(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/nats/pods" || r.URL.Query().Get("labelSelector") != "app=nats" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"items": [
+  {"metadata": {"name": "nats-0"}, "status": {"phase": "Running", "podIP": "10.0.0.1",
+    "conditions": [{"type": "Ready", "status": "True"}]}},
+  {"metadata": {"name": "nats-1"}, "status": {"phase": "Running", "podIP": "10.0.0.2",
+    "conditions": [{"type": "Ready", "status": "False"}]}},
+  {"metadata": {"name": "nats-2"}, "status": {"phase": "Pending"}},
+  {"metadata": {"name": "nats-3", "annotations": {"nats-exporter/collect": "varz, jsz"}},
+    "status": {"phase": "Running", "podIP": "10.0.0.3", "conditions": [{"type": "Ready", "status": "True"}]}}
+]}`)
+	}))
+	defer api.Close()
+
+	k := &Kubernetes{APIServer: api.URL, Namespace: "nats", LabelSelector: "app=nats"}
+	servers, err := k.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected only the ready pods, got %d servers", len(servers))
+	}
+	if servers[0].ID != "nats-0" || servers[0].URL != "http://10.0.0.1:8222" || servers[0].Collectors != nil {
+		t.Fatalf("Unexpected server: %+v", servers[0])
+	}
+	if servers[1].ID != "nats-3" || !reflect.DeepEqual(servers[1].Collectors, []string{"varz", "jsz"}) {
+		t.Fatalf("Unexpected collectors of the annotated pod: %+v", servers[1])
+	}
+
+	k.Namespace = "other"
+	if _, err := k.Discover(context.Background()); err == nil {
+		t.Fatalf("Expected an error listing the pods")
+	}
+}
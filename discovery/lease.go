@@ -0,0 +1,272 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DefaultLeaseDuration is how long the leader holds the lease without
+// renewing it before another replica may take it over.
+const DefaultLeaseDuration = 15 * time.Second
+
+// leaseTimeFormat is the format of the times of a lease, in microseconds.
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// KubernetesLease elects one of the replicas of the exporter as the
+// leader, the one holding a Kubernetes Lease, as client-go leader election
+// does.  The leader renews the lease a few times a lease duration, and the
+// other replicas take it over once it has not been renewed for a lease
+// duration.
+type KubernetesLease struct {
+	// API of the cluster, and the namespace of the lease.
+	API *Kubernetes
+
+	// Name of the lease.
+	Name string
+
+	// Identity of the replica, e.g. the name of its pod.
+	Identity string
+
+	// Duration of the lease.  Zero uses DefaultLeaseDuration.
+	Duration time.Duration
+
+	mu      sync.Mutex
+	leader  bool
+	renewed time.Time
+}
+
+// NewKubernetesLease returns the election of the replica holding the lease
+// of the given name, from within the cluster, as the exporter's pod, named
+// after its hostname.  An empty namespace is the namespace of the pod.
+func NewKubernetesLease(namespace, name string, duration time.Duration) (*KubernetesLease, error) {
+	k, err := inCluster(namespace)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesLease{API: k, Name: name, Identity: identity, Duration: duration}, nil
+}
+
+// lease is the part of a Kubernetes Lease the election uses.
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// expired reports whether the holder has not renewed the lease for its
+// duration.
+func (s *leaseSpec) expired(now time.Time) bool {
+	renewed, err := time.Parse(time.RFC3339, s.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(s.LeaseDurationSeconds) * time.Second))
+}
+
+// Leader reports whether the replica holds the lease.
+func (l *KubernetesLease) Leader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// Campaign tries to acquire or renew the lease every fifth of its duration
+// until done is closed, then releases it if held.  The replica steps down
+// when it fails to renew the lease for two thirds of its duration, before
+// another may take it over.
+func (l *KubernetesLease) Campaign(done <-chan struct{}) {
+	duration := l.duration()
+	ticker := time.NewTicker(duration / 5)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), duration/5)
+		held, err := l.tryAcquire(ctx)
+		cancel()
+		l.mu.Lock()
+		switch {
+		case err == nil:
+			if held != l.leader {
+				collector.Noticef("Lease %s: leader %v", l.Name, held)
+			}
+			l.leader = held
+			if held {
+				l.renewed = time.Now()
+			}
+		case l.leader && time.Since(l.renewed) > duration*2/3:
+			collector.Errorf("Unable to renew the lease %s, stepping down: %v", l.Name, err)
+			l.leader = false
+		default:
+			collector.Debugf("Unable to acquire the lease %s: %v", l.Name, err)
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			l.release()
+			return
+		}
+	}
+}
+
+func (l *KubernetesLease) duration() time.Duration {
+	if l.Duration <= 0 {
+		return DefaultLeaseDuration
+	}
+	return l.Duration
+}
+
+// leaseURL returns the URL of the leases of the namespace, or of the lease
+// if named.
+func (l *KubernetesLease) leaseURL(name string) string {
+	u := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+		strings.TrimSuffix(l.API.APIServer, "/"), url.PathEscape(l.API.Namespace))
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+// tryAcquire creates the lease, takes it over once expired, or renews it
+// if held, returning whether the replica holds it.  Concurrent updates by
+// other replicas are rejected by the API as conflicts.
+func (l *KubernetesLease) tryAcquire(ctx context.Context) (bool, error) {
+	now := time.Now()
+	cur, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if cur == nil {
+		cur = &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		cur.Metadata.Name, cur.Metadata.Namespace = l.Name, l.API.Namespace
+		cur.Spec = l.spec(now)
+		cur.Spec.AcquireTime = now.UTC().Format(leaseTimeFormat)
+		return l.write(ctx, "POST", l.leaseURL(""), cur)
+	}
+	spec := cur.Spec
+	if spec.HolderIdentity != l.Identity && spec.HolderIdentity != "" && !spec.expired(now) {
+		return false, nil
+	}
+	cur.Spec = l.spec(now)
+	if spec.HolderIdentity == l.Identity {
+		cur.Spec.AcquireTime = spec.AcquireTime
+		cur.Spec.LeaseTransitions = spec.LeaseTransitions
+	} else {
+		cur.Spec.AcquireTime = now.UTC().Format(leaseTimeFormat)
+		cur.Spec.LeaseTransitions = spec.LeaseTransitions + 1
+	}
+	return l.write(ctx, "PUT", l.leaseURL(l.Name), cur)
+}
+
+// spec returns the spec of the lease held by the replica, renewed now.
+func (l *KubernetesLease) spec(now time.Time) leaseSpec {
+	return leaseSpec{
+		HolderIdentity:       l.Identity,
+		LeaseDurationSeconds: int(l.duration() / time.Second),
+		RenewTime:            now.UTC().Format(leaseTimeFormat),
+	}
+}
+
+// get returns the lease, or nil if it does not exist.
+func (l *KubernetesLease) get(ctx context.Context) (*lease, error) {
+	resp, err := l.API.do(ctx, "GET", l.leaseURL(l.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting the lease: %s", resp.Status)
+	}
+	cur := &lease{}
+	if err := json.NewDecoder(resp.Body).Decode(cur); err != nil {
+		return nil, fmt.Errorf("getting the lease: %v", err)
+	}
+	return cur, nil
+}
+
+// write creates or updates the lease, returning whether it was written,
+// rather than by another replica first.
+func (l *KubernetesLease) write(ctx context.Context, method, u string, cur *lease) (bool, error) {
+	body, err := json.Marshal(cur)
+	if err != nil {
+		return false, err
+	}
+	resp, err := l.API.do(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	}
+	return false, fmt.Errorf("writing the lease: %s", resp.Status)
+}
+
+// release gives up the lease, if held, so that another replica takes it
+// over without waiting for it to expire.
+func (l *KubernetesLease) release() {
+	l.mu.Lock()
+	leader := l.leader
+	l.leader = false
+	l.mu.Unlock()
+	if !leader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.duration()/5)
+	defer cancel()
+	cur, err := l.get(ctx)
+	if err != nil || cur == nil || cur.Spec.HolderIdentity != l.Identity {
+		return
+	}
+	cur.Spec.HolderIdentity = ""
+	cur.Spec.LeaseDurationSeconds = 1
+	if _, err := l.write(ctx, "PUT", l.leaseURL(l.Name), cur); err != nil {
+		collector.Debugf("Unable to release the lease %s: %v", l.Name, err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeases serves a single lease, rejecting the updates of a stale
+// version as the Kubernetes API does.
+type fakeLeases struct {
+	sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	const leases = "/apis/coordination.k8s.io/v1/namespaces/nats/leases"
+	switch {
+	case r.Method == "GET" && r.URL.Path == leases+"/exporter":
+		if f.lease == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case r.Method == "POST" && r.URL.Path == leases:
+		if f.lease != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.write(w, r, http.StatusCreated)
+	case r.Method == "PUT" && r.URL.Path == leases+"/exporter":
+		f.write(w, r, http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeLeases) write(w http.ResponseWriter, r *http.Request, status int) {
+	l := &lease{}
+	if err := json.NewDecoder(r.Body).Decode(l); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if f.lease != nil && l.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	f.version++
+	l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.lease = l
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(l)
+}
+
+func TestKubernetesLease(t *testing.T) {
+	leases := &fakeLeases{}
+	api := httptest.NewServer(leases)
+	defer api.Close()
+
+	k := &Kubernetes{APIServer: api.URL, Namespace: "nats"}
+	a := &KubernetesLease{API: k, Name: "exporter", Identity: "a", Duration: time.Minute}
+	b := &KubernetesLease{API: k, Name: "exporter", Identity: "b", Duration: time.Minute}
+	ctx := context.Background()
+
+	if held, err := a.tryAcquire(ctx); err != nil || !held {
+		t.Fatalf("Expected a to create the lease, got %v, %v", held, err)
+	}
+	if held, err := b.tryAcquire(ctx); err != nil || held {
+		t.Fatalf("Expected b not to take over the lease held by a, got %v, %v", held, err)
+	}
+	if held, err := a.tryAcquire(ctx); err != nil || !held {
+		t.Fatalf("Expected a to renew the lease, got %v, %v", held, err)
+	}
+
+	// The lease expires when a stops renewing it.
+	leases.Lock()
+	leases.lease.Spec.RenewTime = time.Now().Add(-2 * time.Minute).UTC().Format(leaseTimeFormat)
+	leases.Unlock()
+	if held, err := b.tryAcquire(ctx); err != nil || !held {
+		t.Fatalf("Expected b to take over the expired lease, got %v, %v", held, err)
+	}
+	leases.Lock()
+	spec := leases.lease.Spec
+	leases.Unlock()
+	if spec.HolderIdentity != "b" || spec.LeaseTransitions != 1 {
+		t.Fatalf("Unexpected lease: %+v", spec)
+	}
+
+	// Releasing the lease hands it over right away.
+	b.mu.Lock()
+	b.leader = true
+	b.mu.Unlock()
+	b.release()
+	if b.Leader() {
+		t.Fatalf("Expected b to step down")
+	}
+	if held, err := a.tryAcquire(ctx); err != nil || !held {
+		t.Fatalf("Expected a to take over the released lease, got %v, %v", held, err)
+	}
+}
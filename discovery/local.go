@@ -0,0 +1,136 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DefaultLocalRetryInterval is how often the local ports are probed until
+// one of them accepts connections.
+var DefaultLocalRetryInterval = time.Second
+
+// Local discovers the NATS server running beside the exporter, e.g. in the
+// same pod as a sidecar, as the first of the monitor ports on localhost
+// accepting connections.  Until the server comes up, the ports are probed
+// again every RetryInterval rather than on the discovery interval.
+type Local struct {
+	// Host probed, localhost unless set.
+	Host string
+
+	// Ports probed, in order.  If empty, DefaultMonitorPort is probed.
+	Ports []int
+
+	// Scheme of the monitor URL, http unless set.
+	Scheme string
+
+	// RetryInterval is how often the ports are probed until the server
+	// is found.  Zero uses DefaultLocalRetryInterval.
+	RetryInterval time.Duration
+
+	mu    sync.Mutex
+	found bool
+}
+
+// Discover probes the ports, returning the server on the first accepting
+// connections, with its host:port as its ID.  Until the server is first
+// found none is returned, and afterwards an error if none answers, so
+// that the server last found keeps being polled while it restarts.
+func (l *Local) Discover(ctx context.Context) ([]*collector.CollectedServer, error) {
+	host := l.Host
+	if host == "" {
+		host = "localhost"
+	}
+	ports := l.Ports
+	if len(ports) == 0 {
+		ports = []int{DefaultMonitorPort}
+	}
+	scheme := l.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	for _, port := range ports {
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		if l.probe(ctx, addr) {
+			l.mu.Lock()
+			l.found = true
+			l.mu.Unlock()
+			return []*collector.CollectedServer{{ID: addr, URL: scheme + "://" + addr}}, nil
+		}
+	}
+	l.mu.Lock()
+	found := l.found
+	l.mu.Unlock()
+	if !found {
+		collector.Debugf("Waiting for a NATS server on %s ports %v", host, ports)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("no NATS server is listening on %s ports %v", host, ports)
+}
+
+// probe reports whether addr accepts connections.
+func (l *Local) probe(ctx context.Context, addr string) bool {
+	ctx, cancel := context.WithTimeout(ctx, l.retryInterval())
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (l *Local) retryInterval() time.Duration {
+	if l.RetryInterval <= 0 {
+		return DefaultLocalRetryInterval
+	}
+	return l.RetryInterval
+}
+
+// Watch signals every RetryInterval until the server is first found, so
+// that the exporter polls it as soon as it comes up, or done is closed.
+func (l *Local) Watch(done <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(l.retryInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			l.mu.Lock()
+			found := l.found
+			l.mu.Unlock()
+			if found {
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLocalDiscover(t *testing.T) {
+	// A port nothing listens on any more.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	l := &Local{Host: "127.0.0.1", Ports: []int{closedPort}, RetryInterval: 10 * time.Millisecond}
+	done := make(chan struct{})
+	defer close(done)
+	changed := l.Watch(done)
+
+	// Until the server comes up, none is found and the ports are probed
+	// again.
+	servers, err := l.Discover(context.Background())
+	if err != nil || len(servers) != 0 {
+		t.Fatalf("Expected no server yet, got %+v, %v", servers, err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the ports to be probed again")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer ln.Close()
+	l.Ports = append(l.Ports, ln.Addr().(*net.TCPAddr).Port)
+	servers, err = l.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	addr := ln.Addr().String()
+	if len(servers) != 1 || servers[0].ID != addr || servers[0].URL != "http://"+addr {
+		t.Fatalf("Expected the server on %s, got %+v", addr, servers)
+	}
+
+	// Once found, the server last found is kept while it restarts.
+	ln.Close()
+	if _, err := l.Discover(context.Background()); err == nil {
+		t.Fatalf("Expected an error once the server is gone")
+	}
+}
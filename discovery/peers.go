@@ -0,0 +1,83 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// Peers discovers the other servers of the cluster of a seed server, from
+// the routes in the /routez of the seed.  The peers are assumed to serve
+// their monitor endpoints on the same scheme and port as the seed, unless
+// MonitorPort is set.
+type Peers struct {
+	// Seed is the monitor URL of the seed server.
+	Seed *collector.CollectedServer
+
+	// MonitorPort of the peers.  Zero uses the port of the seed.
+	MonitorPort int
+
+	// Options the seed is polled with.  If nil, the defaults are used.
+	Options *collector.CollectorOptions
+}
+
+// Discover gets the routes of the seed, returning a server for each peer,
+// labeled with the cluster of the seed, with the URL of its monitor
+// endpoint as its ID.
+func (p *Peers) Discover(ctx context.Context) ([]*collector.CollectedServer, error) {
+	seed, err := url.Parse(p.Seed.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed url %q: %v", p.Seed.URL, err)
+	}
+	port := seed.Port()
+	if p.MonitorPort > 0 {
+		port = strconv.Itoa(p.MonitorPort)
+	}
+	if port == "" {
+		port = strconv.Itoa(DefaultMonitorPort)
+	}
+	opts := p.Options
+	if opts == nil {
+		opts = &collector.CollectorOptions{}
+	}
+	ips, err := collector.GetRouteIPsFromRoutez(ctx, p.Seed.URL, p.Seed.Headers, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the routes of %s: %v", p.Seed.ID, err)
+	}
+
+	servers := make([]*collector.CollectedServer, 0, len(ips))
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		u := url.URL{Scheme: seed.Scheme, Host: net.JoinHostPort(ip, port), User: seed.User}
+		id := seed.Scheme + "://" + u.Host
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		servers = append(servers, &collector.CollectedServer{
+			ID:      id,
+			URL:     u.String(),
+			Headers: p.Seed.Headers,
+			Cluster: p.Seed.Cluster,
+			Labels:  p.Seed.Labels,
+		})
+	}
+	return servers, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+func TestPeersDiscover(t *testing.T) {
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/routez" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"routes": [{"ip": "10.0.0.2", "port": 6222}, {"ip": "10.0.0.3", "port": 6222},
+  {"ip": "10.0.0.3", "port": 41234}]}`)
+	}))
+	defer seed.Close()
+
+	p := &Peers{
+		Seed:        &collector.CollectedServer{ID: "seed", URL: seed.URL, Cluster: "east"},
+		MonitorPort: 8222,
+	}
+	servers, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 peers, got %d", len(servers))
+	}
+	if servers[0].ID != "http://10.0.0.2:8222" || servers[0].URL != "http://10.0.0.2:8222" ||
+		servers[0].Cluster != "east" {
+		t.Fatalf("Unexpected server: %+v", servers[0])
+	}
+}
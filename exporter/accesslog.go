@@ -0,0 +1,69 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// accessRecorder records the status and size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (ar *accessRecorder) WriteHeader(status int) {
+	if ar.status == 0 {
+		ar.status = status
+	}
+	ar.ResponseWriter.WriteHeader(status)
+}
+
+func (ar *accessRecorder) Write(b []byte) (int, error) {
+	if ar.status == 0 {
+		ar.status = http.StatusOK
+	}
+	n, err := ar.ResponseWriter.Write(b)
+	ar.bytes += n
+	return n, err
+}
+
+// withAccessLog returns h, logging every request with the address of the
+// client, the path, status, size and duration of the response, and the
+// user agent.
+func withAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ar := &accessRecorder{ResponseWriter: rw}
+		h.ServeHTTP(ar, r)
+		if ar.status == 0 {
+			ar.status = http.StatusOK
+		}
+
+		client := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		if client == "" || client == "@" {
+			client = "-"
+		}
+		collector.Noticef("%s %q %d %d %.3fs %q", client, r.Method+" "+r.URL.Path,
+			ar.status, ar.bytes, time.Since(start).Seconds(), r.UserAgent())
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// targetsPath is the path of the admin API listing, adding and removing
+// the servers polled.
+const targetsPath = "/api/targets"
+
+// target is a server as listed and added by the admin API.
+type target struct {
+	ID      string            `json:"id"`
+	URL     string            `json:"url"`
+	Cluster string            `json:"cluster,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// errTargetExists is returned adding a target with the ID of a server
+// already polled.
+var errTargetExists = fmt.Errorf("a server with this id is already polled")
+
+// AddTarget is an exporter API to add a server to the running exporter,
+// replacing the collectors to poll it too.
+func (ne *NATSExporter) AddTarget(cs *collector.CollectedServer) error {
+	ne.Lock()
+	defer ne.Unlock()
+
+	if !ne.running {
+		return fmt.Errorf("the exporter is not running")
+	}
+	if err := collector.CheckServerLabels(cs.Labels); err != nil {
+		return err
+	}
+	for _, s := range ne.knownServers() {
+		if s.ID == cs.ID {
+			return errTargetExists
+		}
+	}
+	prev := ne.targets
+	ne.targets = append(ne.targets, cs)
+	if err := ne.recreateCollectors(); err != nil {
+		ne.targets = prev
+		return err
+	}
+	collector.Noticef("Added server %s, %s", cs.ID, redactURL(cs.URL))
+	return nil
+}
+
+// RemoveTarget is an exporter API to remove a server added by AddTarget
+// from the running exporter.  It reports whether there was one with the
+// ID.
+func (ne *NATSExporter) RemoveTarget(id string) (bool, error) {
+	ne.Lock()
+	defer ne.Unlock()
+
+	for i, s := range ne.targets {
+		if s.ID != id {
+			continue
+		}
+		prev := ne.targets
+		ne.targets = make([]*collector.CollectedServer, 0, len(prev)-1)
+		ne.targets = append(append(ne.targets, prev[:i]...), prev[i+1:]...)
+		if ne.running {
+			if err := ne.recreateCollectors(); err != nil {
+				ne.targets = prev
+				return false, err
+			}
+		}
+		collector.Noticef("Removed server %s, %s", s.ID, redactURL(s.URL))
+		return true, nil
+	}
+	return false, nil
+}
+
+// handleTargets lists the servers polled on GET, adds one on POST, and
+// removes one added by the API, given by its id parameter, on DELETE.
+func (ne *NATSExporter) handleTargets(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		ne.Lock()
+		servers := ne.allServers()
+		targets := make([]target, 0, len(servers))
+		for _, s := range servers {
+			targets = append(targets, target{ID: s.ID, URL: redactURL(s.URL), Cluster: s.Cluster, Labels: s.Labels})
+		}
+		ne.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(targets); err != nil {
+			collector.Debugf("Unable to write the targets: %v", err)
+		}
+	case "POST":
+		var t target
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid target: %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := url.ParseRequestURI(t.URL)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid target url: %v", err), http.StatusBadRequest)
+			return
+		}
+		if t.ID == "" {
+			t.ID = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+		}
+		cs := &collector.CollectedServer{ID: t.ID, URL: t.URL, Cluster: t.Cluster, Labels: t.Labels}
+		switch err := ne.AddTarget(cs); err {
+		case nil:
+			rw.WriteHeader(http.StatusCreated)
+		case errTargetExists:
+			http.Error(rw, err.Error(), http.StatusConflict)
+		default:
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		}
+	case "DELETE":
+		removed, err := ne.RemoveTarget(r.URL.Query().Get("id"))
+		switch {
+		case err != nil:
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+		case !removed:
+			http.Error(rw, "no server with this id was added by the API", http.StatusNotFound)
+		default:
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		rw.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
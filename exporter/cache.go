@@ -0,0 +1,222 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachedCollector serves the metrics last polled from the wrapped
+// collector rather than polling the NATS servers on every scrape.
+type cachedCollector struct {
+	sync.RWMutex
+	collector prometheus.Collector
+	interval  time.Duration // How often the collector is polled.
+	ttl       time.Duration
+	metrics   []prometheus.Metric
+	updated   time.Time
+}
+
+func newCachedCollector(c prometheus.Collector, interval, ttl time.Duration) *cachedCollector {
+	return &cachedCollector{collector: c, interval: interval, ttl: ttl}
+}
+
+// Describe describes the wrapped collector.
+func (cc *cachedCollector) Describe(ch chan<- *prometheus.Desc) {
+	cc.collector.Describe(ch)
+}
+
+// Collect sends the cached metrics, unless they are older than the TTL.
+func (cc *cachedCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.RLock()
+	defer cc.RUnlock()
+
+	if cc.ttl > 0 && time.Since(cc.updated) > cc.ttl {
+		collector.Debugf("Cached metrics expired, last polled at %v", cc.updated)
+		return
+	}
+	for _, m := range cc.metrics {
+		ch <- m
+	}
+}
+
+// refresh polls the wrapped collector and replaces the cached metrics.
+func (cc *cachedCollector) refresh(ctx context.Context) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	if c, ok := cc.collector.(collector.ContextCollector); ok {
+		c.CollectWithContext(ctx, ch)
+	} else {
+		cc.collector.Collect(ch)
+	}
+	close(ch)
+	metrics := <-done
+
+	cc.Lock()
+	cc.metrics = metrics
+	cc.updated = time.Now()
+	cc.Unlock()
+}
+
+// pollCollectors refreshes the cached collectors polled on the interval,
+// unless another replica is the leader.
+func (ne *NATSExporter) pollCollectors(interval time.Duration) {
+	ne.Lock()
+	if !ne.leader() {
+		ne.Unlock()
+		return
+	}
+	collectors := make([]prometheus.Collector, len(ne.collectors))
+	copy(collectors, ne.collectors)
+	// The polls spread out are given the time of the spread on top.
+	timeout := ne.opts.CollectTimeout
+	if timeout > 0 {
+		timeout += ne.opts.PollSpread
+	}
+	ne.Unlock()
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	ctx, span := ne.startTrace(ctx, "poll")
+	defer span.Finish(nil)
+
+	var wg sync.WaitGroup
+	for _, c := range collectors {
+		cc, ok := c.(*cachedCollector)
+		if !ok || cc.interval != interval {
+			continue
+		}
+		wg.Add(1)
+		go func(cc *cachedCollector) {
+			defer wg.Done()
+			cc.refresh(ctx)
+		}(cc)
+	}
+	wg.Wait()
+}
+
+// startPolling polls the NATS servers on the configured intervals until
+// the exporter is stopped.
+// caller must lock
+func (ne *NATSExporter) startPolling() {
+	quit := make(chan struct{})
+	ne.pollQuit = quit
+	for _, interval := range ne.pollIntervals() {
+		go func(interval time.Duration) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				ne.pollCollectors(interval)
+				select {
+				case <-ticker.C:
+				case <-quit:
+					return
+				}
+			}
+		}(interval)
+	}
+}
+
+// pollIntervals returns the intervals the collectors are polled on:
+// PollInterval and those of the collectors with their own.
+// caller must lock
+func (ne *NATSExporter) pollIntervals() []time.Duration {
+	intervals := []time.Duration{ne.opts.PollInterval}
+	seen := map[time.Duration]bool{ne.opts.PollInterval: true}
+	for _, interval := range ne.opts.PollIntervals {
+		if !seen[interval] {
+			seen[interval] = true
+			intervals = append(intervals, interval)
+		}
+	}
+	return intervals
+}
+
+// pollInterval returns the interval the collector of an endpoint is
+// polled on.
+// caller must lock
+func (ne *NATSExporter) pollInterval(system, endpoint string) time.Duration {
+	key := collectorEndpoint{system, endpoint}.key()
+	for name, interval := range ne.opts.PollIntervals {
+		if e, ok := serverCollectors[name]; ok && e.key() == key {
+			return interval
+		}
+	}
+	return ne.opts.PollInterval
+}
+
+// checkPollIntervals checks the poll intervals of the collectors name
+// known collectors, polled in the background, and the polls are spread
+// within the intervals.
+func checkPollIntervals(opts *NATSExporterOptions) error {
+	if len(opts.PollIntervals) > 0 && opts.PollInterval <= 0 {
+		return fmt.Errorf("poll intervals of the collectors require a poll interval")
+	}
+	if opts.PollSpread > 0 && opts.PollInterval <= 0 {
+		return fmt.Errorf("the poll spread requires a poll interval")
+	}
+	if opts.PollSpread > 0 && opts.PollSpread >= opts.PollInterval {
+		return fmt.Errorf("the poll spread must be shorter than the poll interval")
+	}
+	for name, interval := range opts.PollIntervals {
+		if _, ok := serverCollectors[name]; !ok {
+			return fmt.Errorf("poll interval of unknown collector %q", name)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid poll interval %v of collector %q", interval, name)
+		}
+		if opts.PollSpread >= interval {
+			return fmt.Errorf("the poll spread must be shorter than the poll interval of collector %q", name)
+		}
+	}
+	return nil
+}
+
+// stopPolling stops the background polling, if running.
+// caller must lock
+func (ne *NATSExporter) stopPolling() {
+	if ne.pollQuit != nil {
+		close(ne.pollQuit)
+		ne.pollQuit = nil
+	}
+}
+
+// cacheTTL returns how long the metrics polled on the interval may be
+// served: CacheTTL, unless shorter than the interval of a collector polled
+// less often than PollInterval, or else three intervals.
+func (ne *NATSExporter) cacheTTL(interval time.Duration) time.Duration {
+	if ne.opts.CacheTTL > 0 && (interval <= ne.opts.PollInterval || ne.opts.CacheTTL >= interval) {
+		return ne.opts.CacheTTL
+	}
+	return 3 * interval
+}
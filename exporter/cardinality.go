@@ -0,0 +1,142 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// otherLabelValue is the value of the labels that differ between the
+// series aggregated over the series limit.
+const otherLabelValue = "other"
+
+// checkSeriesLimits checks the series limits name known collectors.
+func checkSeriesLimits(opts *NATSExporterOptions) error {
+	if opts.MaxSeries < 0 {
+		return fmt.Errorf("invalid series limit %d", opts.MaxSeries)
+	}
+	for name, limit := range opts.SeriesLimits {
+		if _, ok := serverCollectors[name]; !ok {
+			return fmt.Errorf("series limit of unknown collector %q", name)
+		}
+		if limit < 1 {
+			return fmt.Errorf("invalid series limit %d of collector %q", limit, name)
+		}
+	}
+	return nil
+}
+
+// seriesLimits returns the series limits of the collectors with their own.
+// caller must lock
+func (ne *NATSExporter) seriesLimits() map[prometheus.Collector]int {
+	if len(ne.opts.SeriesLimits) == 0 {
+		return nil
+	}
+	limits := make(map[prometheus.Collector]int)
+	for name, limit := range ne.opts.SeriesLimits {
+		if c, ok := ne.endpoints[serverCollectors[name].key()]; ok {
+			limits[c] = limit
+		}
+	}
+	return limits
+}
+
+// limitedGatherer gathers at most limit series of each metric.
+type limitedGatherer struct {
+	prometheus.Gatherer
+	limit int
+}
+
+// Gather gathers the metric families of the underlying gatherer, limiting
+// their series.
+func (lg *limitedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := lg.Gatherer.Gather()
+	return limitSeries(mfs, lg.limit), err
+}
+
+// limitSeries keeps the limit-1 series of greatest value of each gauge and
+// untyped metric with more than limit series, summing the others into a
+// single series whose labels differing between them are "other".  Counters
+// are left as they are, since the series summed change as their values
+// do, which would make the sum decrease, as are histograms and summaries,
+// and all the metrics if limit is not positive.
+func limitSeries(mfs []*dto.MetricFamily, limit int) []*dto.MetricFamily {
+	if limit <= 0 {
+		return mfs
+	}
+	for _, mf := range mfs {
+		switch mf.GetType() {
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		default:
+			continue
+		}
+		if len(mf.Metric) <= limit {
+			continue
+		}
+		collector.Debugf("Aggregating %d series of %s over the limit of %d",
+			len(mf.Metric)-limit+1, mf.GetName(), limit)
+		metrics := mf.Metric
+		sort.SliceStable(metrics, func(i, j int) bool {
+			return metricValue(metrics[i]) > metricValue(metrics[j])
+		})
+		other := aggregateMetrics(mf.GetType(), metrics[limit-1:])
+		mf.Metric = append(metrics[:limit-1:limit-1], other)
+	}
+	return mfs
+}
+
+// aggregateMetrics returns the sum of the metrics, labeled with the values
+// of their labels shared by all of them, or "other".
+func aggregateMetrics(typ dto.MetricType, metrics []*dto.Metric) *dto.Metric {
+	var sum float64
+	for _, m := range metrics {
+		sum += metricValue(m)
+	}
+	labels := make([]*dto.LabelPair, 0, len(metrics[0].Label))
+	for i, lp := range metrics[0].Label {
+		value := lp.GetValue()
+		for _, m := range metrics[1:] {
+			if i >= len(m.Label) || m.Label[i].GetValue() != value {
+				value = otherLabelValue
+				break
+			}
+		}
+		labels = append(labels, &dto.LabelPair{Name: proto.String(lp.GetName()), Value: proto.String(value)})
+	}
+	other := &dto.Metric{Label: labels}
+	switch typ {
+	case dto.MetricType_GAUGE:
+		other.Gauge = &dto.Gauge{Value: proto.Float64(sum)}
+	default:
+		other.Untyped = &dto.Untyped{Value: proto.Float64(sum)}
+	}
+	return other
+}
+
+// metricValue returns the value of a gauge or untyped metric.
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return 0
+}
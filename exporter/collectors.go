@@ -0,0 +1,87 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// serverCollectors are the collectors a server may name to be polled by,
+// after their flags, by their endpoint.
+var serverCollectors = map[string]collectorEndpoint{
+	"varz":           {collector.CoreSystem, "varz"},
+	"connz":          {collector.CoreSystem, "connz"},
+	"subz":           {collector.CoreSystem, "subsz"},
+	"subsz":          {collector.CoreSystem, "subsz"},
+	"routez":         {collector.CoreSystem, "routez"},
+	"gatewayz":       {collector.CoreSystem, "gatewayz"},
+	"jsz":            {collector.CoreSystem, "jsz"},
+	"ipqueuesz":      {collector.CoreSystem, "ipqueuesz"},
+	"replicatorVarz": {collector.ReplicatorSystem, "varz"},
+	"channelz":       {collector.StreamingSystem, "channelsz"},
+	"serverz":        {collector.StreamingSystem, "serverz"},
+	"clientz":        {collector.StreamingSystem, "clientsz"},
+	"storez":         {collector.StreamingSystem, "storez"},
+}
+
+// collectedEndpoints returns the endpoints with a collector: those
+// selected by the options, then those named by the servers polled.
+// caller must lock
+func (ne *NATSExporter) collectedEndpoints() []collectorEndpoint {
+	endpoints := selectedEndpoints(ne.opts)
+	seen := make(map[string]bool)
+	for _, e := range endpoints {
+		seen[e.key()] = true
+	}
+	for _, s := range ne.allServers() {
+		for _, name := range s.Collectors {
+			e, ok := serverCollectors[name]
+			if !ok {
+				collector.Errorf("Ignoring the unknown collector %q of server %s", name, s.ID)
+				continue
+			}
+			if !seen[e.key()] {
+				seen[e.key()] = true
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+	return endpoints
+}
+
+// endpointServers returns the servers polled at an endpoint: those naming
+// its collector, and those naming none if the endpoint is selected.
+// caller must lock
+func (ne *NATSExporter) endpointServers(system, endpoint string) []*collector.CollectedServer {
+	key := collectorEndpoint{system, endpoint}.key()
+	selected := false
+	for _, e := range selectedEndpoints(ne.opts) {
+		if e.key() == key {
+			selected = true
+		}
+	}
+	var servers []*collector.CollectedServer
+	for _, s := range ne.allServers() {
+		polled := len(s.Collectors) == 0 && selected
+		for _, name := range s.Collectors {
+			if e, ok := serverCollectors[name]; ok && e.key() == key {
+				polled = true
+			}
+		}
+		if polled {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DefaultDiscoveryInterval is how often the discoverers are queried.
+var DefaultDiscoveryInterval = 30 * time.Second
+
+// Discoverer finds NATS servers to poll, e.g. in a service registry.
+type Discoverer interface {
+	// Discover returns the servers currently found.
+	Discover(ctx context.Context) ([]*collector.CollectedServer, error)
+}
+
+// WatchingDiscoverer is a Discoverer that watches for changes to the
+// servers, to be queried again as soon as they change rather than on the
+// next interval.
+type WatchingDiscoverer interface {
+	Discoverer
+
+	// Watch signals possible changes on the returned channel until done
+	// is closed.
+	Watch(done <-chan struct{}) <-chan struct{}
+}
+
+// discovery holds the servers last found by a discoverer.
+type discovery struct {
+	discoverer Discoverer
+	servers    []*collector.CollectedServer
+}
+
+// AddDiscoverer is an exporter API to add a source of servers, polled
+// along with those added directly.  Once the exporter is started, the
+// discoverer is queried every DiscoveryInterval and the collectors are
+// replaced whenever the servers it finds change.  If a query fails, the
+// servers last found are kept.
+func (ne *NATSExporter) AddDiscoverer(d Discoverer) error {
+	ne.Lock()
+	defer ne.Unlock()
+
+	if ne.running {
+		return fmt.Errorf("discoverers cannot be added after the exporter is started")
+	}
+	ne.discoveries = append(ne.discoveries, &discovery{discoverer: d})
+	return nil
+}
+
+// allServers returns the servers polled: those added, those added through
+// the admin API and those discovered, of the shard of the exporter if
+// sharded.
+// caller must lock
+func (ne *NATSExporter) allServers() []*collector.CollectedServer {
+	return shardServers(ne.knownServers(), ne.opts.ShardIndex, ne.opts.ShardCount)
+}
+
+// knownServers returns the servers added, those added through the admin
+// API and those discovered, of every shard.  A discovered server with the
+// ID of another is left out.
+// caller must lock
+func (ne *NATSExporter) knownServers() []*collector.CollectedServer {
+	if len(ne.discoveries) == 0 && len(ne.targets) == 0 {
+		return ne.servers
+	}
+	servers := make([]*collector.CollectedServer, 0, len(ne.servers)+len(ne.targets))
+	ids := make(map[string]bool)
+	for _, s := range ne.servers {
+		servers = append(servers, s)
+		ids[s.ID] = true
+	}
+	for _, s := range ne.targets {
+		servers = append(servers, s)
+		ids[s.ID] = true
+	}
+	for _, d := range ne.discoveries {
+		for _, s := range d.servers {
+			if ids[s.ID] {
+				continue
+			}
+			servers = append(servers, s)
+			ids[s.ID] = true
+		}
+	}
+	return servers
+}
+
+// startDiscovery queries the discoverers on the configured interval until
+// the exporter is stopped.
+// caller must lock
+func (ne *NATSExporter) startDiscovery() {
+	if len(ne.discoveries) == 0 {
+		return
+	}
+	quit := make(chan struct{})
+	ne.discoveryQuit = quit
+	interval := ne.opts.DiscoveryInterval
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	for _, d := range ne.discoveries {
+		go func(d *discovery) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			var changed <-chan struct{}
+			if w, ok := d.discoverer.(WatchingDiscoverer); ok {
+				changed = w.Watch(quit)
+			}
+			for {
+				ne.discover(d, interval)
+				select {
+				case <-ticker.C:
+				case <-changed:
+				case <-quit:
+					return
+				}
+			}
+		}(d)
+	}
+}
+
+// stopDiscovery stops querying the discoverers, if running.
+// caller must lock
+func (ne *NATSExporter) stopDiscovery() {
+	if ne.discoveryQuit != nil {
+		close(ne.discoveryQuit)
+		ne.discoveryQuit = nil
+	}
+}
+
+// discover queries a discoverer, replacing the collectors if the servers
+// it finds changed.
+func (ne *NATSExporter) discover(d *discovery, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	servers, err := d.discoverer.Discover(ctx)
+	if err != nil {
+		collector.Errorf("Unable to discover servers: %v", err)
+		return
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+
+	ne.Lock()
+	defer ne.Unlock()
+	if !ne.running || sameServers(d.servers, servers) {
+		return
+	}
+	collector.Noticef("Discovered %d servers", len(servers))
+	prev := d.servers
+	d.servers = servers
+	if err := ne.recreateCollectors(); err != nil {
+		collector.Errorf("Unable to create the collectors of the servers discovered: %v", err)
+		d.servers = prev
+	}
+}
+
+// recreateCollectors replaces all the collectors, e.g. once the servers
+// changed, keeping them if the new ones cannot be created.
+// caller must lock
+func (ne *NATSExporter) recreateCollectors() error {
+	if err := ne.prepareCollectors(); err != nil {
+		return err
+	}
+	ne.stopPolling()
+	ne.clearCollectors()
+	ne.createCollectors()
+	if ne.opts.PollInterval > 0 {
+		ne.startPolling()
+	}
+	return nil
+}
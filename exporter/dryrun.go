@@ -0,0 +1,98 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// DryRun polls the servers once, discovering them first, and writes the
+// metrics the exporter would serve to w, with their type, label names and
+// help, without starting the exporter.
+func (ne *NATSExporter) DryRun(w io.Writer) error {
+	ne.Lock()
+	if ne.running {
+		ne.Unlock()
+		return fmt.Errorf("the exporter is running")
+	}
+	timeout := ne.opts.CollectTimeout
+	if timeout <= 0 {
+		timeout = collector.DefaultCollectTimeout
+	}
+	for _, d := range ne.discoveries {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		servers, err := d.discoverer.Discover(ctx)
+		cancel()
+		if err != nil {
+			ne.Unlock()
+			return fmt.Errorf("unable to discover servers: %v", err)
+		}
+		d.servers = servers
+	}
+	filter, err := newMetricFilter(ne.opts.MetricsInclude, ne.opts.MetricsExclude)
+	if err != nil {
+		ne.Unlock()
+		return err
+	}
+	rules, err := compileRelabelConfigs(ne.opts.RelabelConfigs)
+	if err != nil {
+		ne.Unlock()
+		return err
+	}
+	ne.filter, ne.relabelRules = filter, rules
+	err = ne.initializeCollectors()
+	ne.Unlock()
+
+	defer func() {
+		ne.Lock()
+		ne.clearCollectors()
+		ne.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	mfs, err := ne.scrapeGatherer(ctx).Gather()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tLABELS\tHELP")
+	for _, mf := range mfs {
+		names := make(map[string]bool)
+		for _, m := range mf.Metric {
+			for _, lp := range m.Label {
+				names[lp.GetName()] = true
+			}
+		}
+		labels := make([]string, 0, len(names))
+		for name := range names {
+			labels = append(labels, name)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mf.GetName(),
+			strings.ToLower(mf.GetType().String()), strings.Join(labels, ","), mf.GetHelp())
+	}
+	return tw.Flush()
+}
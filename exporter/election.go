@@ -0,0 +1,109 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Elector elects one of the replicas of the exporter as the leader, e.g.
+// discovery.KubernetesLease.  Only the leader polls the NATS servers; the
+// others serve the metrics of the exporter alone, so that the replicas
+// neither duplicate the series nor the load on the monitor ports.
+type Elector interface {
+	// Campaign takes part in the election until done is closed, then
+	// steps down.
+	Campaign(done <-chan struct{})
+
+	// Leader reports whether the replica is the leader.
+	Leader() bool
+}
+
+// election runs the campaign of the exporter.
+type election struct {
+	elector Elector
+	quit    chan struct{}
+	wg      sync.WaitGroup
+	desc    *prometheus.Desc
+}
+
+// SetElector is an exporter API to run the exporter as one of several
+// replicas, of which only the leader elected polls the servers.
+func (ne *NATSExporter) SetElector(e Elector) error {
+	ne.Lock()
+	defer ne.Unlock()
+
+	if ne.running {
+		return fmt.Errorf("the elector cannot be set after the exporter is started")
+	}
+	ne.election = &election{
+		elector: e,
+		desc: prometheus.NewDesc("nats_exporter_leader",
+			"Whether the exporter is the leader of its replicas, polling the servers", nil, nil),
+	}
+	return nil
+}
+
+// leader reports whether the exporter polls the servers, always unless
+// elected.
+// caller must lock
+func (ne *NATSExporter) leader() bool {
+	return ne.election == nil || ne.election.elector.Leader()
+}
+
+// startElection starts campaigning, if elected.
+// caller must lock
+func (ne *NATSExporter) startElection() {
+	if ne.election == nil {
+		return
+	}
+	e := ne.election
+	quit := make(chan struct{})
+	e.quit = quit
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.elector.Campaign(quit)
+	}()
+}
+
+// stopElection ends the campaign, returning a function that waits for the
+// exporter to step down.  Stepping down may call the elector over the
+// network, so the function is called once unlocked.
+// caller must lock
+func (ne *NATSExporter) stopElection() func() {
+	e := ne.election
+	if e == nil || e.quit == nil {
+		return func() {}
+	}
+	quit := e.quit
+	e.quit = nil
+	return func() {
+		close(quit)
+		e.wg.Wait()
+	}
+}
+
+// Describe describes the leader metric.
+func (e *election) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.desc
+}
+
+// Collect sends whether the exporter is the leader.
+func (e *election) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.desc, prometheus.GaugeValue, boolToFloat(e.elector.Leader()))
+}
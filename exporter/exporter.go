@@ -14,13 +14,20 @@
 package exporter
 
 import (
+	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,27 +42,98 @@ import (
 // NATSExporterOptions are options to configure the NATS collector
 type NATSExporterOptions struct {
 	collector.LoggerOptions
+	collector.CollectorOptions
 	ListenAddress        string
 	ListenPort           int
+	ListenSocket         string // Unix domain socket listened on instead of TCP.
+	AdminListenAddress   string // host:port serving the admin endpoints apart from scrapes.
 	ScrapePath           string
 	GetConnz             bool
 	GetVarz              bool
 	GetSubz              bool
 	GetRoutez            bool
 	GetGatewayz          bool
+	GetJsz               bool
+	GetIpqueuesz         bool
+	ShardIndex           int // Shard of the servers polled, out of ShardCount.
+	ShardCount           int // Replicas splitting the servers between them, if more than one.
 	GetReplicatorVarz    bool
 	GetStreamingChannelz bool
 	GetStreamingServerz  bool
+	GetStreamingClientsz bool
+	GetStreamingStorez   bool
 	RetryInterval        time.Duration
 	CertFile             string
 	KeyFile              string
 	CaFile               string
+	TLSVerifyClient      bool   // Require client certificates signed by CaFile.
+	CRLFile              string // Revoked client certificates.
 	NATSServerURL        string
 	NATSServerTag        string
 	HTTPUser             string // User in metrics scrape by prometheus.
 	HTTPPassword         string
+	HTTPUsers            map[string]string // Users and passwords of scrapes, along with HTTPUser.
+	HTTPBearerToken      string            // Token scrapers may send instead.
+	HTTPBearerTokenFile  string            // Holds the token, read on every request.
 	Prefix               string
+	LegacyPrefixNames    bool // With Prefix, also serve the metrics under their default prefix.
 	UseInternalServerID  bool
+	PollInterval         time.Duration            // Poll in the background and serve cached metrics.
+	PollIntervals        map[string]time.Duration // PollInterval of the collectors named after their flags, e.g. jsz.
+	CacheTTL             time.Duration
+	MonitorCertFile      string // Client certificate for the NATS monitor endpoints.
+	MonitorKeyFile       string
+	MonitorCaFile        string
+	MonitorServerName    string
+	MonitorTLSInsecure   bool
+	ProxyURL             string        // HTTP or SOCKS5 proxy to poll the servers through.
+	DiscoveryInterval    time.Duration // Not reloaded.
+	AdminAPI             bool          // Serve the API adding and removing servers.
+	Probe                bool          // Serve /probe, polling the target of each request.
+	Pprof                bool          // Serve the Go profiles at /debug/pprof.
+	DebugRaw             bool          // Serve the last responses of the servers at /debug/raw.
+	AccessLog            bool          // Log every request to the exporter.
+	ReloadFunc           func() error  // Reloads the configuration, e.g. on SIGHUP.
+	ReloadAPI            bool          // Serve POST /-/reload, running ReloadFunc.
+	LogLevelAPI          bool          // Serve /admin/loglevel, changing the log level.
+	StatusAPI            bool          // Serve the last poll of each server at /api/status.
+	MaxRequestsInFlight  int           // Scrapes and probes served at a time, unlimited if zero.
+	RequestsInFlightWait time.Duration // How long scrapes over the limit wait before being rejected.
+	MetricsInclude       string        // Regexp of the names of the metrics served.
+	MetricsExclude       string        // Regexp of the names of the metrics not served.
+	RelabelConfigs       []RelabelConfig
+	MaxSeries            int            // Series served of each metric, the others aggregated, unlimited if zero.
+	SeriesLimits         map[string]int // MaxSeries of the collectors named after their flags, e.g. connz.
+	DisableOpenMetrics   bool           // Always serve the text format.
+	OpenMetricsCreated   bool           // Serve the _created samples of counters in OpenMetrics.
+	PushGatewayURL       string         // Pushgateway the metrics are pushed to, if any.
+	PushGatewayJob       string
+	PushGatewayInstance  string // Defaults to the host name.
+	PushGatewayInterval  time.Duration
+	OTLPEndpoint         string // OTLP/HTTP endpoint of an OpenTelemetry Collector the metrics are pushed to.
+	OTLPInterval         time.Duration
+	OTLPTracesEndpoint   string // OTLP/HTTP endpoint of an OpenTelemetry Collector the traces of the scrapes are exported to.
+	StatsdAddress        string // host:port of the StatsD server the metrics are sent to over UDP.
+	StatsdPrefix         string
+	StatsdTags           bool // Send the labels as DogStatsD tags rather than in the names.
+	StatsdInterval       time.Duration
+	GraphiteAddress      string // host:port of the carbon plaintext endpoint the metrics are sent to.
+	GraphitePrefix       string
+	GraphiteInterval     time.Duration
+	InfluxURL            string // InfluxDB v2 server the metrics are written to.
+	InfluxOrg            string
+	InfluxBucket         string
+	InfluxToken          string
+	InfluxInterval       time.Duration
+	PublishURL           string // NATS server the metrics are published to.
+	PublishSubject       string
+	PublishFormat        string // json or protobuf.
+	PublishInterval      time.Duration
+	SysURL               string // NATS server whose system account the servers are requested their metrics on.
+	SysCredsFile         string // Credentials of the system account user.
+	GetSysEvents         bool   // Count the advisories published on the system account.
+	Version              string // Version and commit of the exporter, shown on the landing page.
+	Commit               string
 }
 
 //NATSExporter collects NATS metrics
@@ -64,9 +142,34 @@ type NATSExporter struct {
 	opts       *NATSExporterOptions
 	doneWg     sync.WaitGroup
 	http       net.Listener
+	srv        *http.Server
+	admin      net.Listener // Listener of the admin endpoints, if apart.
+	adminSrv   *http.Server
+	registry   *prometheus.Registry
 	collectors []prometheus.Collector
+	endpoints  map[string]prometheus.Collector // Collectors by endpoint key.
 	servers    []*collector.CollectedServer
 	running    bool
+	pollQuit   chan struct{}
+	pushQuit   chan struct{}
+	traces     chan []*collector.Span // Traces waiting to be exported.
+	tracesQuit chan struct{}
+
+	created      *createdTracker // Creation times of the counters served.
+	otlpStarts   *createdTracker // Start times of the cumulative metrics pushed.
+	statsdDeltas *counterDeltas  // Counter values last sent to StatsD.
+	publisher    publisher
+	sys          sysConn
+	polls        pollOutcomes
+	raw          rawResponses // Last responses of the servers, served at /debug/raw.
+	statuses     pollStatuses // Last polls of the servers, served at /api/status.
+
+	election      *election // Elects the replica polling the servers, if set.
+	discoveries   []*discovery
+	discoveryQuit chan struct{}
+	targets       []*collector.CollectedServer // Added through the admin API.
+	filter        *metricFilter
+	relabelRules  []*relabelRule
 }
 
 // Defaults
@@ -77,10 +180,25 @@ var (
 	DefaultMonitorURL        = "http://localhost:8222"
 	DefaultRetryIntervalSecs = 30
 
-	// bcryptPrefix from gnatsd
-	bcryptPrefix = "$2a$"
+	// DefaultShutdownTimeout bounds waiting for the scrapes in flight
+	// when the exporter is shut down.
+	DefaultShutdownTimeout = 10 * time.Second
+
+	// bcryptPrefixes from gnatsd and htpasswd
+	bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+	// scrapeTimeoutOffset is subtracted from the scrape timeout sent by
+	// Prometheus to leave time to write the response.
+	scrapeTimeoutOffset = 500 * time.Millisecond
 )
 
+// scrapeTimeoutHeader carries the Prometheus scrape timeout in seconds.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// authRealm is the realm of the challenges sent with the responses to
+// unauthenticated requests.
+const authRealm = `"NATS Prometheus Exporter"`
+
 // GetDefaultExporterOptions returns the default set of exporter options
 // The NATS server url must be set
 func GetDefaultExporterOptions() *NATSExporterOptions {
@@ -89,6 +207,21 @@ func GetDefaultExporterOptions() *NATSExporterOptions {
 		ListenPort:    DefaultListenPort,
 		ScrapePath:    DefaultScrapePath,
 		RetryInterval: time.Duration(DefaultRetryIntervalSecs) * time.Second,
+		CollectorOptions: collector.CollectorOptions{
+			MaxConcurrentRequests: collector.DefaultMaxConcurrentRequests,
+			CollectTimeout:        collector.DefaultCollectTimeout,
+			Retry: collector.RetryPolicy{
+				Backoff:    collector.DefaultRetryBackoff,
+				MaxBackoff: collector.DefaultRetryMaxBackoff,
+				Jitter:     collector.DefaultRetryJitter,
+			},
+			BreakerCooldown:     collector.DefaultBreakerCooldown,
+			MaxResponseBytes:    collector.DefaultMaxResponseBytes,
+			ConnectTimeout:      collector.DefaultConnectTimeout,
+			TLSHandshakeTimeout: collector.DefaultTLSHandshakeTimeout,
+			MaxIdleConnsPerHost: collector.DefaultMaxIdleConnsPerHost,
+			IdleConnTimeout:     collector.DefaultIdleConnTimeout,
+		},
 	}
 	return opts
 }
@@ -102,8 +235,12 @@ func NewExporter(opts *NATSExporterOptions) *NATSExporter {
 	}
 	collector.ConfigureLogger(&o.LoggerOptions)
 	ne := &NATSExporter{
-		opts: o,
-		http: nil,
+		opts:         o,
+		http:         nil,
+		registry:     prometheus.NewRegistry(),
+		created:      newCreatedTracker(),
+		otlpStarts:   newCreatedTracker(),
+		statsdDeltas: newCounterDeltas(),
 	}
 	if o.NATSServerURL != "" {
 		_ = ne.AddServer(o.NATSServerTag, o.NATSServerURL) // nolint
@@ -112,14 +249,53 @@ func NewExporter(opts *NATSExporterOptions) *NATSExporter {
 }
 
 func (ne *NATSExporter) createCollector(system, endpoint string) {
-	ne.registerCollector(system, endpoint,
-		collector.NewCollector(system, endpoint,
+	// Each collector has its own copy of the options, which may be
+	// reloaded while it is collecting.
+	copts := ne.opts.CollectorOptions
+	copts.InternalServerIDLabel = ne.opts.UseInternalServerID
+	onPoll := copts.OnPoll
+	copts.OnPoll = func(serverID string, err error) {
+		ne.polls.record(serverID, err)
+		if onPoll != nil {
+			onPoll(serverID, err)
+		}
+	}
+	onPollStatus := copts.OnPollStatus
+	copts.OnPollStatus = func(status collector.PollStatus) {
+		ne.statuses.record(status)
+		if onPollStatus != nil {
+			onPollStatus(status)
+		}
+	}
+	if ne.opts.DebugRaw {
+		onResponse := copts.OnResponse
+		copts.OnResponse = func(serverID, endpoint string, body []byte) {
+			ne.raw.record(serverID, endpoint, body)
+			if onResponse != nil {
+				onResponse(serverID, endpoint, body)
+			}
+		}
+	}
+	var nc prometheus.Collector
+	if system == collector.CoreSystem && endpoint == "events" {
+		nc = collector.NewEventsCollector(ne.opts.Prefix, ne.sys.conn, &copts)
+	} else if ne.opts.SysURL != "" && system == collector.CoreSystem && collector.IsSysEndpoint(endpoint) {
+		nc = collector.NewSysCollector(endpoint, ne.opts.Prefix, ne.sys.conn, &copts)
+	} else {
+		nc = collector.NewCollectorWithOptions(system, endpoint,
 			ne.opts.Prefix,
-			ne.servers))
+			ne.endpointServers(system, endpoint),
+			&copts)
+	}
+	if ne.opts.PollInterval > 0 {
+		interval := ne.pollInterval(system, endpoint)
+		nc = newCachedCollector(nc, interval, ne.cacheTTL(interval))
+	}
+	ne.registerCollector(system, endpoint, nc)
 }
 
 func (ne *NATSExporter) registerCollector(system, endpoint string, nc prometheus.Collector) {
-	if err := prometheus.Register(nc); err != nil {
+	if err := ne.registry.Register(nc); err != nil {
 		if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
 			collector.Errorf("A collector for this server's metrics has already been registered.")
 		} else {
@@ -134,6 +310,10 @@ func (ne *NATSExporter) registerCollector(system, endpoint string, nc prometheus
 	} else {
 		collector.Debugf("Registered collector for system %s, endpoint: %s", system, endpoint)
 		ne.collectors = append(ne.collectors, nc)
+		if ne.endpoints == nil {
+			ne.endpoints = make(map[string]prometheus.Collector)
+		}
+		ne.endpoints[collectorEndpoint{system, endpoint}.key()] = nc
 	}
 }
 
@@ -141,13 +321,25 @@ func (ne *NATSExporter) registerCollector(system, endpoint string, nc prometheus
 // through the options.  Adding more than one server will
 // violate Prometheus.io guidelines.
 func (ne *NATSExporter) AddServer(id, url string) error {
+	return ne.AddServerWithHeaders(id, url, nil)
+}
+
+// AddServerWithHeaders is an exporter API to add a server to collect
+// from, sending the given headers with every request to it, e.g. to
+// scrape it through a multi-tenant proxy.
+func (ne *NATSExporter) AddServerWithHeaders(id, url string, headers http.Header) error {
+	return ne.AddCollectedServer(&collector.CollectedServer{ID: id, URL: url, Headers: headers})
+}
+
+// AddCollectedServer is an exporter API to add a server to collect from,
+// with all of its settings, e.g. the cluster it belongs to.
+func (ne *NATSExporter) AddCollectedServer(cs *collector.CollectedServer) error {
 	ne.Lock()
 	defer ne.Unlock()
 
 	if ne.running {
 		return fmt.Errorf("servers cannot be added after the exporter is started")
 	}
-	cs := &collector.CollectedServer{ID: id, URL: url}
 	if ne.servers == nil {
 		ne.servers = make([]*collector.CollectedServer, 0)
 	}
@@ -155,57 +347,331 @@ func (ne *NATSExporter) AddServer(id, url string) error {
 	return nil
 }
 
-// initializeCollectors initializes the collectors for the exporter.
-// Caller must lock
-func (ne *NATSExporter) initializeCollectors() error {
-	opts := ne.opts
+// collectorEndpoint is a monitoring endpoint of a NATS system, polled by
+// a collector.
+type collectorEndpoint struct {
+	system   string
+	endpoint string
+}
 
-	if len(ne.servers) == 0 {
-		return fmt.Errorf("no servers configured to obtain metrics")
-	}
+// key identifies the collector of the endpoint.
+func (e collectorEndpoint) key() string {
+	return e.system + "/" + e.endpoint
+}
 
-	if !opts.GetConnz && !opts.GetRoutez && !opts.GetSubz && !opts.GetVarz &&
-		!opts.GetGatewayz && !opts.GetStreamingChannelz &&
-		!opts.GetStreamingServerz && !opts.GetReplicatorVarz {
-		return fmt.Errorf("no collectors specfied")
-	}
-	if opts.GetReplicatorVarz && opts.GetVarz {
-		return fmt.Errorf("replicatorVarz cannot be used with varz")
-	}
+// selectedEndpoints returns the endpoints selected by the options, in the
+// order their collectors are created.
+func selectedEndpoints(opts *NATSExporterOptions) []collectorEndpoint {
+	var endpoints []collectorEndpoint
 	if opts.GetSubz {
-		ne.createCollector(collector.CoreSystem, "subsz")
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "subsz"})
 	}
 	if opts.GetVarz {
-		ne.createCollector(collector.CoreSystem, "varz")
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "varz"})
 	}
 	if opts.GetConnz {
-		ne.createCollector(collector.CoreSystem, "connz")
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "connz"})
 	}
 	if opts.GetGatewayz {
-		ne.createCollector(collector.CoreSystem, "gatewayz")
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "gatewayz"})
 	}
 	if opts.GetRoutez {
-		ne.createCollector(collector.CoreSystem, "routez")
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "routez"})
+	}
+	if opts.GetJsz {
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "jsz"})
+	}
+	if opts.GetIpqueuesz {
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "ipqueuesz"})
 	}
 	if opts.GetStreamingChannelz {
-		ne.createCollector(collector.StreamingSystem, "channelsz")
+		endpoints = append(endpoints, collectorEndpoint{collector.StreamingSystem, "channelsz"})
 	}
 	if opts.GetStreamingServerz {
-		ne.createCollector(collector.StreamingSystem, "serverz")
+		endpoints = append(endpoints, collectorEndpoint{collector.StreamingSystem, "serverz"})
+	}
+	if opts.GetStreamingClientsz {
+		endpoints = append(endpoints, collectorEndpoint{collector.StreamingSystem, "clientsz"})
+	}
+	if opts.GetStreamingStorez {
+		endpoints = append(endpoints, collectorEndpoint{collector.StreamingSystem, "storez"})
 	}
 	if opts.GetReplicatorVarz {
-		ne.createCollector(collector.ReplicatorSystem, "varz")
+		endpoints = append(endpoints, collectorEndpoint{collector.ReplicatorSystem, "varz"})
+	}
+	if opts.SysURL != "" {
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "statsz"})
+	}
+	if opts.GetSysEvents {
+		endpoints = append(endpoints, collectorEndpoint{collector.CoreSystem, "events"})
+	}
+	return endpoints
+}
+
+// SetCollectors enables the collectors in the comma-separated list,
+// named after their flags.
+func SetCollectors(opts *NATSExporterOptions, list string) error {
+	collectors := map[string]*bool{
+		"varz":           &opts.GetVarz,
+		"connz":          &opts.GetConnz,
+		"subz":           &opts.GetSubz,
+		"subsz":          &opts.GetSubz,
+		"routez":         &opts.GetRoutez,
+		"gatewayz":       &opts.GetGatewayz,
+		"jsz":            &opts.GetJsz,
+		"ipqueuesz":      &opts.GetIpqueuesz,
+		"replicatorVarz": &opts.GetReplicatorVarz,
+		"channelz":       &opts.GetStreamingChannelz,
+		"serverz":        &opts.GetStreamingServerz,
+		"clientz":        &opts.GetStreamingClientsz,
+		"storez":         &opts.GetStreamingStorez,
+		"sys_events":     &opts.GetSysEvents,
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		get, ok := collectors[name]
+		if !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+		*get = true
 	}
 	return nil
 }
 
+// checkCollectorOptions checks the options select a valid set of
+// collectors for the servers.
+func checkCollectorOptions(opts *NATSExporterOptions, servers []*collector.CollectedServer) error {
+	if !opts.GetConnz && !opts.GetRoutez && !opts.GetSubz && !opts.GetVarz &&
+		!opts.GetGatewayz && !opts.GetJsz && !opts.GetIpqueuesz && !opts.GetStreamingChannelz && !opts.GetStreamingServerz &&
+		!opts.GetStreamingClientsz && !opts.GetStreamingStorez && !opts.GetReplicatorVarz &&
+		opts.SysURL == "" {
+		return fmt.Errorf("no collectors specfied")
+	}
+	if opts.GetReplicatorVarz && opts.GetVarz {
+		return fmt.Errorf("replicatorVarz cannot be used with varz")
+	}
+	if opts.GetSysEvents && opts.SysURL == "" {
+		return fmt.Errorf("sys_events requires sys_url")
+	}
+	if err := checkShard(opts.ShardIndex, opts.ShardCount); err != nil {
+		return err
+	}
+	if err := checkSeriesLimits(opts); err != nil {
+		return err
+	}
+	if err := checkLegacyPrefixNames(opts); err != nil {
+		return err
+	}
+	if err := collector.CheckSubjectAggregations(opts.SubjectAggregations); err != nil {
+		return err
+	}
+	if err := checkPollIntervals(opts); err != nil {
+		return err
+	}
+	for endpoint := range opts.EndpointParams {
+		switch endpoint {
+		case "varz", "connz", "subsz", "routez", "gatewayz", "ipqueuesz":
+		default:
+			return fmt.Errorf("query parameters cannot be set for endpoint %q", endpoint)
+		}
+	}
+	switch opts.ServerNameLabel {
+	case "", collector.ServerNameLabelAdd, collector.ServerNameLabelReplace:
+	default:
+		return fmt.Errorf("invalid server name label %q", opts.ServerNameLabel)
+	}
+	if opts.UseServerURLLabel &&
+		(opts.UseInternalServerID || opts.ServerNameLabel == collector.ServerNameLabelReplace) {
+		return fmt.Errorf("the server URL label cannot be used with another server id")
+	}
+	if opts.LegacyMetricNames && !opts.UnitMetricNames {
+		return fmt.Errorf("legacy metric names are only served alongside unit metric names")
+	}
+	for _, s := range servers {
+		if err := collector.CheckServerLabels(s.Labels); err != nil {
+			return fmt.Errorf("server %s: %v", s.ID, err)
+		}
+	}
+	return nil
+}
+
+// checkAdminAuth checks the endpoints acting on the exporter, or exposing
+// what it polls, are only served to authenticated requests.
+func checkAdminAuth(opts *NATSExporterOptions) error {
+	if basicAuthEnabled(opts) || bearerAuthEnabled(opts) {
+		return nil
+	}
+	switch {
+	case opts.AdminAPI:
+		return fmt.Errorf("the admin API requires an http user or bearer token")
+	case opts.DebugRaw:
+		return fmt.Errorf("debug_raw requires an http user or bearer token")
+	case opts.ReloadAPI:
+		return fmt.Errorf("reload_api requires an http user or bearer token")
+	case opts.LogLevelAPI:
+		return fmt.Errorf("log_level_api requires an http user or bearer token")
+	case opts.StatusAPI:
+		return fmt.Errorf("status_api requires an http user or bearer token")
+	case opts.Probe:
+		return fmt.Errorf("probe requires an http user or bearer token")
+	case opts.Pprof:
+		return fmt.Errorf("pprof requires an http user or bearer token")
+	}
+	return nil
+}
+
+// CheckOptions validates the options without polling any server,
+// reporting settings that are invalid or have no effect without another.
+func CheckOptions(opts *NATSExporterOptions) error {
+	if err := checkCollectorOptions(opts, nil); err != nil {
+		return err
+	}
+	if opts.CertFile != "" && opts.KeyFile == "" {
+		return fmt.Errorf("tlscert requires tlskey")
+	}
+	if opts.KeyFile != "" && opts.CertFile == "" {
+		return fmt.Errorf("tlskey requires tlscert")
+	}
+	if opts.CaFile != "" && opts.CertFile == "" {
+		return fmt.Errorf("tlscacert requires tlscert")
+	}
+	if opts.TLSVerifyClient && opts.CaFile == "" {
+		return fmt.Errorf("tlsverify requires tlscacert")
+	}
+	if opts.CRLFile != "" && !opts.TLSVerifyClient {
+		return fmt.Errorf("tlscrl requires tlsverify")
+	}
+	if opts.MonitorCertFile != "" && opts.MonitorKeyFile == "" {
+		return fmt.Errorf("monitor_tlscert requires monitor_tlskey")
+	}
+	if opts.MonitorKeyFile != "" && opts.MonitorCertFile == "" {
+		return fmt.Errorf("monitor_tlskey requires monitor_tlscert")
+	}
+	if opts.HTTPPassword != "" && opts.HTTPUser == "" {
+		return fmt.Errorf("http_pass requires http_user")
+	}
+	if opts.HTTPBearerToken != "" && opts.HTTPBearerTokenFile != "" {
+		return fmt.Errorf("http_bearer_token cannot be used with http_bearer_token_file")
+	}
+	if err := checkAdminAuth(opts); err != nil {
+		return err
+	}
+	if opts.SysCredsFile != "" && opts.SysURL == "" {
+		return fmt.Errorf("sys_creds requires sys_url")
+	}
+	if opts.ProxyURL != "" {
+		if _, err := url.Parse(opts.ProxyURL); err != nil {
+			return fmt.Errorf("invalid proxy url %q: %v", opts.ProxyURL, err)
+		}
+	}
+	if _, err := newMetricFilter(opts.MetricsInclude, opts.MetricsExclude); err != nil {
+		return err
+	}
+	_, err := compileRelabelConfigs(opts.RelabelConfigs)
+	return err
+}
+
+// initializeCollectors initializes the collectors for the exporter.
+// Caller must lock
+func (ne *NATSExporter) initializeCollectors() error {
+	if err := ne.prepareCollectors(); err != nil {
+		return err
+	}
+	ne.createCollectors()
+	return nil
+}
+
+// prepareCollectors checks the collectors can be created for the servers
+// and options, and sets up the HTTP client they share.
+// caller must lock
+func (ne *NATSExporter) prepareCollectors() error {
+	if len(ne.knownServers()) == 0 && len(ne.discoveries) == 0 && !ne.opts.AdminAPI && !ne.opts.Probe &&
+		ne.opts.SysURL == "" {
+		return fmt.Errorf("no servers configured to obtain metrics")
+	}
+	if err := checkCollectorOptions(ne.opts, ne.allServers()); err != nil {
+		return err
+	}
+	return ne.setupHTTPClient()
+}
+
+// createCollectors creates the collectors of the endpoints selected.
+// caller must lock
+func (ne *NATSExporter) createCollectors() {
+	for _, e := range ne.collectedEndpoints() {
+		ne.createCollector(e.system, e.endpoint)
+	}
+}
+
+// setupHTTPClient creates the HTTP client shared by the collectors, and
+// its connection pool, unless one was provided.
+// caller must lock
+func (ne *NATSExporter) setupHTTPClient() error {
+	opts := ne.opts
+	if opts.HTTPClient != nil {
+		return nil
+	}
+	if opts.TLSConfig == nil {
+		config, err := ne.generateMonitorTLSConfig()
+		if err != nil {
+			return err
+		}
+		opts.TLSConfig = config
+	}
+	if opts.Proxy == nil && opts.ProxyURL != "" {
+		proxy, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url %q: %v", opts.ProxyURL, err)
+		}
+		opts.Proxy = proxy
+	}
+	opts.HTTPClient = collector.NewHTTPClient(&opts.CollectorOptions)
+	return nil
+}
+
+// GetServerIDFromVarz gets the server ID from the /varz endpoint of the
+// server at url, polled as the collectors would, retrying until it is
+// available or ctx is done.
+func (ne *NATSExporter) GetServerIDFromVarz(ctx context.Context, url string) (string, error) {
+	ne.Lock()
+	if err := ne.setupHTTPClient(); err != nil {
+		ne.Unlock()
+		return "", err
+	}
+	opts := ne.opts.CollectorOptions
+	ne.Unlock()
+
+	// Retry until the server is available, backing off up to the retry
+	// interval.
+	opts.Retry.MaxAttempts = -1
+	opts.Retry.MaxBackoff = ne.opts.RetryInterval
+	return collector.GetServerIDFromVarzWithOptions(ctx, url, &opts)
+}
+
+// PollOptions returns a copy of the options the servers are polled with,
+// e.g. for a Discoverer to poll them the same way.
+func (ne *NATSExporter) PollOptions() (*collector.CollectorOptions, error) {
+	ne.Lock()
+	defer ne.Unlock()
+	if err := ne.setupHTTPClient(); err != nil {
+		return nil, err
+	}
+	opts := ne.opts.CollectorOptions
+	return &opts, nil
+}
+
 // caller must lock
 func (ne *NATSExporter) clearCollectors() {
 	if ne.collectors != nil {
 		for _, c := range ne.collectors {
-			prometheus.Unregister(c)
+			ne.registry.Unregister(c)
+			closeCollector(c)
 		}
 		ne.collectors = nil
+		ne.endpoints = nil
 	}
 }
 
@@ -217,18 +683,72 @@ func (ne *NATSExporter) Start() error {
 		return nil
 	}
 
+	if err := checkAdminAuth(ne.opts); err != nil {
+		return err
+	}
+	filter, err := newMetricFilter(ne.opts.MetricsInclude, ne.opts.MetricsExclude)
+	if err != nil {
+		return err
+	}
+	rules, err := compileRelabelConfigs(ne.opts.RelabelConfigs)
+	if err != nil {
+		return err
+	}
+	if ne.opts.OTLPEndpoint != "" {
+		if _, err := otlpMetricsURL(ne.opts.OTLPEndpoint); err != nil {
+			return err
+		}
+	}
+	if ne.opts.OTLPTracesEndpoint != "" {
+		if _, err := otlpTracesURL(ne.opts.OTLPTracesEndpoint); err != nil {
+			return err
+		}
+	}
+	if ne.opts.StatsdAddress != "" {
+		if _, _, err := net.SplitHostPort(ne.opts.StatsdAddress); err != nil {
+			return fmt.Errorf("invalid StatsD address: %v", err)
+		}
+	}
+	if ne.opts.GraphiteAddress != "" {
+		if _, _, err := net.SplitHostPort(ne.opts.GraphiteAddress); err != nil {
+			return fmt.Errorf("invalid Graphite address: %v", err)
+		}
+	}
+	if ne.opts.InfluxURL != "" {
+		if _, err := influxWriteURL(ne.opts.InfluxURL, ne.opts.InfluxOrg, ne.opts.InfluxBucket); err != nil {
+			return err
+		}
+	}
+	if err := checkPublishFormat(ne.opts.PublishFormat); err != nil {
+		return err
+	}
+	ne.filter, ne.relabelRules = filter, rules
+	ne.sys.url, ne.sys.credsFile = ne.opts.SysURL, ne.opts.SysCredsFile
+	if ne.opts.OTLPTracesEndpoint != "" {
+		ne.startTracing()
+	}
 	if err := ne.initializeCollectors(); err != nil {
+		ne.stopTracing()
 		ne.clearCollectors()
 		return err
 	}
 
 	if err := ne.startHTTP(); err != nil {
+		ne.stopTracing()
 		ne.clearCollectors()
 		return fmt.Errorf("error serving http:  %v", err)
 	}
 
+	ne.startElection()
+	if ne.opts.PollInterval > 0 {
+		ne.startPolling()
+	}
+	ne.startPushing()
+	ne.startDiscovery()
+
 	ne.doneWg.Add(1)
 	ne.running = true
+	sdNotify("READY=1")
 
 	return nil
 }
@@ -265,60 +785,296 @@ func (ne *NATSExporter) generateTLSConfig() (*tls.Config, error) {
 		}
 		config.ClientCAs = pool
 	}
+	if ne.opts.TLSVerifyClient {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if ne.opts.CRLFile != "" {
+		revoked, err := loadRevokedCerts(ne.opts.CRLFile)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				for _, cert := range chain {
+					if revoked[revokedCertKey(cert.RawIssuer, cert.SerialNumber)] {
+						return fmt.Errorf("certificate %s has been revoked", cert.SerialNumber)
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return config, nil
+}
+
+// loadRevokedCerts loads the certificates revoked by a PEM or DER encoded
+// certificate revocation list, keyed by revokedCertKey.
+func loadRevokedCerts(path string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate revocation list (%s): %v", path, err)
+	}
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate revocation list (%s): %v", path, err)
+	}
+	issuer, err := asn1.Marshal(crl.TBSCertList.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer of certificate revocation list (%s): %v", path, err)
+	}
+	revoked := make(map[string]bool)
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		revoked[revokedCertKey(issuer, rc.SerialNumber)] = true
+	}
+	return revoked, nil
+}
+
+// revokedCertKey identifies a certificate by the DER encoding of its
+// issuer and its serial number.
+func revokedCertKey(issuer []byte, serial *big.Int) string {
+	return string(issuer) + "/" + serial.String()
+}
+
+// generates the TLS config used to poll the NATS monitor endpoints, or nil
+// if none is configured.
+func (ne *NATSExporter) generateMonitorTLSConfig() (*tls.Config, error) {
+	opts := ne.opts
+	if opts.MonitorCertFile == "" && opts.MonitorKeyFile == "" && opts.MonitorCaFile == "" &&
+		opts.MonitorServerName == "" && !opts.MonitorTLSInsecure {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         opts.MonitorServerName,
+		InsecureSkipVerify: opts.MonitorTLSInsecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if opts.MonitorCertFile != "" || opts.MonitorKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.MonitorCertFile, opts.MonitorKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing monitor X509 certificate/key pair (%s, %s): %v",
+				opts.MonitorCertFile, opts.MonitorKeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if opts.MonitorCaFile != "" {
+		rootPEM, err := ioutil.ReadFile(opts.MonitorCaFile)
+		if err != nil || rootPEM == nil {
+			return nil, fmt.Errorf("failed to load monitor root ca certificate (%s): %v", opts.MonitorCaFile, err)
+		}
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(rootPEM); !ok {
+			return nil, fmt.Errorf("failed to parse monitor root ca certificate")
+		}
+		config.RootCAs = pool
+	}
 	return config, nil
 }
 
 // isBcrypt checks whether the given password or token is bcrypted.
 func isBcrypt(password string) bool {
-	return strings.HasPrefix(password, bcryptPrefix)
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// basicAuthEnabled reports whether scrapes are authenticated by a user and
+// password.
+func basicAuthEnabled(opts *NATSExporterOptions) bool {
+	return opts.HTTPUser != "" || len(opts.HTTPUsers) > 0
+}
+
+// bearerAuthEnabled reports whether scrapes are authenticated by a bearer
+// token.
+func bearerAuthEnabled(opts *NATSExporterOptions) bool {
+	return opts.HTTPBearerToken != "" || opts.HTTPBearerTokenFile != ""
+}
+
+// isValidBearerToken checks the token sent by a scraper, reading the
+// token file on every request so that the token can be rotated.
+func (ne *NATSExporter) isValidBearerToken(token string) bool {
+	expected := ne.opts.HTTPBearerToken
+	if ne.opts.HTTPBearerTokenFile != "" {
+		b, err := ioutil.ReadFile(ne.opts.HTTPBearerTokenFile)
+		if err != nil {
+			collector.Errorf("Unable to read the bearer token: %v", err)
+			return false
+		}
+		expected = strings.TrimSpace(string(b))
+	}
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
 }
 
 func (ne *NATSExporter) isValidUserPass(user, password string) bool {
-	if user != ne.opts.HTTPUser {
+	exporterPassword, ok := ne.opts.HTTPUsers[user]
+	if ne.opts.HTTPUser != "" && user == ne.opts.HTTPUser {
+		exporterPassword, ok = ne.opts.HTTPPassword, true
+	}
+	if !ok {
 		return false
 	}
-	exporterPassword := ne.opts.HTTPPassword
 	if isBcrypt(exporterPassword) {
-		if err := bcrypt.CompareHashAndPassword([]byte(exporterPassword), []byte(password)); err != nil {
-			return false
+		return bcrypt.CompareHashAndPassword([]byte(exporterPassword), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(exporterPassword), []byte(password)) == 1
+}
+
+// scrapeCollector binds a collector to the context of a single scrape.
+type scrapeCollector struct {
+	collector.ContextCollector
+	ctx context.Context
+}
+
+// Collect collects the metrics of the underlying collector, bounded by
+// the scrape's context.
+func (sc *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.CollectWithContext(sc.ctx, ch)
+}
+
+// scrapeContext returns the context bounding the collection for a scrape
+// request, honoring the Prometheus scrape timeout when one is sent.
+func (ne *NATSExporter) scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ne.Lock()
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+	if v := r.Header.Get(scrapeTimeoutHeader); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			timeout = time.Duration(secs * float64(time.Second))
+			if timeout > scrapeTimeoutOffset {
+				timeout -= scrapeTimeoutOffset
+			}
 		}
-	} else if exporterPassword != password {
-		return false
 	}
-	return true
+	if timeout > 0 {
+		return context.WithTimeout(r.Context(), timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// scrapeGatherer returns a gatherer for a single scrape, collecting
+// the NATS metrics bounded by ctx, unless another replica is the leader,
+// along with the default Go and process metrics.
+func (ne *NATSExporter) scrapeGatherer(ctx context.Context) prometheus.Gatherer {
+	ne.Lock()
+	var collectors []prometheus.Collector
+	if ne.leader() {
+		collectors = make([]prometheus.Collector, len(ne.collectors))
+		copy(collectors, ne.collectors)
+	}
+	if ne.election != nil {
+		collectors = append(collectors, ne.election)
+	}
+	filter, rules := ne.filter, ne.relabelRules
+	maxSeries, limits := ne.opts.MaxSeries, ne.seriesLimits()
+	prefix, systems := ne.opts.Prefix, ne.legacyPrefixSystems()
+	ne.Unlock()
+
+	// The collectors with their own series limit, or whose metrics are
+	// also served under their default prefix, are gathered apart.
+	reg := prometheus.NewRegistry()
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, &limitedGatherer{Gatherer: reg, limit: maxSeries}}
+	for _, c := range collectors {
+		r := reg
+		limit, limited := limits[c]
+		system, legacy := systems[c]
+		if limited || legacy {
+			r = prometheus.NewRegistry()
+			if !limited {
+				limit = maxSeries
+			}
+			var g prometheus.Gatherer = &limitedGatherer{Gatherer: r, limit: limit}
+			if legacy {
+				g = &legacyPrefixGatherer{Gatherer: g, prefix: prefix, system: system}
+			}
+			gatherers = append(gatherers, g)
+		}
+		if cc, ok := c.(collector.ContextCollector); ok {
+			c = &scrapeCollector{ContextCollector: cc, ctx: ctx}
+		}
+		if err := r.Register(c); err != nil {
+			collector.Debugf("Unable to register collector for scrape: %v", err)
+		}
+	}
+	return filterGatherer(gatherers, filter, rules)
+}
+
+// filterGatherer returns g, filtering and relabeling the metrics gathered
+// if configured to.
+func filterGatherer(g prometheus.Gatherer, filter *metricFilter, rules []*relabelRule) prometheus.Gatherer {
+	if filter != nil {
+		g = &filteredGatherer{Gatherer: g, filter: filter}
+	}
+	if len(rules) > 0 {
+		g = &relabelGatherer{Gatherer: g, rules: rules}
+	}
+	return g
+}
+
+// serveGatherer serves the metrics gathered, in the format negotiated
+// with the scraper.
+func (ne *NATSExporter) serveGatherer(rw http.ResponseWriter, r *http.Request, g prometheus.Gatherer) {
+	if !ne.opts.DisableOpenMetrics && acceptsOpenMetrics(r) {
+		ne.serveOpenMetrics(rw, r, g)
+		return
+	}
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(rw, r)
 }
 
 // getScrapeHandler returns the default handler if no nttp
 // auhtorization has been specificed.  Otherwise, it checks
-// basic authorization.
+// basic authorization or the bearer token.
 func (ne *NATSExporter) getScrapeHandler() http.Handler {
-	h := promhttp.Handler()
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := ne.scrapeContext(r)
+		defer cancel()
+		ctx, span := ne.startTrace(ctx, "scrape")
+		defer span.Finish(nil)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		ne.serveGatherer(rw, r, ne.scrapeGatherer(ctx))
+	})
 
-	if ne.opts.HTTPUser != "" {
-		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
-			if len(auth) != 2 || auth[0] != "Basic" {
-				http.Error(rw, "authorization failed", http.StatusUnauthorized)
-				return
-			}
+	return ne.withAuth(h)
+}
 
+// withAuth returns h, checking the basic authorization or bearer token
+// of requests first if either has been specified.
+func (ne *NATSExporter) withAuth(h http.Handler) http.Handler {
+	basic, bearer := basicAuthEnabled(ne.opts), bearerAuthEnabled(ne.opts)
+	if !basic && !bearer {
+		return h
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		authorized := false
+		auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		switch {
+		case len(auth) != 2:
+		case basic && auth[0] == "Basic":
 			payload, err := base64.StdEncoding.DecodeString(auth[1])
 			if err != nil {
 				http.Error(rw, "authorization failed", http.StatusBadRequest)
 				return
 			}
 			pair := strings.SplitN(string(payload), ":", 2)
+			authorized = len(pair) == 2 && ne.isValidUserPass(pair[0], pair[1])
+		case bearer && auth[0] == "Bearer":
+			authorized = ne.isValidBearerToken(auth[1])
+		}
 
-			if len(pair) != 2 || !ne.isValidUserPass(pair[0], pair[1]) {
-				http.Error(rw, "authorization failed", http.StatusUnauthorized)
-				return
+		if !authorized {
+			if basic {
+				rw.Header().Add("WWW-Authenticate", "Basic realm="+authRealm)
 			}
-
-			h.ServeHTTP(rw, r)
-		})
-	}
-
-	return h
+			if bearer {
+				rw.Header().Add("WWW-Authenticate", "Bearer realm="+authRealm)
+			}
+			http.Error(rw, "authorization failed", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
 }
 
 // startHTTP configures and starts the HTTP server for applications to poll data from
@@ -331,7 +1087,27 @@ func (ne *NATSExporter) startHTTP() error {
 	var proto string
 	var config *tls.Config
 
+	// A socket passed by systemd socket activation is listened on rather
+	// than the address configured.
+	inherited, err := systemdListener()
+	if err != nil {
+		return err
+	}
+
+	network := "tcp"
 	hp = net.JoinHostPort(ne.opts.ListenAddress, strconv.Itoa(ne.opts.ListenPort))
+	if inherited != nil {
+		network, hp = inherited.Addr().Network(), inherited.Addr().String()
+	} else if ne.opts.ListenSocket != "" {
+		network, hp = "unix", ne.opts.ListenSocket
+		// A socket left behind by an exporter that did not stop cleanly
+		// would fail the listen.
+		if fi, err := os.Stat(hp); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(hp); err != nil {
+				return err
+			}
+		}
+	}
 	path = ne.opts.ScrapePath
 
 	if !strings.HasPrefix(path, "/") {
@@ -345,16 +1121,31 @@ func (ne *NATSExporter) startHTTP() error {
 		collector.Debugf("Certificate file specfied; using https.")
 		config, err = ne.generateTLSConfig()
 		if err != nil {
+			if inherited != nil {
+				inherited.Close()
+			}
 			return err
 		}
-		ne.http, err = tls.Listen("tcp", hp, config)
+		if inherited != nil {
+			ne.http = tls.NewListener(inherited, config)
+		} else {
+			ne.http, err = tls.Listen(network, hp, config)
+		}
 	} else {
 		proto = "http"
 		collector.Debugf("No certificate file specified; using http.")
-		ne.http, err = net.Listen("tcp", hp)
+		if inherited != nil {
+			ne.http = inherited
+		} else {
+			ne.http, err = net.Listen(network, hp)
+		}
 	}
 
-	collector.Noticef("Prometheus exporter listening at %s://%s%s", proto, hp, path)
+	if network == "unix" {
+		collector.Noticef("Prometheus exporter listening on unix socket %s, serving %s at %s", hp, proto, path)
+	} else {
+		collector.Noticef("Prometheus exporter listening at %s://%s%s", proto, hp, path)
+	}
 
 	if err != nil {
 		collector.Errorf("can't start HTTP listener: %v", err)
@@ -362,20 +1153,84 @@ func (ne *NATSExporter) startHTTP() error {
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle(path, ne.getScrapeHandler())
+	// The admin endpoints are served on their own listener if one is
+	// configured, so that the scrape port can be exposed more broadly.
+	adminMux := mux
+	if ne.opts.AdminListenAddress != "" {
+		adminMux = http.NewServeMux()
+	}
+	// Scrapes and probes, which poll the servers, share the limit.
+	limiter := newRequestLimiter(ne.opts.MaxRequestsInFlight, ne.opts.RequestsInFlightWait)
+	mux.Handle(path, limiter.limit(ne.getScrapeHandler()))
+	mux.Handle(strings.TrimSuffix(path, "/")+influxSuffix, limiter.limit(ne.getInfluxHandler()))
+	mux.Handle(jsonPath(path), limiter.limit(ne.getJSONHandler()))
+	if path != "/" {
+		mux.Handle("/", ne.withAuth(http.HandlerFunc(ne.handleLanding)))
+	}
+	adminMux.HandleFunc(healthzPath, ne.handleHealthz)
+	adminMux.HandleFunc(readyzPath, ne.handleReadyz)
+	mux.Handle(sdPath, ne.withAuth(http.HandlerFunc(ne.handleSD)))
+	if ne.opts.Probe {
+		mux.Handle(probePath, limiter.limit(ne.withAuth(http.HandlerFunc(ne.handleProbe))))
+	}
+	if ne.opts.AdminAPI {
+		adminMux.Handle(targetsPath, ne.withAuth(http.HandlerFunc(ne.handleTargets)))
+	}
+	if ne.opts.ReloadAPI && ne.opts.ReloadFunc != nil {
+		adminMux.Handle(reloadPath, ne.withAuth(http.HandlerFunc(ne.handleReload)))
+	}
+	if ne.opts.LogLevelAPI {
+		adminMux.Handle(logLevelPath, ne.withAuth(http.HandlerFunc(ne.handleLogLevel)))
+	}
+	if ne.opts.StatusAPI {
+		adminMux.Handle(statusPath, ne.withAuth(http.HandlerFunc(ne.handleStatus)))
+	}
+	if ne.opts.Pprof {
+		adminMux.Handle("/debug/pprof/", ne.withAuth(http.HandlerFunc(pprof.Index)))
+		adminMux.Handle("/debug/pprof/cmdline", ne.withAuth(http.HandlerFunc(pprof.Cmdline)))
+		adminMux.Handle("/debug/pprof/profile", ne.withAuth(http.HandlerFunc(pprof.Profile)))
+		adminMux.Handle("/debug/pprof/symbol", ne.withAuth(http.HandlerFunc(pprof.Symbol)))
+		adminMux.Handle("/debug/pprof/trace", ne.withAuth(http.HandlerFunc(pprof.Trace)))
+	}
+	if ne.opts.DebugRaw {
+		adminMux.Handle(rawPath, ne.withAuth(http.HandlerFunc(ne.handleRaw)))
+	}
+
+	if adminMux != mux {
+		if config != nil {
+			ne.admin, err = tls.Listen("tcp", ne.opts.AdminListenAddress, config)
+		} else {
+			ne.admin, err = net.Listen("tcp", ne.opts.AdminListenAddress)
+		}
+		if err != nil {
+			collector.Errorf("can't start the admin HTTP listener: %v", err)
+			ne.http.Close()
+			return err
+		}
+		collector.Noticef("Serving the admin endpoints at %s://%s", proto, ne.admin.Addr())
+		ne.adminSrv = ne.serveHTTP(ne.admin, adminMux, config)
+	}
+	ne.srv = ne.serveHTTP(ne.http, mux, config)
+
+	return nil
+}
 
+// serveHTTP serves the handler on the listener in the background.
+func (ne *NATSExporter) serveHTTP(l net.Listener, handler http.Handler, config *tls.Config) *http.Server {
+	if ne.opts.AccessLog {
+		handler = withAccessLog(handler)
+	}
 	srv := &http.Server{
-		Addr:           hp,
-		Handler:        mux,
+		Addr:           l.Addr().String(),
+		Handler:        handler,
 		MaxHeaderBytes: 1 << 20,
 		TLSConfig:      config,
 	}
 
-	sHTTP := ne.http
 	go func() {
 		for i := 0; i < 10; i++ {
 			var err error
-			if err = srv.Serve(sHTTP); err != nil {
+			if err = srv.Serve(l); err != nil {
 				// In a test environment, this can fail because the server is already running.
 				collector.Debugf("Unable to start HTTP server (may already be running): %v", err)
 			} else {
@@ -383,8 +1238,7 @@ func (ne *NATSExporter) startHTTP() error {
 			}
 		}
 	}()
-
-	return nil
+	return srv
 }
 
 // WaitUntilDone blocks until the collector is stopped.
@@ -396,20 +1250,61 @@ func (ne *NATSExporter) WaitUntilDone() {
 	wg.Wait()
 }
 
+// Shutdown stops the exporter gracefully: it stops accepting scrapes and
+// waits for those in flight to complete, abandoning them once ctx is
+// done, before stopping the exporter as Stop does.
+func (ne *NATSExporter) Shutdown(ctx context.Context) error {
+	ne.Lock()
+	srv, adminSrv := ne.srv, ne.adminSrv
+	running := ne.running
+	ne.Unlock()
+	if !running {
+		return nil
+	}
+
+	collector.Debugf("Shutting down.")
+	if adminSrv != nil {
+		adminSrv.Close()
+	}
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		srv.Close()
+	}
+	ne.Stop()
+	return err
+}
+
 // Stop stops the collector.
 func (ne *NATSExporter) Stop() {
 	collector.Debugf("Stopping.")
 	ne.Lock()
-	defer ne.Unlock()
-
 	if !ne.running {
+		ne.Unlock()
 		return
 	}
 
 	ne.running = false
+	sdNotify("STOPPING=1")
+	ne.stopPolling()
+	ne.stopPushing()
+	ne.stopTracing()
+	stepDown := ne.stopElection()
+	ne.publisher.close()
+	ne.sys.close()
+	ne.stopDiscovery()
 	if err := ne.http.Close(); err != nil {
 		collector.Debugf("Did not close HTTP: %v", err)
 	}
+	if ne.admin != nil {
+		if err := ne.admin.Close(); err != nil {
+			collector.Debugf("Did not close the admin HTTP: %v", err)
+		}
+		ne.admin, ne.adminSrv = nil, nil
+	}
 	ne.clearCollectors()
+	ne.opts.HTTPClient.CloseIdleConnections()
+	ne.Unlock()
+
+	stepDown()
 	ne.doneWg.Done()
 }
@@ -0,0 +1,77 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricFilter selects the metrics served by their name.
+type metricFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// newMetricFilter returns a filter keeping the metrics whose whole name
+// matches include, if set, and does not match exclude, if set.  It
+// returns nil if neither is set.
+func newMetricFilter(include, exclude string) (*metricFilter, error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+	f := &metricFilter{}
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile("^(?:" + include + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid metrics include filter: %v", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile("^(?:" + exclude + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid metrics exclude filter: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// keep reports whether the metrics with the name are served.
+func (f *metricFilter) keep(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// filteredGatherer gathers the metric families kept by the filter.
+type filteredGatherer struct {
+	prometheus.Gatherer
+	filter *metricFilter
+}
+
+// Gather gathers the metric families of the underlying gatherer, leaving
+// out those filtered.
+func (fg *filteredGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := fg.Gatherer.Gather()
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if fg.filter.keep(mf.GetName()) {
+			kept = append(kept, mf)
+		}
+	}
+	return kept, err
+}
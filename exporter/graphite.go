@@ -0,0 +1,106 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Graphite defaults
+var (
+	DefaultGraphitePrefix   = "nats"
+	DefaultGraphiteInterval = 15 * time.Second
+)
+
+// writeGraphite writes the samples of the metric families in the carbon
+// plaintext format, named after the prefix, the metric and its labels
+// sorted by name, as the Graphite bridge of the Prometheus client does.
+func writeGraphite(out io.Writer, mfs []*dto.MetricFamily, prefix string, now time.Time) error {
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.TimeFromUnixNano(now.UnixNano())}, mfs...)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, s := range samples {
+		parts := make([]string, 0, len(s.Metric))
+		for name, value := range s.Metric {
+			if name != model.MetricNameLabel {
+				parts = append(parts, graphiteSanitize(string(name))+"."+graphiteSanitize(string(value)))
+			}
+		}
+		sort.Strings(parts)
+		parts = append([]string{graphiteSanitize(string(s.Metric[model.MetricNameLabel]))}, parts...)
+		if prefix != "" {
+			parts = append([]string{graphiteSanitize(prefix)}, parts...)
+		}
+		fmt.Fprintf(w, "%s %g %d\n", strings.Join(parts, "."), float64(s.Value), s.Timestamp.Unix())
+	}
+	return w.Flush()
+}
+
+// graphiteSanitize replaces the characters Graphite reserves in a part of
+// a name, collapsing repeated underscores.
+func graphiteSanitize(s string) string {
+	var b strings.Builder
+	prevUnderscore := false
+	for _, r := range s {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == ':' || r == '-') {
+			r = '_'
+		}
+		if r == '_' && prevUnderscore {
+			continue
+		}
+		prevUnderscore = r == '_'
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// pushGraphite sends the metrics a scrape would be served to the carbon
+// plaintext endpoint.
+func (ne *NATSExporter) pushGraphite() error {
+	ne.Lock()
+	addr := ne.opts.GraphiteAddress
+	prefix := ne.opts.GraphitePrefix
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+
+	ctx, cancel := pushContext(timeout)
+	defer cancel()
+	mfs, err := ne.scrapeGatherer(ctx).Gather()
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	return writeGraphite(conn, mfs, prefix, time.Now())
+}
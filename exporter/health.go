@@ -0,0 +1,92 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Paths of the liveness and readiness endpoints of the exporter.
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+)
+
+// pollOutcomes holds the outcome of the last poll of each server.
+type pollOutcomes struct {
+	sync.Mutex
+	failed map[string]bool
+}
+
+// record records the outcome of a poll of the server.
+func (po *pollOutcomes) record(serverID string, err error) {
+	po.Lock()
+	defer po.Unlock()
+	if po.failed == nil {
+		po.failed = make(map[string]bool)
+	}
+	po.failed[serverID] = err != nil
+}
+
+// Ready returns nil if the exporter is ready to serve scrapes: it is
+// running, at least one collector is registered, and unless no server
+// has been polled yet, the last poll of at least one server succeeded.
+func (ne *NATSExporter) Ready() error {
+	ne.Lock()
+	defer ne.Unlock()
+
+	if !ne.running {
+		return fmt.Errorf("the exporter is not running")
+	}
+	if len(ne.collectors) == 0 {
+		// An exporter only serving probes has no collectors of its own.
+		if ne.opts.Probe && len(ne.allServers()) == 0 {
+			return nil
+		}
+		return fmt.Errorf("no collector is registered")
+	}
+
+	ne.polls.Lock()
+	defer ne.polls.Unlock()
+	polled := false
+	for _, s := range ne.allServers() {
+		failed, ok := ne.polls.failed[s.ID]
+		if ok && !failed {
+			return nil
+		}
+		polled = polled || ok
+	}
+	if polled {
+		return fmt.Errorf("the last polls of all servers failed")
+	}
+	return nil
+}
+
+// handleHealthz reports that the exporter is alive, as long as it serves
+// requests.
+func (ne *NATSExporter) handleHealthz(rw http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(rw, "ok")
+}
+
+// handleReadyz reports whether the exporter is ready to serve scrapes,
+// failing with the reason if not.
+func (ne *NATSExporter) handleReadyz(rw http.ResponseWriter, r *http.Request) {
+	if err := ne.Ready(); err != nil {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(rw, "ok")
+}
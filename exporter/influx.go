@@ -0,0 +1,211 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultInfluxInterval is how often the metrics are written to InfluxDB.
+var DefaultInfluxInterval = 15 * time.Second
+
+// influxSuffix is appended to the scrape path to serve the line protocol.
+const influxSuffix = "/influx"
+
+// influxField is a field of a line.
+type influxField struct {
+	key   string
+	value float64
+}
+
+// writeInflux writes the metric families in the InfluxDB line protocol,
+// as the Prometheus input of Telegraf does: a line per metric, measured
+// after the metric family and tagged with its labels, with the value in a
+// counter, gauge or value field, and summaries and histograms in their
+// count, sum and quantile or bucket fields.
+func writeInflux(out io.Writer, mfs []*dto.MetricFamily, now time.Time) error {
+	w := bufio.NewWriter(out)
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var fields []influxField
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				fields = append(fields, influxField{"counter", m.GetCounter().GetValue()})
+			case dto.MetricType_GAUGE:
+				fields = append(fields, influxField{"gauge", m.GetGauge().GetValue()})
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				fields = append(fields, influxField{"count", float64(s.GetSampleCount())},
+					influxField{"sum", s.GetSampleSum()})
+				for _, q := range s.Quantile {
+					fields = append(fields, influxField{strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64), q.GetValue()})
+				}
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				fields = append(fields, influxField{"count", float64(h.GetSampleCount())},
+					influxField{"sum", h.GetSampleSum()})
+				inf := false
+				for _, b := range h.Bucket {
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+					fields = append(fields, influxField{formatOpenMetricsFloat(b.GetUpperBound()), float64(b.GetCumulativeCount())})
+				}
+				if !inf {
+					fields = append(fields, influxField{"+Inf", float64(h.GetSampleCount())})
+				}
+			default:
+				fields = append(fields, influxField{"value", m.GetUntyped().GetValue()})
+			}
+			writeInfluxLine(w, mf.GetName(), m.Label, fields, ts)
+		}
+	}
+	return w.Flush()
+}
+
+// writeInfluxLine writes a line, leaving out the values InfluxDB does not
+// accept.
+func writeInfluxLine(w *bufio.Writer, measurement string, labels []*dto.LabelPair, fields []influxField, ts string) {
+	n := 0
+	for _, f := range fields {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			continue
+		}
+		if n == 0 {
+			w.WriteString(influxMeasurementEscaper.Replace(measurement))
+			for _, lp := range labels {
+				if lp.GetValue() == "" {
+					continue
+				}
+				w.WriteByte(',')
+				w.WriteString(influxKeyEscaper.Replace(lp.GetName()))
+				w.WriteByte('=')
+				w.WriteString(influxKeyEscaper.Replace(lp.GetValue()))
+			}
+			w.WriteByte(' ')
+		} else {
+			w.WriteByte(',')
+		}
+		w.WriteString(influxKeyEscaper.Replace(f.key))
+		w.WriteByte('=')
+		w.WriteString(strconv.FormatFloat(f.value, 'g', -1, 64))
+		n++
+	}
+	if n > 0 {
+		w.WriteByte(' ')
+		w.WriteString(ts)
+		w.WriteByte('\n')
+	}
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxKeyEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
+
+// getInfluxHandler serves the metrics of a scrape in the line protocol.
+func (ne *NATSExporter) getInfluxHandler() http.Handler {
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := ne.scrapeContext(r)
+		defer cancel()
+		mfs, err := ne.scrapeGatherer(ctx).Gather()
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("An error has occurred during metrics gathering:\n\n%v", err),
+				http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeInflux(rw, mfs, time.Now()); err != nil {
+			collector.Debugf("Unable to write the metrics: %v", err)
+		}
+	})
+	return ne.withAuth(h)
+}
+
+// influxWriteURL returns the URL of the InfluxDB v2 write API for the
+// organization and bucket.
+func influxWriteURL(base, org, bucket string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid InfluxDB URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid InfluxDB URL %q", base)
+	}
+	if bucket == "" {
+		return "", fmt.Errorf("the InfluxDB bucket is missing")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	q := url.Values{}
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// pushInflux writes the metrics a scrape would be served to InfluxDB.
+func (ne *NATSExporter) pushInflux() error {
+	ne.Lock()
+	base := ne.opts.InfluxURL
+	u, err := influxWriteURL(base, ne.opts.InfluxOrg, ne.opts.InfluxBucket)
+	token := ne.opts.InfluxToken
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := pushContext(timeout)
+	defer cancel()
+	mfs, err := ne.scrapeGatherer(ctx).Gather()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := writeInflux(&body, mfs, time.Now()); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, redactURL(base), msg)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// jsonSuffix is appended to the scrape path to serve the metrics as JSON.
+const jsonSuffix = ".json"
+
+// jsonMetrics is the metric set of a scrape as JSON.
+type jsonMetrics struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Metrics   []jsonSample `json:"metrics"`
+}
+
+// jsonSample is a sample, with the type and help of its metric family.
+type jsonSample struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Help   string            `json:"help,omitempty"`
+	Labels map[string]string `json:"labels"`
+	Value  jsonFloat         `json:"value"`
+}
+
+// jsonFloat is a value, encoded as a string if not finite as in the
+// Prometheus text format.
+type jsonFloat float64
+
+// MarshalJSON encodes the value.
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte(`"` + formatOpenMetricsFloat(v) + `"`), nil
+	}
+	return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+}
+
+// jsonPath returns the path serving the metrics as JSON, /metrics.json
+// for the default scrape path.
+func jsonPath(scrapePath string) string {
+	base := strings.TrimSuffix(scrapePath, "/")
+	if base == "" {
+		base = DefaultScrapePath
+	}
+	return base + jsonSuffix
+}
+
+// flattenMetrics returns the samples of the metric families, summaries and
+// histograms flattened to their quantile, bucket, sum and count samples.
+func flattenMetrics(mfs []*dto.MetricFamily) []jsonSample {
+	samples := []jsonSample{}
+	for _, mf := range mfs {
+		typ := strings.ToLower(mf.GetType().String())
+		add := func(name string, labels []*dto.LabelPair, extraName, extraValue string, v float64) {
+			s := jsonSample{Name: name, Type: typ, Help: mf.GetHelp(), Labels: make(map[string]string), Value: jsonFloat(v)}
+			for _, lp := range labels {
+				s.Labels[lp.GetName()] = lp.GetValue()
+			}
+			if extraName != "" {
+				s.Labels[extraName] = extraValue
+			}
+			samples = append(samples, s)
+		}
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.Label, "", "", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.Label, "", "", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, m.Label, "quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64), q.GetValue())
+				}
+				add(name+"_sum", m.Label, "", "", s.GetSampleSum())
+				add(name+"_count", m.Label, "", "", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.Bucket {
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+					add(name+"_bucket", m.Label, "le", formatOpenMetricsFloat(b.GetUpperBound()), float64(b.GetCumulativeCount()))
+				}
+				if !inf {
+					add(name+"_bucket", m.Label, "le", "+Inf", float64(h.GetSampleCount()))
+				}
+				add(name+"_sum", m.Label, "", "", h.GetSampleSum())
+				add(name+"_count", m.Label, "", "", float64(h.GetSampleCount()))
+			default:
+				add(name, m.Label, "", "", m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return samples
+}
+
+// getJSONHandler serves the metrics of a scrape as JSON.
+func (ne *NATSExporter) getJSONHandler() http.Handler {
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := ne.scrapeContext(r)
+		defer cancel()
+		mfs, err := ne.scrapeGatherer(ctx).Gather()
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("An error has occurred during metrics gathering:\n\n%v", err),
+				http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(jsonMetrics{Timestamp: time.Now().UTC(), Metrics: flattenMetrics(mfs)}); err != nil {
+			collector.Debugf("Unable to write the metrics: %v", err)
+		}
+	})
+	return ne.withAuth(h)
+}
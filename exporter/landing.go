@@ -0,0 +1,132 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>NATS Exporter</title></head>
+<body>
+<h1>NATS Exporter</h1>
+<p>Version {{.Version}}{{if .Commit}}, commit {{.Commit}}{{end}}, built with {{.GoVersion}}</p>
+<ul>
+<li><a href="{{.MetricsPath}}">Metrics</a></li>
+{{if not .AdminApart}}<li><a href="/healthz">Health</a></li>
+<li><a href="/readyz">Readiness</a></li>
+{{end}}</ul>
+<h2>Collectors</h2>
+<table>
+<tr><th>System</th><th>Endpoint</th><th>Status</th></tr>
+{{range .Collectors}}<tr><td>{{.System}}</td><td>{{.Endpoint}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+<h2>Servers</h2>
+<table>
+<tr><th>ID</th><th>URL</th><th>Status</th></tr>
+{{range .Servers}}<tr><td>{{.ID}}</td><td>{{.URL}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type landingCollector struct {
+	System   string
+	Endpoint string
+	Status   string
+}
+
+type landingServer struct {
+	ID     string
+	URL    string
+	Status string
+}
+
+// handleLanding serves a page linking to the metrics, and listing the
+// collectors and the servers polled with their status.
+func (ne *NATSExporter) handleLanding(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	ne.Lock()
+	data := struct {
+		Version     string
+		Commit      string
+		GoVersion   string
+		MetricsPath string
+		AdminApart  bool
+		Collectors  []landingCollector
+		Servers     []landingServer
+	}{
+		Version:     ne.opts.Version,
+		Commit:      ne.opts.Commit,
+		GoVersion:   runtime.Version(),
+		MetricsPath: ne.opts.ScrapePath,
+		AdminApart:  ne.opts.AdminListenAddress != "",
+	}
+	if data.Version == "" {
+		data.Version = "unknown"
+	}
+	if !strings.HasPrefix(data.MetricsPath, "/") {
+		data.MetricsPath = "/" + data.MetricsPath
+	}
+	for _, e := range selectedEndpoints(ne.opts) {
+		c := landingCollector{System: e.system, Endpoint: e.endpoint, Status: "registered"}
+		if _, ok := ne.endpoints[e.key()]; !ok {
+			c.Status = "not registered"
+		}
+		data.Collectors = append(data.Collectors, c)
+	}
+	servers := ne.allServers()
+	ne.Unlock()
+
+	ne.polls.Lock()
+	for _, s := range servers {
+		status := "not polled yet"
+		if failed, ok := ne.polls.failed[s.ID]; ok && failed {
+			status = "down"
+		} else if ok {
+			status = "up"
+		}
+		data.Servers = append(data.Servers, landingServer{ID: s.ID, URL: redactURL(s.URL), Status: status})
+	}
+	ne.polls.Unlock()
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := landingTemplate.Execute(rw, data); err != nil {
+		collector.Debugf("Unable to write the landing page: %v", err)
+	}
+}
+
+// redactURL returns the URL of a server without the password it may
+// hold.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
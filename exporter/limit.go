@@ -0,0 +1,80 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// requestLimiter limits the requests served at a time.  Further
+// requests wait for up to wait for one to complete, then are rejected
+// with a 503 response.
+type requestLimiter struct {
+	inFlight chan struct{}
+	wait     time.Duration
+}
+
+// newRequestLimiter returns a limiter of the requests, or nil if the
+// limit is zero or less.
+func newRequestLimiter(limit int, wait time.Duration) *requestLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &requestLimiter{inFlight: make(chan struct{}, limit), wait: wait}
+}
+
+// limit returns h, limiting its requests along with those of the other
+// handlers of the limiter.
+func (rl *requestLimiter) limit(h http.Handler) http.Handler {
+	if rl == nil {
+		return h
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !rl.acquire(r) {
+			collector.Debugf("Rejecting a request from %s, %d requests in flight", r.RemoteAddr, cap(rl.inFlight))
+			http.Error(rw, fmt.Sprintf(
+				"Limit of concurrent requests reached (%d), try again later.", cap(rl.inFlight)),
+				http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-rl.inFlight }()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// acquire takes a slot of the requests in flight, waiting for one until
+// the wait has passed or the request is canceled.
+func (rl *requestLimiter) acquire(r *http.Request) bool {
+	select {
+	case rl.inFlight <- struct{}{}:
+		return true
+	default:
+	}
+	if rl.wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(rl.wait)
+	defer timer.Stop()
+	select {
+	case rl.inFlight <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// logLevelPath is the path getting and changing the log level.
+const logLevelPath = "/admin/loglevel"
+
+// handleLogLevel returns the log level, after changing it to the level
+// given by a PUT request, as its level query parameter or its body.
+func (ne *NATSExporter) handleLogLevel(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		level := r.URL.Query().Get("level")
+		if level == "" {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level = strings.TrimSpace(string(body))
+		}
+		if err := collector.SetLogLevel(level); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		collector.Noticef("Log level changed to %s", level)
+	default:
+		rw.Header().Set("Allow", "GET, PUT")
+		http.Error(rw, "Only GET and PUT requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(rw, collector.LogLevel())
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// openMetricsContentType is the content type of the OpenMetrics text
+// format.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// openMetricsUnits are the units declared by # UNIT for the metric
+// families whose name ends with them.
+var openMetricsUnits = []string{"seconds", "bytes", "ratio"}
+
+// acceptsOpenMetrics reports whether the scraper accepts the OpenMetrics
+// text format.
+func acceptsOpenMetrics(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mt != "application/openmetrics-text" {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(q, 64); err != nil || f <= 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveOpenMetrics gathers the metrics and writes them in the OpenMetrics
+// text format, failing the scrape if they cannot all be gathered as the
+// text format handler does.
+func (ne *NATSExporter) serveOpenMetrics(rw http.ResponseWriter, r *http.Request, g prometheus.Gatherer) {
+	mfs, err := g.Gather()
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("An error has occurred during metrics gathering:\n\n%v", err),
+			http.StatusInternalServerError)
+		return
+	}
+	var created *createdTracker
+	if ne.opts.OpenMetricsCreated {
+		created = ne.created
+	}
+
+	rw.Header().Set("Content-Type", openMetricsContentType)
+	var w io.Writer = rw
+	if acceptsGzip(r) {
+		rw.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(rw)
+		defer gz.Close()
+		w = gz
+	}
+	if err := writeOpenMetrics(w, mfs, created); err != nil {
+		collector.Debugf("Unable to write the metrics: %v", err)
+	}
+}
+
+// acceptsGzip reports whether the scraper accepts gzip compressed
+// responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOpenMetrics writes the metric families in the OpenMetrics text
+// format.  Counters are exposed with the _total suffix, and with their
+// _created sample if the created tracker is set.
+func writeOpenMetrics(out io.Writer, mfs []*dto.MetricFamily, created *createdTracker) error {
+	w := bufio.NewWriter(out)
+	now := time.Now()
+	var seen map[string]bool
+	if created != nil {
+		seen = make(map[string]bool)
+	}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		typ := "unknown"
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			typ = "counter"
+			name = strings.TrimSuffix(name, "_total")
+		case dto.MetricType_GAUGE:
+			typ = "gauge"
+		case dto.MetricType_SUMMARY:
+			typ = "summary"
+		case dto.MetricType_HISTOGRAM:
+			typ = "histogram"
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		for _, unit := range openMetricsUnits {
+			if strings.HasSuffix(name, "_"+unit) {
+				fmt.Fprintf(w, "# UNIT %s %s\n", name, unit)
+				break
+			}
+		}
+		if mf.GetHelp() != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, escapeOpenMetrics(mf.GetHelp()))
+		}
+
+		for _, m := range mf.Metric {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				v := m.GetCounter().GetValue()
+				writeOpenMetricsSample(w, name+"_total", m.Label, "", 0, v, m)
+				if created != nil {
+					key := seriesKey(name, m.Label)
+					seen[key] = true
+					t := created.created(key, v, now)
+					writeOpenMetricsSample(w, name+"_created", m.Label, "", 0, float64(t.UnixNano())/1e9, m)
+				}
+			case dto.MetricType_GAUGE:
+				writeOpenMetricsSample(w, name, m.Label, "", 0, m.GetGauge().GetValue(), m)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					writeOpenMetricsSample(w, name, m.Label, "quantile", q.GetQuantile(), q.GetValue(), m)
+				}
+				writeOpenMetricsSample(w, name+"_sum", m.Label, "", 0, s.GetSampleSum(), m)
+				writeOpenMetricsSample(w, name+"_count", m.Label, "", 0, float64(s.GetSampleCount()), m)
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.Bucket {
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+					writeOpenMetricsSample(w, name+"_bucket", m.Label, "le", b.GetUpperBound(),
+						float64(b.GetCumulativeCount()), m)
+				}
+				if !inf {
+					writeOpenMetricsSample(w, name+"_bucket", m.Label, "le", math.Inf(1),
+						float64(h.GetSampleCount()), m)
+				}
+				writeOpenMetricsSample(w, name+"_sum", m.Label, "", 0, h.GetSampleSum(), m)
+				writeOpenMetricsSample(w, name+"_count", m.Label, "", 0, float64(h.GetSampleCount()), m)
+			default:
+				writeOpenMetricsSample(w, name, m.Label, "", 0, m.GetUntyped().GetValue(), m)
+			}
+		}
+	}
+	w.WriteString("# EOF\n")
+	if created != nil {
+		created.prune(seen)
+	}
+	return w.Flush()
+}
+
+// writeOpenMetricsSample writes a sample, with the extra label, a
+// quantile or bucket bound, if its name is given.
+func writeOpenMetricsSample(w *bufio.Writer, name string, labels []*dto.LabelPair,
+	extraName string, extraValue, value float64, m *dto.Metric) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, lp := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", lp.GetName(), escapeOpenMetrics(lp.GetValue()))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, formatOpenMetricsFloat(extraValue))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatOpenMetricsFloat(value))
+	if m.TimestampMs != nil {
+		w.WriteByte(' ')
+		w.WriteString(formatOpenMetricsFloat(float64(m.GetTimestampMs()) / 1000))
+	}
+	w.WriteByte('\n')
+}
+
+// formatOpenMetricsFloat formats a value, with integral values written as
+// floats.
+func formatOpenMetricsFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// escapeOpenMetrics escapes help texts and label values.
+func escapeOpenMetrics(s string) string {
+	return openMetricsEscaper.Replace(s)
+}
+
+// seriesKey identifies a series by its name and labels.
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		pairs = append(pairs, lp.GetName()+"="+lp.GetValue())
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// createdTracker tracks the creation time of counters, which the NATS
+// servers do not report: the time a counter was first scraped, or last
+// seen reset.
+type createdTracker struct {
+	sync.Mutex
+	counters map[string]*createdCounter
+}
+
+type createdCounter struct {
+	created time.Time
+	value   float64
+}
+
+func newCreatedTracker() *createdTracker {
+	return &createdTracker{counters: make(map[string]*createdCounter)}
+}
+
+// created returns the creation time of the counter with the value.
+func (ct *createdTracker) created(key string, value float64, now time.Time) time.Time {
+	ct.Lock()
+	defer ct.Unlock()
+	c, ok := ct.counters[key]
+	if !ok || value < c.value {
+		c = &createdCounter{created: now}
+		ct.counters[key] = c
+	}
+	c.value = value
+	return c.created
+}
+
+// prune forgets the counters not seen in the last scrape.
+func (ct *createdTracker) prune(seen map[string]bool) {
+	ct.Lock()
+	defer ct.Unlock()
+	for key := range ct.counters {
+		if !seen[key] {
+			delete(ct.counters, key)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultOTLPInterval is how often the metrics are pushed to the
+// OpenTelemetry Collector.
+var DefaultOTLPInterval = 15 * time.Second
+
+// otlpMetricsPath is the path of the OTLP/HTTP metrics service.
+const otlpMetricsPath = "/v1/metrics"
+
+// otlpCumulative is the cumulative aggregation temporality of sums and
+// histograms.
+const otlpCumulative = 2
+
+// otlpUnits are the units of the metrics whose name ends with their
+// Prometheus suffix.
+var otlpUnits = map[string]string{"seconds": "s", "bytes": "By", "ratio": "1"}
+
+// The OTLP metrics request in its JSON encoding.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          otlpDouble      `json:"asDouble"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               otlpDouble      `json:"sum"`
+	QuantileValues    []otlpQuantile  `json:"quantileValues,omitempty"`
+}
+
+type otlpQuantile struct {
+	Quantile otlpDouble `json:"quantile"`
+	Value    otlpDouble `json:"value"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               otlpDouble      `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []otlpDouble    `json:"explicitBounds"`
+}
+
+// otlpDouble is a double, encoded as a string if not finite as the
+// protobuf JSON mapping requires.
+type otlpDouble float64
+
+// MarshalJSON encodes the double.
+func (d otlpDouble) MarshalJSON() ([]byte, error) {
+	f := float64(d)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// otlpMetricsURL returns the URL of the metrics service of an OTLP/HTTP
+// endpoint, given with or without the service path.
+func otlpMetricsURL(endpoint string) (string, error) {
+	return otlpServiceURL(endpoint, otlpMetricsPath)
+}
+
+// otlpServiceURL returns the URL of the service at path of an OTLP/HTTP
+// endpoint, given with or without the service path.
+func otlpServiceURL(endpoint, path string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid OTLP endpoint: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q, only OTLP over http or https is supported", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, path) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + path
+	}
+	return u.String(), nil
+}
+
+// pushOTLP pushes the metrics a scrape would be served to the
+// OpenTelemetry Collector.
+func (ne *NATSExporter) pushOTLP() error {
+	ne.Lock()
+	endpoint := ne.opts.OTLPEndpoint
+	version := ne.opts.Version
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+	u, err := otlpMetricsURL(endpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := pushContext(timeout)
+	defer cancel()
+	mfs, err := ne.scrapeGatherer(ctx).Gather()
+	if err != nil {
+		return err
+	}
+	return postOTLP(ctx, u, otlpMetricsRequest(mfs, version, ne.otlpStarts, time.Now()))
+}
+
+// postOTLP posts an OTLP request in its JSON encoding to the service at u.
+func postOTLP(ctx context.Context, u string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, redactURL(u), msg)
+	}
+	return nil
+}
+
+// otlpMetricsRequest converts the metric families to an OTLP request.
+// Cumulative metrics start when first pushed, or last seen reset, as
+// tracked by starts.
+func otlpMetricsRequest(mfs []*dto.MetricFamily, version string, starts *createdTracker, now time.Time) *otlpRequest {
+	ts := otlpTime(now)
+	seen := make(map[string]bool)
+	start := func(name string, labels []*dto.LabelPair, value float64) string {
+		key := seriesKey(name, labels)
+		seen[key] = true
+		return otlpTime(starts.created(key, value, now))
+	}
+
+	var metrics []otlpMetric
+	for _, mf := range mfs {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Name = strings.TrimSuffix(m.Name, "_total")
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, pm := range mf.Metric {
+				v := pm.GetCounter().GetValue()
+				m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{
+					Attributes:        otlpAttributes(pm.Label),
+					StartTimeUnixNano: start(m.Name, pm.Label, v),
+					TimeUnixNano:      ts,
+					AsDouble:          otlpDouble(v),
+				})
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+			for _, pm := range mf.Metric {
+				s := pm.GetSummary()
+				dp := otlpSummaryDataPoint{
+					Attributes:        otlpAttributes(pm.Label),
+					StartTimeUnixNano: start(m.Name, pm.Label, float64(s.GetSampleCount())),
+					TimeUnixNano:      ts,
+					Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:               otlpDouble(s.GetSampleSum()),
+				}
+				for _, q := range s.Quantile {
+					dp.QuantileValues = append(dp.QuantileValues,
+						otlpQuantile{Quantile: otlpDouble(q.GetQuantile()), Value: otlpDouble(q.GetValue())})
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, dp)
+			}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, pm := range mf.Metric {
+				h := pm.GetHistogram()
+				dp := otlpHistogramDataPoint{
+					Attributes:        otlpAttributes(pm.Label),
+					StartTimeUnixNano: start(m.Name, pm.Label, float64(h.GetSampleCount())),
+					TimeUnixNano:      ts,
+					Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+					Sum:               otlpDouble(h.GetSampleSum()),
+					ExplicitBounds:    []otlpDouble{},
+				}
+				// OTLP buckets count the observations within their bounds
+				// rather than up to them, the last one up to +Inf.
+				var cumulative uint64
+				for _, b := range h.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					dp.ExplicitBounds = append(dp.ExplicitBounds, otlpDouble(b.GetUpperBound()))
+					dp.BucketCounts = append(dp.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-cumulative, 10))
+					cumulative = b.GetCumulativeCount()
+				}
+				dp.BucketCounts = append(dp.BucketCounts, strconv.FormatUint(h.GetSampleCount()-cumulative, 10))
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, dp)
+			}
+		default:
+			m.Gauge = &otlpGauge{}
+			for _, pm := range mf.Metric {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberDataPoint{
+					Attributes:   otlpAttributes(pm.Label),
+					TimeUnixNano: ts,
+					AsDouble:     otlpDouble(v),
+				})
+			}
+		}
+		for suffix, unit := range otlpUnits {
+			if strings.HasSuffix(m.Name, "_"+suffix) {
+				m.Unit = unit
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	starts.prune(seen)
+
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpServiceResource(version),
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "prometheus-nats-exporter", Version: version},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// otlpServiceResource returns the resource of the exporter, the service
+// the metrics and spans are sent by.
+func otlpServiceResource(version string) otlpResource {
+	attrs := []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: "prometheus-nats-exporter"}}}
+	if version != "" {
+		attrs = append(attrs, otlpAttribute{Key: "service.version", Value: otlpValue{StringValue: version}})
+	}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, otlpAttribute{Key: "service.instance.id", Value: otlpValue{StringValue: host}})
+	}
+	return otlpResource{Attributes: attrs}
+}
+
+// otlpAttributes converts the labels of a metric to attributes.
+func otlpAttributes(labels []*dto.LabelPair) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(labels))
+	for _, lp := range labels {
+		attrs = append(attrs, otlpAttribute{Key: lp.GetName(), Value: otlpValue{StringValue: lp.GetValue()}})
+	}
+	return attrs
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
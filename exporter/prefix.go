@@ -0,0 +1,84 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// checkLegacyPrefixNames checks the legacy prefix names are served
+// alongside a prefix.
+func checkLegacyPrefixNames(opts *NATSExporterOptions) error {
+	if opts.LegacyPrefixNames && opts.Prefix == "" {
+		return fmt.Errorf("legacy prefix names are only served alongside a prefix")
+	}
+	return nil
+}
+
+// legacyPrefixSystems returns the systems of the collectors whose metrics
+// are also served under their default prefix.
+// caller must lock
+func (ne *NATSExporter) legacyPrefixSystems() map[prometheus.Collector]string {
+	if !ne.opts.LegacyPrefixNames || ne.opts.Prefix == "" {
+		return nil
+	}
+	systems := make(map[prometheus.Collector]string)
+	for _, e := range ne.collectedEndpoints() {
+		if c, ok := ne.endpoints[e.key()]; ok && e.system != ne.opts.Prefix {
+			systems[c] = e.system
+		}
+	}
+	return systems
+}
+
+// legacyPrefixGatherer serves the metrics of the collectors of a system
+// both under the prefix and under the default prefix of the system.
+type legacyPrefixGatherer struct {
+	prometheus.Gatherer
+	prefix string
+	system string
+}
+
+// Gather gathers the metric families of the underlying gatherer, along
+// with their copies under the default prefix.
+func (lg *legacyPrefixGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := lg.Gatherer.Gather()
+	return legacyPrefixFamilies(mfs, lg.prefix, lg.system), err
+}
+
+// legacyPrefixFamilies returns the metric families, along with a copy of
+// each named after prefix renamed after system instead, e.g.
+// nats_varz_connections as gnatsd_varz_connections.  The metrics named the
+// same whatever the prefix are not copied.
+func legacyPrefixFamilies(mfs []*dto.MetricFamily, prefix, system string) []*dto.MetricFamily {
+	if prefix == "" || prefix == system {
+		return mfs
+	}
+	for _, mf := range mfs[:len(mfs):len(mfs)] {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, prefix+"_") || collector.IsFixedMetricName(name) {
+			continue
+		}
+		legacy := proto.Clone(mf).(*dto.MetricFamily)
+		legacy.Name = proto.String(system + strings.TrimPrefix(name, prefix))
+		mfs = append(mfs, legacy)
+	}
+	return mfs
+}
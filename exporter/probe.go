@@ -0,0 +1,180 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// probePath is the path polling the server given by each request.
+const probePath = "/probe"
+
+// gatheredMetrics is a gatherer of metrics already gathered.
+type gatheredMetrics struct {
+	mfs []*dto.MetricFamily
+	err error
+}
+
+// Gather returns the metrics already gathered.
+func (gm *gatheredMetrics) Gather() ([]*dto.MetricFamily, error) {
+	return gm.mfs, gm.err
+}
+
+// probeTarget returns the URL of the monitor port of a probe target,
+// given as a URL or as host:port, polled over http.
+func probeTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("the target parameter is missing")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// handleProbe polls the server given by the target parameter with the
+// collectors in the collect parameter, or those configured if absent, and
+// serves its metrics along with probe_success and probe_duration_seconds.
+func (ne *NATSExporter) handleProbe(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := q.Get("target")
+	u, err := probeTarget(target)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ne.Lock()
+	opts := *ne.opts
+	filter, rules := ne.filter, ne.relabelRules
+	ne.Unlock()
+	// The target is polled over HTTP, never through the system account.
+	opts.SysURL, opts.GetSysEvents = "", false
+	// The target is chosen by the request, so it is not sent the
+	// credentials of the servers the exporter monitors.
+	opts.MonitorUser, opts.MonitorPassword, opts.BearerTokenFile = "", "", ""
+	if opts.TLSConfig != nil {
+		opts.TLSConfig = opts.TLSConfig.Clone()
+		opts.TLSConfig.Certificates, opts.TLSConfig.GetClientCertificate = nil, nil
+	}
+	opts.HTTPClient = collector.NewHTTPClient(&opts.CollectorOptions)
+	defer opts.HTTPClient.CloseIdleConnections()
+	if collect := q.Get("collect"); collect != "" {
+		opts.GetVarz, opts.GetConnz, opts.GetSubz, opts.GetRoutez = false, false, false, false
+		opts.GetGatewayz, opts.GetJsz, opts.GetIpqueuesz, opts.GetReplicatorVarz = false, false, false, false
+		opts.GetStreamingChannelz, opts.GetStreamingServerz = false, false
+		opts.GetStreamingClientsz, opts.GetStreamingStorez = false, false
+		if err := SetCollectors(&opts, collect); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	servers := []*collector.CollectedServer{{ID: target, URL: u}}
+	if err := checkCollectorOptions(&opts, servers); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := ne.scrapeContext(r)
+	defer cancel()
+	ctx, span := ne.startTrace(ctx, "probe")
+	defer span.Finish(nil)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	span.SetAttribute("probe.target", redactURL(target))
+	start := time.Now()
+
+	var mu sync.Mutex
+	success := true
+	copts := opts.CollectorOptions
+	copts.PollSpread = 0
+	copts.OnPoll = func(serverID string, err error) {
+		if err != nil {
+			mu.Lock()
+			success = false
+			mu.Unlock()
+		}
+	}
+	// The collectors of each system are gathered apart, for their metrics
+	// to be served under its default prefix too, if configured to.
+	regs := make(map[string]*prometheus.Registry)
+	var gatherers prometheus.Gatherers
+	for _, e := range selectedEndpoints(&opts) {
+		reg, ok := regs[e.system]
+		if !ok {
+			reg = prometheus.NewRegistry()
+			regs[e.system] = reg
+			var g prometheus.Gatherer = reg
+			if opts.LegacyPrefixNames {
+				g = &legacyPrefixGatherer{Gatherer: reg, prefix: opts.Prefix, system: e.system}
+			}
+			gatherers = append(gatherers, g)
+		}
+		var c prometheus.Collector = collector.NewCollectorWithOptions(e.system, e.endpoint, opts.Prefix, servers, &copts)
+		if cc, ok := c.(collector.ContextCollector); ok {
+			c = &scrapeCollector{ContextCollector: cc, ctx: ctx}
+		}
+		// The collectors of endpoints that did not respond describe no
+		// metrics to register.
+		if err := reg.Register(c); err != nil {
+			collector.Debugf("Unable to register the %s collector of %s: %v", e.endpoint, target, err)
+			mu.Lock()
+			success = false
+			mu.Unlock()
+		}
+	}
+	mfs, err := gatherers.Gather()
+	mfs = limitSeries(mfs, opts.MaxSeries)
+
+	probe := prometheus.NewRegistry()
+	successGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether all the polls of the probe succeeded",
+	})
+	durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "How long the probe took to complete in seconds",
+	})
+	probe.MustRegister(successGauge, durationGauge)
+	mu.Lock()
+	successGauge.Set(boolToFloat(success && err == nil))
+	mu.Unlock()
+	durationGauge.Set(time.Since(start).Seconds())
+
+	g := prometheus.Gatherers{&gatheredMetrics{mfs: mfs, err: err}, probe}
+	ne.serveGatherer(rw, r, filterGatherer(g, filter, rules))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
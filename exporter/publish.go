@@ -0,0 +1,119 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/go-nats"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Publishing defaults
+var (
+	DefaultPublishSubject  = "nats.exporter.metrics"
+	DefaultPublishFormat   = "json"
+	DefaultPublishInterval = 15 * time.Second
+)
+
+// publisher holds the connection the metrics are published on, opened
+// on the first publish and kept until the exporter is stopped.
+type publisher struct {
+	sync.Mutex
+	nc *nats.Conn
+}
+
+// conn returns the connection to the NATS server, connecting if needed.
+func (p *publisher) conn(url string) (*nats.Conn, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.nc != nil && !p.nc.IsClosed() {
+		return p.nc, nil
+	}
+	nc, err := nats.Connect(url, nats.Name("prometheus-nats-exporter"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	p.nc = nc
+	return nc, nil
+}
+
+// close closes the connection, if open.
+func (p *publisher) close() {
+	p.Lock()
+	defer p.Unlock()
+	if p.nc != nil {
+		p.nc.Close()
+		p.nc = nil
+	}
+}
+
+// checkPublishFormat checks the format of the metrics published.
+func checkPublishFormat(format string) error {
+	switch format {
+	case "", "json", "protobuf":
+		return nil
+	}
+	return fmt.Errorf("invalid publish format %q, json or protobuf expected", format)
+}
+
+// publishMetrics publishes the metrics a scrape would be served on the
+// subject, as JSON or as delimited Prometheus protobuf metric families.
+func (ne *NATSExporter) publishMetrics() error {
+	ne.Lock()
+	url := ne.opts.PublishURL
+	subject := ne.opts.PublishSubject
+	format := ne.opts.PublishFormat
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+	if subject == "" {
+		subject = DefaultPublishSubject
+	}
+
+	ctx, cancel := pushContext(timeout)
+	defer cancel()
+	mfs, err := ne.scrapeGatherer(ctx).Gather()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if format == "protobuf" {
+		var buf bytes.Buffer
+		enc := expfmt.NewEncoder(&buf, expfmt.FmtProtoDelim)
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				return err
+			}
+		}
+		data = buf.Bytes()
+	} else {
+		data, err = json.Marshal(jsonMetrics{Timestamp: time.Now().UTC(), Metrics: flattenMetrics(mfs)})
+		if err != nil {
+			return err
+		}
+	}
+
+	nc, err := ne.publisher.conn(url)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %v", redactURL(url), err)
+	}
+	if err := nc.Publish(subject, data); err != nil {
+		return err
+	}
+	return nc.Flush()
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Pushgateway defaults
+var (
+	DefaultPushGatewayJob      = "nats"
+	DefaultPushGatewayInterval = 15 * time.Second
+)
+
+// pushGrouping returns the grouping labels of the metrics pushed: the
+// instance configured, or the host name of the exporter.
+func pushGrouping(opts *NATSExporterOptions) map[string]string {
+	instance := opts.PushGatewayInstance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	if instance == "" {
+		return nil
+	}
+	return map[string]string{"instance": instance}
+}
+
+// pushMetrics pushes the metrics a scrape would be served to the
+// Pushgateway, replacing those pushed before.
+func (ne *NATSExporter) pushMetrics() error {
+	ne.Lock()
+	url := ne.opts.PushGatewayURL
+	job := ne.opts.PushGatewayJob
+	grouping := pushGrouping(ne.opts)
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+	if job == "" {
+		job = DefaultPushGatewayJob
+	}
+
+	ctx, cancel := pushContext(timeout)
+	defer cancel()
+	return push.FromGatherer(job, grouping, url, ne.scrapeGatherer(ctx))
+}
+
+// pushContext bounds collecting the metrics pushed by the collect
+// timeout, if set.
+func pushContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// startPushing pushes the metrics to each destination configured on its
+// interval until the exporter is stopped.
+// caller must lock
+func (ne *NATSExporter) startPushing() {
+	quit := make(chan struct{})
+	ne.pushQuit = quit
+	if ne.opts.PushGatewayURL != "" {
+		ne.pushEvery(quit, "Pushgateway", redactURL(ne.opts.PushGatewayURL),
+			ne.opts.PushGatewayInterval, DefaultPushGatewayInterval, ne.pushMetrics)
+	}
+	if ne.opts.OTLPEndpoint != "" {
+		ne.pushEvery(quit, "OpenTelemetry Collector", redactURL(ne.opts.OTLPEndpoint),
+			ne.opts.OTLPInterval, DefaultOTLPInterval, ne.pushOTLP)
+	}
+	if ne.opts.StatsdAddress != "" {
+		ne.pushEvery(quit, "StatsD server", ne.opts.StatsdAddress,
+			ne.opts.StatsdInterval, DefaultStatsdInterval, ne.pushStatsd)
+	}
+	if ne.opts.GraphiteAddress != "" {
+		ne.pushEvery(quit, "Graphite server", ne.opts.GraphiteAddress,
+			ne.opts.GraphiteInterval, DefaultGraphiteInterval, ne.pushGraphite)
+	}
+	if ne.opts.InfluxURL != "" {
+		ne.pushEvery(quit, "InfluxDB server", redactURL(ne.opts.InfluxURL),
+			ne.opts.InfluxInterval, DefaultInfluxInterval, ne.pushInflux)
+	}
+	if ne.opts.PublishURL != "" {
+		ne.pushEvery(quit, "NATS server", redactURL(ne.opts.PublishURL),
+			ne.opts.PublishInterval, DefaultPublishInterval, ne.publishMetrics)
+	}
+}
+
+// pushEvery calls fn on the interval, or the default one if not set,
+// until quit is closed, logging the pushes that fail.
+func (ne *NATSExporter) pushEvery(quit chan struct{}, name, dest string, interval, def time.Duration, fn func() error) {
+	if interval <= 0 {
+		interval = def
+	}
+	collector.Noticef("Pushing the metrics to %s every %v", dest, interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := fn(); err != nil {
+				collector.Errorf("Unable to push the metrics to the %s: %v", name, err)
+			}
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// stopPushing stops pushing the metrics, if pushing.
+// caller must lock
+func (ne *NATSExporter) stopPushing() {
+	if ne.pushQuit != nil {
+		close(ne.pushQuit)
+		ne.pushQuit = nil
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rawPath is the path serving the last response of a server to an
+// endpoint, at rawPath<server_id>/<endpoint>.
+const rawPath = "/debug/raw/"
+
+// rawResponse is a response of a server, as received.
+type rawResponse struct {
+	body     []byte
+	received time.Time
+}
+
+// rawResponses holds the last response of each server to each endpoint
+// polled.
+type rawResponses struct {
+	sync.Mutex
+	last map[string]rawResponse
+}
+
+// record records a response of the server to the endpoint.
+func (rr *rawResponses) record(serverID, endpoint string, body []byte) {
+	rr.Lock()
+	defer rr.Unlock()
+	if rr.last == nil {
+		rr.last = make(map[string]rawResponse)
+	}
+	rr.last[serverID+"/"+endpoint] = rawResponse{body: body, received: time.Now()}
+}
+
+// get returns the last response of the server to the endpoint.
+func (rr *rawResponses) get(serverID, endpoint string) (rawResponse, bool) {
+	rr.Lock()
+	defer rr.Unlock()
+	resp, ok := rr.last[serverID+"/"+endpoint]
+	return resp, ok
+}
+
+// handleRaw serves the last response of the server to the endpoint given
+// by the path, as received.
+func (ne *NATSExporter) handleRaw(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, rawPath)
+	i := strings.LastIndex(p, "/")
+	if i <= 0 || i == len(p)-1 {
+		http.Error(rw, fmt.Sprintf("expected %s<server_id>/<endpoint>", rawPath), http.StatusNotFound)
+		return
+	}
+	serverID, endpoint := p[:i], p[i+1:]
+	resp, ok := ne.raw.get(serverID, endpoint)
+	if !ok {
+		http.Error(rw, fmt.Sprintf("no %s response from server %s", endpoint, serverID), http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Last-Modified", resp.received.UTC().Format(http.TimeFormat))
+	rw.Write(resp.body)
+}
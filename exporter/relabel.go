@@ -0,0 +1,222 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// Relabeling actions
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelLabelMap  = "labelmap"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+	RelabelLowercase = "lowercase"
+	RelabelUppercase = "uppercase"
+)
+
+// RelabelConfig is a Prometheus style relabeling rule, applied to the
+// labels of each metric before it is served.  The name of the metric is
+// available as the __name__ source label, but cannot be changed.
+type RelabelConfig struct {
+	SourceLabels []string
+	Separator    string
+	Regex        string
+	TargetLabel  string
+	Replacement  string
+	Action       string
+}
+
+// DefaultRelabelConfig holds the defaults of the fields of a relabeling
+// rule, as in Prometheus.
+var DefaultRelabelConfig = RelabelConfig{
+	Separator:   ";",
+	Regex:       "(.*)",
+	Replacement: "$1",
+	Action:      RelabelReplace,
+}
+
+// relabelRule is a compiled relabeling rule.
+type relabelRule struct {
+	RelabelConfig
+	regex *regexp.Regexp
+}
+
+// compileRelabelConfigs validates and compiles relabeling rules.
+func compileRelabelConfigs(configs []RelabelConfig) ([]*relabelRule, error) {
+	rules := make([]*relabelRule, 0, len(configs))
+	for i, c := range configs {
+		re, err := regexp.Compile("^(?:" + c.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid regex: %v", i, err)
+		}
+		switch c.Action {
+		case RelabelReplace, RelabelLowercase, RelabelUppercase:
+			if c.TargetLabel == "" {
+				return nil, fmt.Errorf("relabel rule %d: %s requires a target label", i, c.Action)
+			}
+			if c.TargetLabel == model.MetricNameLabel {
+				return nil, fmt.Errorf("relabel rule %d: the metric name cannot be changed", i)
+			}
+		case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i, c.Action)
+		}
+		rules = append(rules, &relabelRule{RelabelConfig: c, regex: re})
+	}
+	return rules, nil
+}
+
+// relabel applies the rules to the labels, returning false if the
+// metric is dropped.
+func relabel(labels map[string]string, rules []*relabelRule) bool {
+	for _, r := range rules {
+		values := make([]string, 0, len(r.SourceLabels))
+		for _, name := range r.SourceLabels {
+			values = append(values, labels[name])
+		}
+		value := strings.Join(values, r.Separator)
+
+		switch r.Action {
+		case RelabelKeep:
+			if !r.regex.MatchString(value) {
+				return false
+			}
+		case RelabelDrop:
+			if r.regex.MatchString(value) {
+				return false
+			}
+		case RelabelReplace:
+			idx := r.regex.FindStringSubmatchIndex(value)
+			if idx == nil {
+				continue
+			}
+			target := string(r.regex.ExpandString(nil, r.TargetLabel, value, idx))
+			if !model.LabelName(target).IsValid() || target == model.MetricNameLabel {
+				continue
+			}
+			res := string(r.regex.ExpandString(nil, r.Replacement, value, idx))
+			if res == "" {
+				delete(labels, target)
+			} else {
+				labels[target] = res
+			}
+		case RelabelLowercase:
+			labels[r.TargetLabel] = strings.ToLower(value)
+		case RelabelUppercase:
+			labels[r.TargetLabel] = strings.ToUpper(value)
+		case RelabelLabelMap:
+			mapped := make(map[string]string)
+			for name, v := range labels {
+				if name != model.MetricNameLabel && r.regex.MatchString(name) {
+					mapped[r.regex.ReplaceAllString(name, r.Replacement)] = v
+				}
+			}
+			for name, v := range mapped {
+				if model.LabelName(name).IsValid() && name != model.MetricNameLabel {
+					labels[name] = v
+				}
+			}
+		case RelabelLabelDrop, RelabelLabelKeep:
+			for name := range labels {
+				if name == model.MetricNameLabel {
+					continue
+				}
+				if r.regex.MatchString(name) == (r.Action == RelabelLabelDrop) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// relabelGatherer relabels the metrics gathered.
+type relabelGatherer struct {
+	prometheus.Gatherer
+	rules []*relabelRule
+}
+
+// Gather gathers the metric families of the underlying gatherer, with
+// their metrics relabeled, leaving out the metrics dropped.  Metrics
+// relabeled with the labels of another of their family are left out too,
+// reported as errors, as a registry reports metrics collected twice.
+func (rg *relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := rg.Gatherer.Gather()
+	var errs prometheus.MultiError
+	if multi, ok := err.(prometheus.MultiError); ok {
+		errs = multi
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		metrics := mf.Metric[:0]
+		seen := make(map[string]bool, len(mf.Metric))
+		for _, m := range mf.Metric {
+			labels := make(map[string]string, len(m.Label)+1)
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			labels[model.MetricNameLabel] = mf.GetName()
+			if !relabel(labels, rg.rules) {
+				continue
+			}
+			delete(labels, model.MetricNameLabel)
+
+			// The label pairs may be shared with the collected metric, so
+			// they are replaced rather than modified.
+			pairs := make([]*dto.LabelPair, 0, len(labels))
+			for name, value := range labels {
+				pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+			}
+			sort.Sort(prometheus.LabelPairSorter(pairs))
+			key := labelPairsKey(pairs)
+			if seen[key] {
+				errs = append(errs, fmt.Errorf("metric %s relabeled to the labels of another, {%s}",
+					mf.GetName(), key))
+				continue
+			}
+			seen[key] = true
+			m.Label = pairs
+			metrics = append(metrics, m)
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			kept = append(kept, mf)
+		}
+	}
+	return kept, errs.MaybeUnwrap()
+}
+
+// labelPairsKey returns a key identifying the sorted label pairs of a
+// metric, its label values quoted.
+func labelPairsKey(pairs []*dto.LabelPair) string {
+	parts := make([]string, len(pairs))
+	for i, lp := range pairs {
+		parts[i] = fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue())
+	}
+	return strings.Join(parts, ",")
+}
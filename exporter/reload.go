@@ -0,0 +1,273 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reloadPath is the path reloading the configuration.
+const reloadPath = "/-/reload"
+
+// Reload applies new options and servers to the running exporter without
+// closing its listener.  Collectors are only replaced when the servers or
+// the options they poll with change; otherwise just the collectors of
+// deselected endpoints are removed and those of newly selected ones
+// added.  The listener, logging, scrape authentication, exposition and
+// push options cannot be reloaded, and changing them is an error.  If the
+// new collectors cannot be created, the exporter keeps polling with the
+// previous options and servers.
+func (ne *NATSExporter) Reload(opts *NATSExporterOptions, servers []*collector.CollectedServer) error {
+	ne.Lock()
+	defer ne.Unlock()
+
+	if !ne.running {
+		return fmt.Errorf("the exporter is not running")
+	}
+	if len(servers) == 0 && len(ne.discoveries) == 0 && !ne.opts.AdminAPI && !ne.opts.Probe &&
+		ne.opts.SysURL == "" {
+		return fmt.Errorf("no servers configured to obtain metrics")
+	}
+	if name := changedFixedOption(ne.opts, opts); name != "" {
+		return fmt.Errorf("%s cannot be changed by reload", name)
+	}
+	if err := checkCollectorOptions(opts, servers); err != nil {
+		return err
+	}
+	filter, err := newMetricFilter(opts.MetricsInclude, opts.MetricsExclude)
+	if err != nil {
+		return err
+	}
+	rules, err := compileRelabelConfigs(opts.RelabelConfigs)
+	if err != nil {
+		return err
+	}
+
+	recreate := !sameServers(ne.servers, servers) ||
+		!reflect.DeepEqual(collectorSettings(ne.opts), collectorSettings(opts))
+
+	o := ne.opts
+	prev, prevServers := *o, ne.servers
+	o.GetConnz = opts.GetConnz
+	o.GetVarz = opts.GetVarz
+	o.GetSubz = opts.GetSubz
+	o.GetRoutez = opts.GetRoutez
+	o.GetGatewayz = opts.GetGatewayz
+	o.GetJsz = opts.GetJsz
+	o.GetIpqueuesz = opts.GetIpqueuesz
+	o.GetReplicatorVarz = opts.GetReplicatorVarz
+	o.GetStreamingChannelz = opts.GetStreamingChannelz
+	o.GetStreamingServerz = opts.GetStreamingServerz
+	o.GetStreamingClientsz = opts.GetStreamingClientsz
+	o.GetStreamingStorez = opts.GetStreamingStorez
+	o.GetSysEvents = opts.GetSysEvents
+	o.MetricsInclude = opts.MetricsInclude
+	o.MetricsExclude = opts.MetricsExclude
+	o.RelabelConfigs = opts.RelabelConfigs
+	o.MaxSeries, o.SeriesLimits = opts.MaxSeries, opts.SeriesLimits
+	o.LegacyPrefixNames = opts.LegacyPrefixNames
+
+	if recreate {
+		collector.Debugf("Servers or collector options changed, replacing all collectors")
+		client := o.HTTPClient
+		o.CollectorOptions = opts.CollectorOptions
+		o.RetryInterval = opts.RetryInterval
+		o.Prefix = opts.Prefix
+		o.UseInternalServerID = opts.UseInternalServerID
+		o.PollInterval = opts.PollInterval
+		o.PollIntervals = opts.PollIntervals
+		o.CacheTTL = opts.CacheTTL
+		o.MonitorCertFile = opts.MonitorCertFile
+		o.MonitorKeyFile = opts.MonitorKeyFile
+		o.MonitorCaFile = opts.MonitorCaFile
+		o.MonitorServerName = opts.MonitorServerName
+		o.MonitorTLSInsecure = opts.MonitorTLSInsecure
+		o.ProxyURL = opts.ProxyURL
+		o.ShardIndex, o.ShardCount = opts.ShardIndex, opts.ShardCount
+		ne.servers = servers
+
+		if err := ne.prepareCollectors(); err != nil {
+			*o, ne.servers = prev, prevServers
+			return err
+		}
+		ne.stopPolling()
+		ne.clearCollectors()
+		if client != nil && client != o.HTTPClient {
+			client.CloseIdleConnections()
+		}
+		ne.createCollectors()
+	} else {
+		ne.stopPolling()
+		selected := ne.collectedEndpoints()
+		keep := make(map[string]bool)
+		for _, e := range selected {
+			keep[e.key()] = true
+		}
+		for key := range ne.endpoints {
+			if !keep[key] {
+				ne.removeCollector(key)
+			}
+		}
+		for _, e := range selected {
+			if _, ok := ne.endpoints[e.key()]; !ok {
+				ne.createCollector(e.system, e.endpoint)
+			}
+		}
+	}
+	ne.filter, ne.relabelRules = filter, rules
+
+	if o.PollInterval > 0 {
+		ne.startPolling()
+	}
+	return nil
+}
+
+// handleReload reloads the configuration through the reload function of
+// the options.
+func (ne *NATSExporter) handleReload(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "Only POST requests reload the configuration", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := ne.opts.ReloadFunc(); err != nil {
+		collector.Errorf("Unable to reload the configuration: %v", err)
+		http.Error(rw, fmt.Sprintf("Unable to reload the configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// removeCollector unregisters and removes the collector of an endpoint.
+// caller must lock
+func (ne *NATSExporter) removeCollector(key string) {
+	c, ok := ne.endpoints[key]
+	if !ok {
+		return
+	}
+	collector.Debugf("Removing the collector for %s", key)
+	ne.registry.Unregister(c)
+	closeCollector(c)
+	delete(ne.endpoints, key)
+	for i, nc := range ne.collectors {
+		if nc == c {
+			ne.collectors = append(ne.collectors[:i], ne.collectors[i+1:]...)
+			break
+		}
+	}
+}
+
+// closeCollector releases what a collector holds beyond its registration,
+// e.g. the subscriptions of the events collector.
+func closeCollector(c prometheus.Collector) {
+	if cc, ok := c.(*cachedCollector); ok {
+		c = cc.collector
+	}
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close() // nolint
+	}
+}
+
+// sameServers reports whether two lists of servers are the same.
+func sameServers(a, b []*collector.CollectedServer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].URL != b[i].URL || a[i].Cluster != b[i].Cluster ||
+			!reflect.DeepEqual(a[i].Headers, b[i].Headers) ||
+			!reflect.DeepEqual(a[i].Labels, b[i].Labels) ||
+			!reflect.DeepEqual(a[i].Collectors, b[i].Collectors) {
+			return false
+		}
+	}
+	return true
+}
+
+// collectorSettings returns the options affecting how the collectors
+// poll, leaving out the endpoints selected, the options applied without
+// replacing the collectors, those that cannot be reloaded and those
+// derived when the collectors are created.
+func collectorSettings(opts *NATSExporterOptions) NATSExporterOptions {
+	o := *opts
+	copyFixedOptions(&o, &NATSExporterOptions{})
+	o.GetConnz, o.GetVarz, o.GetSubz, o.GetRoutez = false, false, false, false
+	o.GetGatewayz, o.GetJsz, o.GetIpqueuesz, o.GetReplicatorVarz = false, false, false, false
+	o.GetStreamingChannelz, o.GetStreamingServerz = false, false
+	o.GetStreamingClientsz, o.GetStreamingStorez = false, false
+	o.GetSysEvents = false
+	o.HTTPClient, o.TLSConfig, o.Proxy = nil, nil, nil
+	o.OnPoll, o.OnResponse, o.OnPollStatus = nil, nil, nil
+	o.MetricsInclude, o.MetricsExclude = "", ""
+	o.RelabelConfigs = nil
+	o.MaxSeries, o.SeriesLimits = 0, nil
+	o.LegacyPrefixNames = false
+	o.ReloadFunc = nil
+	return o
+}
+
+// copyFixedOptions copies the options that cannot be reloaded, those of
+// the listeners, the endpoints they serve, logging, scrape authentication,
+// exposition and pushes.
+func copyFixedOptions(dst, src *NATSExporterOptions) {
+	dst.LoggerOptions = src.LoggerOptions
+	dst.ListenAddress, dst.ListenPort, dst.ListenSocket = src.ListenAddress, src.ListenPort, src.ListenSocket
+	dst.AdminListenAddress, dst.ScrapePath = src.AdminListenAddress, src.ScrapePath
+	dst.CertFile, dst.KeyFile, dst.CaFile = src.CertFile, src.KeyFile, src.CaFile
+	dst.TLSVerifyClient, dst.CRLFile = src.TLSVerifyClient, src.CRLFile
+	dst.HTTPUser, dst.HTTPPassword, dst.HTTPUsers = src.HTTPUser, src.HTTPPassword, src.HTTPUsers
+	dst.HTTPBearerToken, dst.HTTPBearerTokenFile = src.HTTPBearerToken, src.HTTPBearerTokenFile
+	dst.NATSServerURL, dst.NATSServerTag = src.NATSServerURL, src.NATSServerTag
+	dst.DiscoveryInterval = src.DiscoveryInterval
+	dst.AdminAPI, dst.Probe, dst.Pprof = src.AdminAPI, src.Probe, src.Pprof
+	dst.DebugRaw, dst.AccessLog = src.DebugRaw, src.AccessLog
+	dst.ReloadAPI, dst.LogLevelAPI, dst.StatusAPI = src.ReloadAPI, src.LogLevelAPI, src.StatusAPI
+	dst.MaxRequestsInFlight, dst.RequestsInFlightWait = src.MaxRequestsInFlight, src.RequestsInFlightWait
+	dst.DisableOpenMetrics, dst.OpenMetricsCreated = src.DisableOpenMetrics, src.OpenMetricsCreated
+	dst.PushGatewayURL, dst.PushGatewayJob = src.PushGatewayURL, src.PushGatewayJob
+	dst.PushGatewayInstance, dst.PushGatewayInterval = src.PushGatewayInstance, src.PushGatewayInterval
+	dst.OTLPEndpoint, dst.OTLPInterval, dst.OTLPTracesEndpoint = src.OTLPEndpoint, src.OTLPInterval, src.OTLPTracesEndpoint
+	dst.StatsdAddress, dst.StatsdPrefix = src.StatsdAddress, src.StatsdPrefix
+	dst.StatsdTags, dst.StatsdInterval = src.StatsdTags, src.StatsdInterval
+	dst.GraphiteAddress, dst.GraphitePrefix, dst.GraphiteInterval = src.GraphiteAddress, src.GraphitePrefix, src.GraphiteInterval
+	dst.InfluxURL, dst.InfluxOrg, dst.InfluxBucket = src.InfluxURL, src.InfluxOrg, src.InfluxBucket
+	dst.InfluxToken, dst.InfluxInterval = src.InfluxToken, src.InfluxInterval
+	dst.PublishURL, dst.PublishSubject = src.PublishURL, src.PublishSubject
+	dst.PublishFormat, dst.PublishInterval = src.PublishFormat, src.PublishInterval
+	dst.SysURL, dst.SysCredsFile = src.SysURL, src.SysCredsFile
+	dst.Version, dst.Commit = src.Version, src.Commit
+}
+
+// changedFixedOption returns the name of the first option that cannot be
+// reloaded that differs between two sets of options, if any.
+func changedFixedOption(a, b *NATSExporterOptions) string {
+	var fa, fb NATSExporterOptions
+	copyFixedOptions(&fa, a)
+	copyFixedOptions(&fb, b)
+	// Configuring the logger always turns Logtime on.
+	fa.Logtime, fb.Logtime = true, true
+	va, vb := reflect.ValueOf(fa), reflect.ValueOf(fb)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			return va.Type().Field(i).Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/prometheus/common/model"
+)
+
+// sdPath is the path of the Prometheus HTTP service discovery of the
+// servers polled.
+const sdPath = "/sd"
+
+// Meta labels of the servers listed by the HTTP service discovery.
+const (
+	sdMetaPrefix        = model.MetaLabelPrefix + "nats_"
+	sdServerIDLabel     = sdMetaPrefix + "server_id"
+	sdServerURLLabel    = sdMetaPrefix + "server_url"
+	sdSchemeLabel       = sdMetaPrefix + "scheme"
+	sdClusterLabel      = sdMetaPrefix + "cluster"
+	sdLabelLabelsPrefix = sdMetaPrefix + "label_"
+)
+
+// sdTargetGroup is a target group of the Prometheus HTTP service
+// discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// sdTargetGroups returns a target group for the monitor port of each
+// server, labeled with its id, URL, cluster and labels.
+func sdTargetGroups(servers []*collector.CollectedServer) []sdTargetGroup {
+	groups := make([]sdTargetGroup, 0, len(servers))
+	for _, s := range servers {
+		u, err := url.Parse(s.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		labels := map[string]string{
+			sdServerIDLabel:  s.ID,
+			sdServerURLLabel: redactURL(s.URL),
+			sdSchemeLabel:    u.Scheme,
+		}
+		if s.Cluster != "" {
+			labels[sdClusterLabel] = s.Cluster
+		}
+		for name, value := range s.Labels {
+			labels[sdLabelLabelsPrefix+name] = value
+		}
+		groups = append(groups, sdTargetGroup{Targets: []string{u.Host}, Labels: labels})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Labels[sdServerIDLabel] < groups[j].Labels[sdServerIDLabel]
+	})
+	return groups
+}
+
+// handleSD lists the servers polled, configured or discovered, in the
+// format of the Prometheus HTTP service discovery.
+func (ne *NATSExporter) handleSD(rw http.ResponseWriter, r *http.Request) {
+	ne.Lock()
+	groups := sdTargetGroups(ne.allServers())
+	ne.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(groups); err != nil {
+		collector.Debugf("Unable to write the targets: %v", err)
+	}
+}
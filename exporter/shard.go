@@ -0,0 +1,68 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// ParseShard parses a shard given as i/n, the i-th of n shards counting
+// from 0.
+func ParseShard(s string) (index, count int, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid shard %q, expected i/n", s)
+	}
+	if index, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("invalid shard %q, expected i/n", s)
+	}
+	if count, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("invalid shard %q, expected i/n", s)
+	}
+	return index, count, checkShard(index, count)
+}
+
+// checkShard checks the shard is one of count shards, if sharded.
+func checkShard(index, count int) error {
+	if count == 0 && index == 0 {
+		return nil
+	}
+	if count < 1 || index < 0 || index >= count {
+		return fmt.Errorf("invalid shard %d/%d", index, count)
+	}
+	return nil
+}
+
+// shardServers returns the servers of the shard, each server assigned to
+// one of count shards by the hash of its ID, so that replicas given the
+// same servers split them between them without overlap.
+func shardServers(servers []*collector.CollectedServer, index, count int) []*collector.CollectedServer {
+	if count <= 1 {
+		return servers
+	}
+	shard := make([]*collector.CollectedServer, 0, len(servers)/count+1)
+	for _, s := range servers {
+		h := fnv.New32a()
+		h.Write([]byte(s.ID)) // nolint
+		if int(h.Sum32()%uint32(count)) == index {
+			shard = append(shard, s)
+		}
+	}
+	return shard
+}
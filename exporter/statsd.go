@@ -0,0 +1,223 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultStatsdInterval is how often the metrics are sent to StatsD.
+var DefaultStatsdInterval = 10 * time.Second
+
+// statsdMaxPacket is the largest datagram sent, fitting an Ethernet MTU.
+const statsdMaxPacket = 1432
+
+// counterDeltas tracks the last value sent of counters, which StatsD
+// expects as increments.
+type counterDeltas struct {
+	sync.Mutex
+	last map[string]float64
+}
+
+func newCounterDeltas() *counterDeltas {
+	return &counterDeltas{last: make(map[string]float64)}
+}
+
+// delta returns the increment of a counter since its last value, and false
+// when first seen.  A counter seen reset is incremented by its value.
+func (cd *counterDeltas) delta(key string, value float64) (float64, bool) {
+	cd.Lock()
+	defer cd.Unlock()
+	last, ok := cd.last[key]
+	cd.last[key] = value
+	if !ok {
+		return 0, false
+	}
+	if value < last {
+		return value, true
+	}
+	return value - last, true
+}
+
+// prune forgets the counters not seen in the last push.
+func (cd *counterDeltas) prune(seen map[string]bool) {
+	cd.Lock()
+	defer cd.Unlock()
+	for key := range cd.last {
+		if !seen[key] {
+			delete(cd.last, key)
+		}
+	}
+}
+
+// statsdLines converts the metric families to StatsD lines: gauges, and
+// counters sent as their increments since the last push.  Labels are sent
+// as DogStatsD tags if tags is set, and added to the dotted name
+// otherwise.
+func statsdLines(mfs []*dto.MetricFamily, prefix string, tags bool, deltas *counterDeltas) []string {
+	var lines []string
+	seen := make(map[string]bool)
+	line := func(name string, labels []*dto.LabelPair, value float64, typ string) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		name, suffix := statsdName(prefix, name, labels, tags)
+		v := strconv.FormatFloat(value, 'f', -1, 64)
+		// A signed gauge value is a change rather than the value, so
+		// negative values are sent after resetting the gauge.
+		if typ == "g" && value < 0 {
+			lines = append(lines, name+":0|g"+suffix)
+		}
+		lines = append(lines, name+":"+v+"|"+typ+suffix)
+	}
+	counter := func(name string, labels []*dto.LabelPair, value float64) {
+		key := seriesKey(name, labels)
+		seen[key] = true
+		if d, ok := deltas.delta(key, value); ok {
+			line(name, labels, d, "c")
+		}
+	}
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				counter(name, m.Label, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				line(name, m.Label, m.GetGauge().GetValue(), "g")
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					labels := append(m.Label[:len(m.Label):len(m.Label)], labelPair("quantile", formatOpenMetricsFloat(q.GetQuantile())))
+					line(name, labels, q.GetValue(), "g")
+				}
+				counter(name+"_sum", m.Label, s.GetSampleSum())
+				counter(name+"_count", m.Label, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					labels := append(m.Label[:len(m.Label):len(m.Label)], labelPair("le", formatOpenMetricsFloat(b.GetUpperBound())))
+					counter(name+"_bucket", labels, float64(b.GetCumulativeCount()))
+				}
+				counter(name+"_sum", m.Label, h.GetSampleSum())
+				counter(name+"_count", m.Label, float64(h.GetSampleCount()))
+			default:
+				line(name, m.Label, m.GetUntyped().GetValue(), "g")
+			}
+		}
+	}
+	deltas.prune(seen)
+	return lines
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// statsdName returns the name of a StatsD metric, with the labels in its
+// name or in the DogStatsD tags suffix.
+func statsdName(prefix, name string, labels []*dto.LabelPair, tags bool) (string, string) {
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+	if len(labels) == 0 {
+		return statsdSanitize(name, true), ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		if tags {
+			pairs = append(pairs, statsdSanitize(lp.GetName(), false)+":"+statsdSanitize(lp.GetValue(), false))
+		} else {
+			pairs = append(pairs, statsdSanitize(lp.GetName(), false)+"."+statsdSanitize(lp.GetValue(), false))
+		}
+	}
+	sort.Strings(pairs)
+	if tags {
+		return statsdSanitize(name, true), "|#" + strings.Join(pairs, ",")
+	}
+	return statsdSanitize(name, true) + "." + strings.Join(pairs, "."), ""
+}
+
+// statsdSanitize replaces the characters StatsD reserves, and dots unless
+// allowed.
+func statsdSanitize(s string, dots bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r == '.' && dots:
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// pushStatsd sends the metrics a scrape would be served to StatsD over
+// UDP, packing as many lines in each datagram as fit.
+func (ne *NATSExporter) pushStatsd() error {
+	ne.Lock()
+	addr := ne.opts.StatsdAddress
+	prefix := ne.opts.StatsdPrefix
+	tags := ne.opts.StatsdTags
+	timeout := ne.opts.CollectTimeout
+	ne.Unlock()
+
+	ctx, cancel := pushContext(timeout)
+	defer cancel()
+	mfs, err := ne.scrapeGatherer(ctx).Gather()
+	if err != nil {
+		return err
+	}
+	lines := statsdLines(mfs, prefix, tags, ne.statsdDeltas)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet []byte
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+	for _, l := range lines {
+		if len(packet) > 0 && len(packet)+1+len(l) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return fmt.Errorf("unable to send to %s: %v", addr, err)
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, l...)
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("unable to send to %s: %v", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// statusPath is the path of the API listing the last poll of each server
+// at each endpoint.
+const statusPath = "/api/status"
+
+// pollStatus is the last poll of a server at an endpoint, as listed by
+// the status API.
+type pollStatus struct {
+	ServerID   string    `json:"server_id"`
+	Endpoint   string    `json:"endpoint"`
+	LastPoll   time.Time `json:"last_poll"`
+	Duration   float64   `json:"duration_seconds"`
+	StatusCode int       `json:"http_status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// pollStatuses holds the last poll of each server at each endpoint.
+type pollStatuses struct {
+	sync.Mutex
+	last map[string]pollStatus
+}
+
+// record records a poll of a server.
+func (ps *pollStatuses) record(status collector.PollStatus) {
+	s := pollStatus{
+		ServerID:   status.ServerID,
+		Endpoint:   status.Endpoint,
+		LastPoll:   status.Time,
+		Duration:   status.Duration.Seconds(),
+		StatusCode: status.StatusCode,
+	}
+	if status.Err != nil {
+		s.Error = status.Err.Error()
+	}
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.last == nil {
+		ps.last = make(map[string]pollStatus)
+	}
+	ps.last[s.ServerID+"/"+s.Endpoint] = s
+}
+
+// list returns the last polls of the servers, by server and endpoint.
+func (ps *pollStatuses) list(servers map[string]bool) []pollStatus {
+	ps.Lock()
+	statuses := make([]pollStatus, 0, len(ps.last))
+	for _, s := range ps.last {
+		if servers[s.ServerID] {
+			statuses = append(statuses, s)
+		}
+	}
+	ps.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].ServerID != statuses[j].ServerID {
+			return statuses[i].ServerID < statuses[j].ServerID
+		}
+		return statuses[i].Endpoint < statuses[j].Endpoint
+	})
+	return statuses
+}
+
+// handleStatus lists the last poll of each server still polled at each
+// endpoint, only those of the server and endpoint given by the server_id
+// and endpoint parameters, if any.
+func (ne *NATSExporter) handleStatus(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	serverID, endpoint := q.Get("server_id"), q.Get("endpoint")
+
+	ne.Lock()
+	servers := make(map[string]bool)
+	for _, s := range ne.allServers() {
+		if serverID == "" || s.ID == serverID {
+			servers[s.ID] = true
+		}
+	}
+	ne.Unlock()
+
+	statuses := ne.statuses.list(servers)
+	if endpoint != "" {
+		filtered := statuses[:0]
+		for _, s := range statuses {
+			if s.Endpoint == endpoint {
+				filtered = append(filtered, s)
+			}
+		}
+		statuses = filtered
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(statuses); err != nil {
+		collector.Debugf("Unable to write the poll statuses: %v", err)
+	}
+}
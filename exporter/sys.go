@@ -0,0 +1,60 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"sync"
+
+	nats "github.com/nats-io/go-nats"
+)
+
+// sysConn holds the connection to the system account the servers are
+// requested their metrics on, opened on the first collection and kept
+// until the exporter is stopped.
+type sysConn struct {
+	sync.Mutex
+	url       string
+	credsFile string
+	nc        *nats.Conn
+}
+
+// conn returns the connection to the system account, connecting if
+// needed.
+func (s *sysConn) conn() (*nats.Conn, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.nc != nil && !s.nc.IsClosed() {
+		return s.nc, nil
+	}
+	options := []nats.Option{nats.Name("prometheus-nats-exporter"), nats.MaxReconnects(-1)}
+	if s.credsFile != "" {
+		options = append(options, nats.UserCredentials(s.credsFile))
+	}
+	nc, err := nats.Connect(s.url, options...)
+	if err != nil {
+		return nil, err
+	}
+	s.nc = nc
+	return nc, nil
+}
+
+// close closes the connection, if open.
+func (s *sysConn) close() {
+	s.Lock()
+	defer s.Unlock()
+	if s.nc != nil {
+		s.nc.Close()
+		s.nc = nil
+	}
+}
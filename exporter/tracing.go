@@ -0,0 +1,183 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// otlpTracesPath is the path of the OTLP/HTTP traces service.
+const otlpTracesPath = "/v1/traces"
+
+// maxQueuedTraces bounds the traces waiting to be exported.  The traces of
+// further scrapes are dropped.
+const maxQueuedTraces = 64
+
+// otlpExportTimeout bounds exporting a trace.
+const otlpExportTimeout = 10 * time.Second
+
+// OTLP span kinds and status codes.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// The OTLP traces request in its JSON encoding.
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code"`
+}
+
+// otlpTracesURL returns the URL of the traces service of an OTLP/HTTP
+// endpoint, given with or without the service path.
+func otlpTracesURL(endpoint string) (string, error) {
+	return otlpServiceURL(endpoint, otlpTracesPath)
+}
+
+// otlpSpansRequest converts the spans of a trace to an OTLP request.
+func otlpSpansRequest(spans []*collector.Span, version string) *otlpTracesRequest {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		root := s.ParentSpanID == [8]byte{}
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: otlpTime(s.Start),
+			EndTimeUnixNano:   otlpTime(s.End),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if !root {
+			span.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		// Spans of HTTP requests are those of the scrapes served, or of
+		// the requests to the servers.
+		if _, ok := s.Attributes["http.method"]; ok {
+			span.Kind = otlpSpanKindClient
+			if root {
+				span.Kind = otlpSpanKindServer
+			}
+		}
+		keys := make([]string, 0, len(s.Attributes))
+		for k := range s.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: s.Attributes[k]}})
+		}
+		if s.Err != nil {
+			span.Status = otlpStatus{Message: s.Err.Error(), Code: otlpStatusError}
+		}
+		converted = append(converted, span)
+	}
+
+	return &otlpTracesRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpServiceResource(version),
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "prometheus-nats-exporter", Version: version},
+			Spans: converted,
+		}},
+	}}}
+}
+
+// startTrace starts the trace of a scrape or poll, exported once its root
+// span ends.  It returns a nil span, recording nothing, unless the traces
+// are exported.
+func (ne *NATSExporter) startTrace(ctx context.Context, name string) (context.Context, *collector.Span) {
+	ne.Lock()
+	queue := ne.traces
+	ne.Unlock()
+	if queue == nil {
+		return ctx, nil
+	}
+	return collector.StartTrace(ctx, name, func(spans []*collector.Span) {
+		select {
+		case queue <- spans:
+		default:
+			collector.Debugf("Dropping the trace of a %s, too many traces waiting to be exported", name)
+		}
+	})
+}
+
+// startTracing exports the traces queued to the OpenTelemetry Collector
+// until the exporter is stopped.
+// caller must lock
+func (ne *NATSExporter) startTracing() {
+	u, _ := otlpTracesURL(ne.opts.OTLPTracesEndpoint)
+	version := ne.opts.Version
+	queue := make(chan []*collector.Span, maxQueuedTraces)
+	quit := make(chan struct{})
+	ne.traces, ne.tracesQuit = queue, quit
+	collector.Noticef("Exporting the traces of the scrapes to %s", redactURL(u))
+	go func() {
+		for {
+			select {
+			case spans := <-queue:
+				ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+				if err := postOTLP(ctx, u, otlpSpansRequest(spans, version)); err != nil {
+					collector.Errorf("Unable to export a trace to the OpenTelemetry Collector: %v", err)
+				}
+				cancel()
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// stopTracing stops exporting the traces, if exporting.
+// caller must lock
+func (ne *NATSExporter) stopTracing() {
+	if ne.tracesQuit != nil {
+		close(ne.tracesQuit)
+		ne.traces, ne.tracesQuit = nil, nil
+	}
+}
@@ -14,20 +14,30 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nats-io/prometheus-nats-exporter/collector"
+	"github.com/nats-io/prometheus-nats-exporter/discovery"
 	"github.com/nats-io/prometheus-nats-exporter/exporter"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var version = "0.6.2"
+// Build metadata, set by the release build with -ldflags -X.
+var (
+	version = "0.6.2"
+	commit  = "unknown"
+	date    = "unknown"
+)
 
 // parseServerIDAndURL parses the url argument the optional id for the server ID.
 func parseServerIDAndURL(urlArg string) (string, string, error) {
@@ -74,8 +84,9 @@ func updateOptions(debugAndTrace, useSysLog bool, opts *exporter.NATSExporterOpt
 	}
 
 	metricsSpecified := opts.GetConnz || opts.GetVarz || opts.GetSubz ||
-		opts.GetRoutez || opts.GetGatewayz || opts.GetStreamingChannelz ||
-		opts.GetStreamingServerz || opts.GetReplicatorVarz
+		opts.GetRoutez || opts.GetGatewayz || opts.GetJsz || opts.GetIpqueuesz || opts.GetStreamingChannelz ||
+		opts.GetStreamingServerz || opts.GetStreamingClientsz || opts.GetStreamingStorez ||
+		opts.GetReplicatorVarz || opts.GetSysEvents
 	if !metricsSpecified {
 		// No logger setup yet, so use fmt
 		fmt.Printf("No metrics specified.  Defaulting to varz.\n")
@@ -83,62 +94,600 @@ func updateOptions(debugAndTrace, useSysLog bool, opts *exporter.NATSExporterOpt
 	}
 }
 
-func main() {
+// options are the options of the exporter and the servers it polls.
+type options struct {
+	exporter     *exporter.NATSExporterOptions
+	servers      []configServer
+	printVersion bool
+	dryRun       bool
+
+	// shutdownTimeout bounds waiting for the scrapes in flight on exit.
+	shutdownTimeout time.Duration
+
+	// Leave out the metrics of the exporter's Go runtime and process.
+	disableGoMetrics      bool
+	disableProcessMetrics bool
+
+	// Kubernetes discovery of the servers.
+	k8sSelector  string
+	k8sNamespace string
+	k8sPort      int
+
+	// DNS discovery of the servers.
+	dnsName string
+	dnsType string
+	dnsPort int
+
+	// Consul discovery of the servers.
+	consulAddr    string
+	consulService string
+	consulTag     string
+	consulPassing bool
+	consulPort    int
+
+	// File discovery of the servers.
+	fileSD string
+
+	// Discovery of the server beside the exporter, e.g. as a sidecar.
+	local      bool
+	localPorts []int
+
+	// Discovery of the cluster peers of the servers.
+	discoverPeers bool
+	peersPort     int
+
+	// Election of the replica polling the servers.
+	leaseName      string
+	leaseNamespace string
+	leaseDuration  time.Duration
+}
+
+// discovers reports whether any discovery of the servers is configured.
+func (o *options) discovers() bool {
+	return o.k8sSelector != "" || o.dnsName != "" || o.consulService != "" || o.fileSD != "" || o.local
+}
+
+// parseOptions parses the command line arguments into fs, then sets the
+// flags not given from the environment and the configuration file.
+func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
 	var useSysLog bool
 	var debugAndTrace bool
 	var retryInterval int
-	var printVersion bool
+	var configFile string
+	var collect string
+	var shard string
+	var localPorts string
+	var accounts string
+	var connzAccounts string
 
-	opts := exporter.GetDefaultExporterOptions()
+	o := &options{exporter: exporter.GetDefaultExporterOptions()}
+	opts := o.exporter
+	opts.Version, opts.Commit = version, commit
 
 	// Parse flags
-	flag.BoolVar(&printVersion, "version", false, "Show exporter version and exit.")
-	flag.IntVar(&opts.ListenPort, "port", exporter.DefaultListenPort, "Port to listen on.")
-	flag.IntVar(&opts.ListenPort, "p", exporter.DefaultListenPort, "Port to listen on.")
-	flag.StringVar(&opts.ListenAddress, "addr", exporter.DefaultListenAddress, "Network host to listen on.")
-	flag.StringVar(&opts.ListenAddress, "a", exporter.DefaultListenAddress, "Network host to listen on.")
-	flag.StringVar(&opts.ScrapePath, "path", exporter.DefaultScrapePath, "URL path from which to serve scrapes.")
-	flag.IntVar(&retryInterval, "ri", exporter.DefaultRetryIntervalSecs,
+	fs.BoolVar(&o.printVersion, "version", false, "Show exporter version and exit.")
+	fs.BoolVar(&o.dryRun, "dry_run", false, "Poll the servers once, print the metrics that would be served and exit.")
+	fs.BoolVar(&o.disableGoMetrics, "disable_go_metrics", false,
+		"Do not serve the go_ metrics of the exporter's Go runtime.")
+	fs.BoolVar(&o.disableProcessMetrics, "disable_process_metrics", false,
+		"Do not serve the process_ metrics of the exporter's process.")
+	fs.DurationVar(&o.shutdownTimeout, "shutdown_timeout", exporter.DefaultShutdownTimeout,
+		"How long to wait for the scrapes in flight to complete on exit.")
+	fs.StringVar(&configFile, "config", "", "Configuration file, whose keys are flag names, overridden by flags.")
+	fs.IntVar(&opts.ListenPort, "port", exporter.DefaultListenPort, "Port to listen on.")
+	fs.IntVar(&opts.ListenPort, "p", exporter.DefaultListenPort, "Port to listen on.")
+	fs.StringVar(&opts.ListenAddress, "addr", exporter.DefaultListenAddress, "Network host to listen on.")
+	fs.StringVar(&opts.ListenAddress, "a", exporter.DefaultListenAddress, "Network host to listen on.")
+	fs.StringVar(&opts.ListenSocket, "listen_socket", "", "Unix domain socket to listen on instead of addr and port.")
+	fs.StringVar(&opts.AdminListenAddress, "admin_addr", "",
+		"host:port serving the health, pprof, reload and admin API endpoints instead of the scrape listener.")
+	fs.StringVar(&opts.ScrapePath, "path", exporter.DefaultScrapePath, "URL path from which to serve scrapes.")
+	fs.BoolVar(&opts.DisableOpenMetrics, "disable_openmetrics", false,
+		"Always serve the Prometheus text format, even to scrapers accepting OpenMetrics.")
+	fs.BoolVar(&opts.OpenMetricsCreated, "openmetrics_created", false,
+		"Serve the _created samples of counters, the time they were first scraped or last reset, in OpenMetrics.")
+	fs.IntVar(&retryInterval, "ri", exporter.DefaultRetryIntervalSecs,
 		"Interval in seconds to retry NATS Server monitor URL.")
-	flag.StringVar(&opts.LogFile, "l", "", "Log file name.")
-	flag.StringVar(&opts.LogFile, "log", "", "Log file name.")
-	flag.BoolVar(&useSysLog, "s", false, "Write log statements to the syslog.")
-	flag.BoolVar(&useSysLog, "syslog", false, "Write log statements to the syslog.")
-	flag.StringVar(&opts.RemoteSyslog, "r", "", "Remote syslog address to write log statements.")
-	flag.StringVar(&opts.RemoteSyslog, "remote_syslog", "", "Write log statements to a remote syslog.")
-	flag.BoolVar(&opts.Debug, "D", false, "Enable debug log level.")
-	flag.BoolVar(&opts.Trace, "V", false, "Enable trace log level.")
-	flag.BoolVar(&debugAndTrace, "DV", false, "Enable debug and trace log levels.")
-	flag.BoolVar(&opts.GetConnz, "connz", false, "Get connection metrics.")
-	flag.BoolVar(&opts.GetReplicatorVarz, "replicatorVarz", false, "Get replicator general metrics.")
-	flag.BoolVar(&opts.GetGatewayz, "gatewayz", false, "Get gateway metrics.")
-	flag.BoolVar(&opts.GetRoutez, "routez", false, "Get route metrics.")
-	flag.BoolVar(&opts.GetSubz, "subz", false, "Get subscription metrics.")
-	flag.BoolVar(&opts.GetStreamingChannelz, "channelz", false, "Get streaming channel metrics.")
-	flag.BoolVar(&opts.GetStreamingServerz, "serverz", false, "Get streaming server metrics.")
-	flag.BoolVar(&opts.GetVarz, "varz", false, "Get general metrics.")
-	flag.StringVar(&opts.CertFile, "tlscert", "", "Server certificate file (Enables HTTPS).")
-	flag.StringVar(&opts.KeyFile, "tlskey", "", "Private key for server certificate (used with HTTPS).")
-	flag.StringVar(&opts.CaFile, "tlscacert", "", "Client certificate CA for verification (used with HTTPS).")
-	flag.StringVar(&opts.HTTPUser, "http_user", "", "Enable basic auth and set user name for HTTP scrapes.")
-	flag.StringVar(&opts.HTTPPassword, "http_pass", "", "Set the password for HTTP scrapes. NATS bcrypt supported.")
-	flag.StringVar(&opts.Prefix, "prefix", "", "Replace the default prefix for all the metrics.")
-	flag.BoolVar(&opts.UseInternalServerID, "use_internal_server_id", false, "Enables using ServerID from /varz")
-	flag.Parse()
+	fs.BoolVar(&opts.AccessLog, "access_log", false,
+		"Log every request to the exporter, with the client address, path, status, size, duration and user agent.")
+	fs.StringVar(&opts.LogFile, "l", "", "Log file name.")
+	fs.StringVar(&opts.LogFile, "log", "", "Log file name.")
+	fs.StringVar(&opts.LogFormat, "log_format", collector.TextLogFormat, "Format of the console and file logs, text or json.")
+	fs.Int64Var(&opts.LogSizeLimit, "log_size_limit", 0, "Rotate the log file once it would exceed this size in bytes (0 is no limit).")
+	fs.DurationVar(&opts.LogMaxAge, "log_max_age", 0, "Rotate the log file once it is this old (0 is no limit).")
+	fs.IntVar(&opts.LogMaxFiles, "log_max_files", 0, "Number of rotated log files kept (0 keeps them all).")
+	fs.BoolVar(&useSysLog, "s", false, "Write log statements to the syslog.")
+	fs.BoolVar(&useSysLog, "syslog", false, "Write log statements to the syslog.")
+	fs.StringVar(&opts.RemoteSyslog, "r", "", "Remote syslog address to write log statements.")
+	fs.StringVar(&opts.RemoteSyslog, "remote_syslog", "", "Write log statements to a remote syslog.")
+	fs.StringVar(&opts.SyslogFacility, "syslog_facility", collector.DefaultSyslogFacility,
+		"Facility of the log statements written to the syslog, e.g. daemon or local0.")
+	fs.BoolVar(&opts.Debug, "D", false, "Enable debug log level.")
+	fs.BoolVar(&opts.Trace, "V", false, "Enable trace log level.")
+	fs.BoolVar(&debugAndTrace, "DV", false, "Enable debug and trace log levels.")
+	fs.BoolVar(&opts.GetConnz, "connz", false, "Get connection metrics.")
+	fs.BoolVar(&opts.GetReplicatorVarz, "replicatorVarz", false, "Get replicator general metrics.")
+	fs.BoolVar(&opts.GetGatewayz, "gatewayz", false, "Get gateway metrics.")
+	fs.BoolVar(&opts.GetJsz, "jsz", false, "Get JetStream metrics.")
+	fs.BoolVar(&opts.GetIpqueuesz, "ipqueuesz", false, "Get internal queue metrics.")
+	fs.BoolVar(&opts.GetRoutez, "routez", false, "Get route metrics.")
+	fs.BoolVar(&opts.GetSubz, "subz", false, "Get subscription metrics.")
+	fs.BoolVar(&opts.GetStreamingChannelz, "channelz", false, "Get streaming channel metrics.")
+	fs.BoolVar(&opts.GetStreamingServerz, "serverz", false, "Get streaming server metrics.")
+	fs.BoolVar(&opts.GetStreamingClientsz, "clientz", false, "Get streaming client metrics.")
+	fs.BoolVar(&opts.GetStreamingStorez, "storez", false, "Get streaming store limit metrics.")
+	fs.BoolVar(&opts.GetVarz, "varz", false, "Get general metrics.")
+	fs.StringVar(&collect, "collect", "",
+		"Comma-separated list of the collectors to enable, e.g. varz,connz,subz, along with their flags.")
+	fs.StringVar(&opts.CertFile, "tlscert", "", "Server certificate file (Enables HTTPS).")
+	fs.StringVar(&opts.KeyFile, "tlskey", "", "Private key for server certificate (used with HTTPS).")
+	fs.StringVar(&opts.CaFile, "tlscacert", "", "Client certificate CA for verification (used with HTTPS).")
+	fs.BoolVar(&opts.TLSVerifyClient, "tlsverify", false,
+		"Require scrapers to present a client certificate signed by tlscacert (used with HTTPS).")
+	fs.StringVar(&opts.CRLFile, "tlscrl", "", "Revocation list of client certificates rejected (used with tlsverify).")
+	fs.StringVar(&opts.HTTPUser, "http_user", "", "Enable basic auth and set user name for HTTP scrapes.")
+	fs.BoolVar(&opts.AdminAPI, "admin_api", false,
+		"Serve the API adding and removing servers at /api/targets (requires http_user or a bearer token).")
+	fs.BoolVar(&opts.ReloadAPI, "reload_api", false,
+		"Serve POST /-/reload, reloading the configuration (requires http_user or a bearer token).")
+	fs.BoolVar(&opts.LogLevelAPI, "log_level_api", false,
+		"Serve /admin/loglevel, getting and changing the log level (requires http_user or a bearer token).")
+	fs.BoolVar(&opts.StatusAPI, "status_api", false,
+		"Serve the last poll of each server at /api/status (requires http_user or a bearer token).")
+	fs.BoolVar(&opts.Probe, "probe", false,
+		"Serve /probe, polling the server given by the target parameter of each request (requires http_user or a bearer token).")
+	fs.BoolVar(&opts.Pprof, "pprof", false,
+		"Serve the Go runtime profiles at /debug/pprof (requires http_user or a bearer token).")
+	fs.BoolVar(&opts.DebugRaw, "debug_raw", false,
+		"Serve the last response of each server to each endpoint at /debug/raw/<server_id>/<endpoint>, requires an http user or bearer token.")
+	fs.StringVar(&opts.HTTPPassword, "http_pass", "", "Set the password for HTTP scrapes. NATS bcrypt supported.")
+	fs.StringVar(&opts.HTTPBearerToken, "http_bearer_token", "",
+		"Enable bearer token auth and set the token of HTTP scrapes.")
+	fs.StringVar(&opts.HTTPBearerTokenFile, "http_bearer_token_file", "",
+		"Enable bearer token auth with the token of HTTP scrapes held in this file, read on every scrape.")
+	fs.StringVar(&opts.PushGatewayURL, "pushgateway_url", "",
+		"Push the metrics to this Pushgateway, e.g. for servers Prometheus cannot reach (not reloaded).")
+	fs.StringVar(&opts.PushGatewayJob, "pushgateway_job", exporter.DefaultPushGatewayJob,
+		"Job label of the metrics pushed.")
+	fs.StringVar(&opts.PushGatewayInstance, "pushgateway_instance", "",
+		"Instance label of the metrics pushed (defaults to the host name).")
+	fs.DurationVar(&opts.PushGatewayInterval, "pushgateway_interval", exporter.DefaultPushGatewayInterval,
+		"Interval to push the metrics to the Pushgateway.")
+	fs.StringVar(&opts.OTLPEndpoint, "otlp_endpoint", "",
+		"Push the metrics to the OpenTelemetry Collector at this OTLP/HTTP endpoint, e.g. http://localhost:4318 (not reloaded).")
+	fs.DurationVar(&opts.OTLPInterval, "otlp_interval", exporter.DefaultOTLPInterval,
+		"Interval to push the metrics to the OpenTelemetry Collector.")
+	fs.StringVar(&opts.OTLPTracesEndpoint, "otlp_traces_endpoint", "",
+		"Export the traces of the scrapes to the OpenTelemetry Collector at this OTLP/HTTP endpoint (not reloaded).")
+	fs.StringVar(&opts.StatsdAddress, "statsd_addr", "",
+		"Send the metrics to the StatsD server at this host:port over UDP (not reloaded).")
+	fs.StringVar(&opts.StatsdPrefix, "statsd_prefix", "", "Prefix of the metrics sent to StatsD.")
+	fs.BoolVar(&opts.StatsdTags, "statsd_tags", false,
+		"Send the labels of the metrics as DogStatsD tags rather than in their names.")
+	fs.DurationVar(&opts.StatsdInterval, "statsd_interval", exporter.DefaultStatsdInterval,
+		"Interval to send the metrics to StatsD.")
+	fs.StringVar(&opts.GraphiteAddress, "graphite_addr", "",
+		"Send the metrics to the carbon plaintext endpoint at this host:port (not reloaded).")
+	fs.StringVar(&opts.GraphitePrefix, "graphite_prefix", exporter.DefaultGraphitePrefix,
+		"Prefix of the metrics sent to Graphite, if any.")
+	fs.DurationVar(&opts.GraphiteInterval, "graphite_interval", exporter.DefaultGraphiteInterval,
+		"Interval to send the metrics to Graphite.")
+	fs.StringVar(&opts.InfluxURL, "influx_url", "",
+		"Write the metrics to the InfluxDB v2 server at this URL (not reloaded).")
+	fs.StringVar(&opts.InfluxOrg, "influx_org", "", "Organization of the InfluxDB bucket.")
+	fs.StringVar(&opts.InfluxBucket, "influx_bucket", "", "InfluxDB bucket the metrics are written to.")
+	fs.StringVar(&opts.InfluxToken, "influx_token", "", "API token authorizing the writes to InfluxDB.")
+	fs.DurationVar(&opts.InfluxInterval, "influx_interval", exporter.DefaultInfluxInterval,
+		"Interval to write the metrics to InfluxDB.")
+	fs.StringVar(&opts.PublishURL, "publish_url", "",
+		"Publish the metrics to the NATS server at this URL (not reloaded).")
+	fs.StringVar(&opts.PublishSubject, "publish_subject", exporter.DefaultPublishSubject,
+		"Subject the metrics are published on.")
+	fs.StringVar(&opts.PublishFormat, "publish_format", exporter.DefaultPublishFormat,
+		"Format of the metrics published, json or protobuf.")
+	fs.DurationVar(&opts.PublishInterval, "publish_interval", exporter.DefaultPublishInterval,
+		"Interval to publish the metrics.")
+	fs.StringVar(&opts.SysURL, "sys_url", "",
+		"Request statsz, varz and connz from the servers through the system account of the NATS server at this URL (not reloaded).")
+	fs.StringVar(&opts.SysCredsFile, "sys_creds", "", "Credentials file of the system account user (not reloaded).")
+	fs.BoolVar(&opts.GetSysEvents, "sys_events", false,
+		"Count the connect, disconnect and auth error advisories of the system account of sys_url.")
+	fs.StringVar(&opts.Prefix, "prefix", "", "Replace the default prefix for all the metrics.")
+	fs.BoolVar(&opts.LegacyPrefixNames, "legacy_prefix_names", false,
+		"With prefix, also serve the metrics under their default prefix, e.g. gnatsd, while migrating.")
+	fs.BoolVar(&opts.UseInternalServerID, "use_internal_server_id", false,
+		"Use the server_id the servers report in /varz, looked up in the background.")
+	fs.BoolVar(&opts.UseServerURLLabel, "use_server_url_label", false,
+		"Use the host:port of the monitor URL as the server_id, stable across restarts.")
+	fs.BoolVar(&opts.UnitMetricNames, "unit_metric_names", false,
+		"Name metrics with a unit after their base unit, e.g. varz_mem_bytes, converting their values.")
+	fs.BoolVar(&opts.LegacyMetricNames, "legacy_metric_names", false,
+		"Also serve the former names of the metrics renamed by unit_metric_names.")
+	fs.IntVar(&opts.MaxRequestsInFlight, "max_requests_in_flight", 0,
+		"Maximum scrapes and probes served at a time, unlimited if zero.")
+	fs.DurationVar(&opts.RequestsInFlightWait, "requests_in_flight_wait", 0,
+		"How long scrapes over max_requests_in_flight wait to be served before being rejected.")
+	fs.IntVar(&opts.MaxConcurrentRequests, "max_concurrent_requests", collector.DefaultMaxConcurrentRequests,
+		"Maximum number of servers polled concurrently per endpoint.")
+	fs.DurationVar(&opts.CollectTimeout, "collect_timeout", collector.DefaultCollectTimeout,
+		"Maximum time to collect metrics when the scraper sets no timeout.")
+	fs.DurationVar(&opts.PollInterval, "poll_interval", 0,
+		"Poll servers on this interval and serve cached metrics (0 polls on each scrape).")
+	fs.DurationVar(&opts.PollSpread, "poll_spread", 0,
+		"Spread the polls of the servers over this time within the poll interval rather than polling them at once.")
+	fs.DurationVar(&opts.CacheTTL, "cache_ttl", 0,
+		"Maximum age of cached metrics served when polling (0 is three poll intervals).")
+	fs.IntVar(&opts.Retry.MaxAttempts, "retry_attempts", 0,
+		"Attempts made to poll a server per collection (0 is a single attempt).")
+	fs.DurationVar(&opts.Retry.Backoff, "retry_backoff", collector.DefaultRetryBackoff,
+		"Delay before retrying a request to a server, doubled on each retry.")
+	fs.DurationVar(&opts.Retry.MaxBackoff, "retry_max_backoff", collector.DefaultRetryMaxBackoff,
+		"Maximum delay between retries of a request to a server.")
+	fs.Float64Var(&opts.Retry.Jitter, "retry_jitter", collector.DefaultRetryJitter,
+		"Fraction of the retry delay that is randomized.")
+	fs.IntVar(&opts.BreakerThreshold, "breaker_threshold", 0,
+		"Consecutive failed polls after which a server is skipped (0 disables).")
+	fs.DurationVar(&opts.BreakerCooldown, "breaker_cooldown", collector.DefaultBreakerCooldown,
+		"Time a server is skipped once its failed poll threshold is reached.")
+	fs.Int64Var(&opts.MaxResponseBytes, "max_response_bytes", collector.DefaultMaxResponseBytes,
+		"Maximum size of a monitor response read from a server (0 is no limit).")
+	fs.StringVar(&accounts, "accounts", "",
+		"Comma-separated list of the only accounts whose metrics jsz, connz and sys_events collect.")
+	fs.StringVar(&connzAccounts, "connz_accounts", "",
+		"Comma-separated list of the accounts whose connections connz is also polled for, by account.")
+	fs.IntVar(&opts.MaxPages, "max_pages", collector.DefaultMaxPages,
+		"Maximum pages requested from connz and subsz in a poll.")
+	fs.BoolVar(&opts.DisableCompression, "disable_compression", false,
+		"Do not request gzip compressed responses from the monitor endpoints.")
+	fs.DurationVar(&opts.ConnectTimeout, "connect_timeout", collector.DefaultConnectTimeout,
+		"Timeout connecting to a monitor endpoint (0 is no limit).")
+	fs.DurationVar(&opts.TLSHandshakeTimeout, "tls_handshake_timeout", collector.DefaultTLSHandshakeTimeout,
+		"Timeout of the TLS handshake with a monitor endpoint (0 is no limit).")
+	fs.DurationVar(&opts.ResponseHeaderTimeout, "response_header_timeout", 0,
+		"Timeout waiting for the response headers from a monitor endpoint (0 is no limit).")
+	fs.DurationVar(&opts.RequestTimeout, "request_timeout", 0,
+		"Timeout of a whole request to a monitor endpoint (0 is no limit).")
+	fs.BoolVar(&opts.DisableKeepAlives, "disable_keepalives", false,
+		"Open a new connection for every request to a monitor endpoint.")
+	fs.IntVar(&opts.MaxIdleConnsPerHost, "max_idle_conns_per_host", collector.DefaultMaxIdleConnsPerHost,
+		"Maximum idle connections kept open to each monitor endpoint.")
+	fs.DurationVar(&opts.IdleConnTimeout, "idle_conn_timeout", collector.DefaultIdleConnTimeout,
+		"Time an idle connection to a monitor endpoint is kept open (0 is no limit).")
+	fs.StringVar(&opts.MonitorCertFile, "monitor_tlscert", "", "Client certificate file for HTTPS monitor endpoints.")
+	fs.StringVar(&opts.MonitorKeyFile, "monitor_tlskey", "", "Private key for the monitor client certificate.")
+	fs.StringVar(&opts.MonitorCaFile, "monitor_tlscacert", "", "CA certificate to verify HTTPS monitor endpoints.")
+	fs.StringVar(&opts.MonitorServerName, "monitor_tls_server_name", "",
+		"Server name expected in the certificate of HTTPS monitor endpoints.")
+	fs.BoolVar(&opts.MonitorTLSInsecure, "monitor_tls_insecure", false,
+		"Skip verifying the certificate of HTTPS monitor endpoints.")
+	fs.StringVar(&opts.MonitorUser, "monitor_user", "",
+		"Basic auth user name for monitor endpoints without credentials in their URL.")
+	fs.StringVar(&opts.MonitorPassword, "monitor_pass", "", "Basic auth password for monitor endpoints.")
+	fs.StringVar(&opts.BearerTokenFile, "bearer_token_file", "",
+		"File holding a bearer token sent to monitor endpoints, read on every request.")
+	fs.StringVar(&opts.ProxyURL, "proxy_url", "",
+		"HTTP or SOCKS5 proxy to poll the servers through (defaults to HTTP_PROXY/HTTPS_PROXY).")
+	fs.DurationVar(&opts.DiscoveryInterval, "discovery_interval", exporter.DefaultDiscoveryInterval,
+		"Interval to discover the servers again (not reloaded).")
+	fs.StringVar(&o.consulAddr, "consul_addr", discovery.DefaultConsulAddress, "Address of the Consul HTTP API.")
+	fs.StringVar(&o.consulService, "consul_service", "",
+		"Discover the instances of this service in Consul (not reloaded).")
+	fs.StringVar(&o.consulTag, "consul_tag", "", "Only discover the Consul service instances with this tag.")
+	fs.BoolVar(&o.consulPassing, "consul_passing", true,
+		"Only discover the Consul service instances passing their health checks.")
+	fs.IntVar(&o.consulPort, "consul_monitor_port", discovery.DefaultMonitorPort,
+		"Monitor port of the Consul service instances without nats_monitor_port metadata.")
+	fs.BoolVar(&o.discoverPeers, "discover_peers", false,
+		"Discover the cluster peers of the servers from their /routez (not reloaded).")
+	fs.StringVar(&o.dnsName, "dns_name", "",
+		"Discover the servers behind this DNS name, resolved again on each discovery (not reloaded).")
+	fs.StringVar(&o.dnsType, "dns_type", discovery.DNSTypeA, "DNS record type looked up, A or SRV.")
+	fs.IntVar(&o.dnsPort, "dns_monitor_port", discovery.DefaultMonitorPort,
+		"Monitor port of the servers found in A records.")
+	fs.StringVar(&o.fileSD, "file_sd", "",
+		"Discover the servers listed in this JSON target file, reread when it changes (not reloaded).")
+	fs.BoolVar(&o.local, "local", false,
+		"Poll the NATS server on localhost, e.g. as its sidecar, waiting for its monitor port to come up (not reloaded).")
+	fs.StringVar(&localPorts, "local_ports", "",
+		"Comma-separated monitor ports probed on localhost before the default 8222 with -local.")
+	fs.StringVar(&o.k8sSelector, "k8s_label_selector", "",
+		"Discover the NATS pods matching this label selector in Kubernetes (not reloaded).")
+	fs.StringVar(&o.k8sNamespace, "k8s_namespace", "",
+		"Namespace of the NATS pods (defaults to the namespace of the exporter).")
+	fs.IntVar(&o.k8sPort, "k8s_monitor_port", discovery.DefaultMonitorPort, "Monitor port of the NATS pods.")
+	fs.StringVar(&shard, "shard", "",
+		"Poll only the shard i/n of the servers, given or discovered, split by the hash of their ID between n replicas.")
+	fs.StringVar(&o.leaseName, "leader_election_lease", "",
+		"Poll the servers only while holding this Kubernetes Lease, shared by the replicas of the exporter (not reloaded).")
+	fs.StringVar(&o.leaseNamespace, "leader_election_namespace", "",
+		"Namespace of the lease (defaults to the namespace of the exporter).")
+	fs.DurationVar(&o.leaseDuration, "leader_election_duration", discovery.DefaultLeaseDuration,
+		"Time the leader holds the lease without renewing it.")
+	fs.IntVar(&o.peersPort, "peers_monitor_port", 0,
+		"Monitor port of the discovered cluster peers (0 is the port of the server they are discovered from).")
+	fs.StringVar(&opts.MetricsInclude, "metrics_include", "",
+		"Only serve the metrics whose names match this regular expression.")
+	fs.StringVar(&opts.MetricsExclude, "metrics_exclude", "",
+		"Do not serve the metrics whose names match this regular expression.")
+	fs.IntVar(&opts.MaxSeries, "max_series", 0,
+		"Maximum series served of each gauge, the others summed into a series labeled \"other\" (0 is no limit).")
+	fs.StringVar(&opts.ServerNameLabel, "server_name_label", "",
+		"Label metrics with the server_name from /varz: \"add\" a server_name label, or \"replace\" the server_id.")
+	fs.DurationVar(&opts.ServerNameRefresh, "server_name_refresh", collector.DefaultServerNameRefresh,
+		"Interval to look up the server_name of the servers again.")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
+	if err := loadEnv(fs); err != nil {
+		return nil, err
+	}
+	if configFile != "" {
+		fc, err := loadConfigFile(fs, configFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load config file %q: %v", configFile, err)
+		}
+		o.servers = fc.servers
+		opts.MetricRenames = fc.metricNames
+		opts.RelabelConfigs = fc.relabelConfigs
+		opts.EndpointParams = fc.endpointParams
+		opts.HTTPUsers = fc.basicAuthUsers
+		opts.SeriesLimits = fc.seriesLimits
+		opts.PollIntervals = fc.pollIntervals
+		opts.SubjectAggregations = fc.subjectAggs
+	}
+
+	if err := exporter.SetCollectors(opts, collect); err != nil {
+		return nil, err
+	}
+	var err error
+	if shard != "" {
+		if opts.ShardIndex, opts.ShardCount, err = exporter.ParseShard(shard); err != nil {
+			return nil, err
+		}
+	}
+	if o.localPorts, err = parseLocalPorts(localPorts); err != nil {
+		return nil, err
+	}
+	opts.Accounts = splitList(accounts)
+	opts.ConnzAccounts = splitList(connzAccounts)
 	opts.RetryInterval = time.Duration(retryInterval) * time.Second
 
-	if printVersion {
-		fmt.Println("prometheus-nats-exporter version", version)
+	// Servers given as arguments replace those of the configuration file.
+	if fs.NArg() > 0 {
+		o.servers = nil
+		for _, arg := range fs.Args() {
+			id, url, err := parseServerIDAndURL(arg)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse URL %q: %v", arg, err)
+			}
+			o.servers = append(o.servers, configServer{id: id, url: url})
+		}
+	}
+
+	updateOptions(debugAndTrace, useSysLog, opts)
+	if err := collector.CheckLoggerOptions(&opts.LoggerOptions); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// splitList splits a comma-separated list, leaving out empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseLocalPorts parses the comma-separated ports probed by -local,
+// followed by the default monitor port.
+func parseLocalPorts(s string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, p := range append(strings.Split(s, ","), strconv.Itoa(discovery.DefaultMonitorPort)) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid local port %q", p)
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// shiftLogLevel raises the log level by delta levels, lowering it if
+// negative, within the levels there are, and returns the new level.
+func shiftLogLevel(delta int) string {
+	i := 0
+	for j, level := range collector.LogLevels {
+		if level == collector.LogLevel() {
+			i = j
+		}
+	}
+	i += delta
+	if i < 0 {
+		i = 0
+	} else if i >= len(collector.LogLevels) {
+		i = len(collector.LogLevels) - 1
+	}
+	level := collector.LogLevels[i]
+	collector.SetLogLevel(level)
+	return level
+}
+
+// unregisterRuntimeCollectors removes the collectors of the Go runtime and
+// process metrics disabled from the default registry.
+func unregisterRuntimeCollectors(o *options) {
+	if o.disableGoMetrics {
+		prometheus.Unregister(prometheus.NewGoCollector())
+	}
+	if o.disableProcessMetrics {
+		prometheus.Unregister(prometheus.NewProcessCollector(os.Getpid(), ""))
+	}
+}
+
+// collectedServers returns the servers to poll.  With
+// -use_internal_server_id the servers keep the ID given to them until the
+// exporter looks up the ID they report in the background.
+func collectedServers(o *options) []*collector.CollectedServer {
+	servers := make([]*collector.CollectedServer, 0, len(o.servers))
+	for _, s := range o.servers {
+		servers = append(servers, &collector.CollectedServer{
+			ID:      s.id,
+			URL:     s.url,
+			Headers: s.headers,
+			Cluster: s.cluster,
+			Labels:  s.labels,
+		})
+	}
+	return servers
+}
+
+// printVersion prints the version of the exporter and how it was built.
+func printVersion() {
+	fmt.Println("prometheus-nats-exporter version", version)
+	fmt.Println("  commit:    ", commit)
+	fmt.Println("  build date:", date)
+	fmt.Println("  go version:", runtime.Version())
+	fmt.Println("  platform:  ", runtime.GOOS+"/"+runtime.GOARCH)
+}
+
+// checkDNSType checks t is a DNS record type the servers can be
+// discovered from.
+func checkDNSType(t string) error {
+	switch strings.ToUpper(t) {
+	case discovery.DNSTypeA, discovery.DNSTypeSRV:
+		return nil
+	}
+	return fmt.Errorf("invalid DNS record type %q", t)
+}
+
+// addDiscoverers adds the configured discoverers of servers to the
+// exporter.
+func addDiscoverers(exp *exporter.NATSExporter, o *options, servers []*collector.CollectedServer) error {
+	if o.discoverPeers {
+		opts, err := exp.PollOptions()
+		if err != nil {
+			return err
+		}
+		for _, s := range servers {
+			p := &discovery.Peers{Seed: s, MonitorPort: o.peersPort, Options: opts}
+			if err := exp.AddDiscoverer(p); err != nil {
+				return err
+			}
+		}
+	}
+	if o.k8sSelector != "" {
+		k, err := discovery.NewKubernetes(o.k8sNamespace, o.k8sSelector, o.k8sPort)
+		if err != nil {
+			return fmt.Errorf("unable to discover servers in Kubernetes: %v", err)
+		}
+		if err := exp.AddDiscoverer(k); err != nil {
+			return err
+		}
+	}
+	if o.dnsName != "" {
+		if err := checkDNSType(o.dnsType); err != nil {
+			return err
+		}
+		d := &discovery.DNS{Name: o.dnsName, Type: o.dnsType, MonitorPort: o.dnsPort}
+		if err := exp.AddDiscoverer(d); err != nil {
+			return err
+		}
+	}
+	if o.consulService != "" {
+		c := &discovery.Consul{
+			Address:     o.consulAddr,
+			Service:     o.consulService,
+			Tag:         o.consulTag,
+			Token:       os.Getenv("CONSUL_HTTP_TOKEN"),
+			Passing:     o.consulPassing,
+			MonitorPort: o.consulPort,
+		}
+		if err := exp.AddDiscoverer(c); err != nil {
+			return err
+		}
+	}
+	if o.fileSD != "" {
+		if err := exp.AddDiscoverer(&discovery.File{Path: o.fileSD}); err != nil {
+			return err
+		}
+	}
+	if o.local {
+		if err := exp.AddDiscoverer(&discovery.Local{Ports: o.localPorts}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reload parses the options again, e.g. after the configuration file
+// changed, and applies them to the running exporter.
+func reload(exp *exporter.NATSExporter) error {
+	collector.Noticef("Reloading the configuration")
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	o, err := parseOptions(fs, os.Args[1:])
+	if err != nil {
+		return err
+	}
+	return exp.Reload(o.exporter, collectedServers(o))
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		if len(os.Args) != 3 {
+			fmt.Printf("Usage:  %s check-config <file>\n", os.Args[0])
+			os.Exit(2)
+		}
+		if err := checkConfig(os.Args[2]); err != nil {
+			fmt.Printf("%s: %v\n", os.Args[2], err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", os.Args[2])
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen-dashboard" {
+		if err := genDashboard(os.Args[2:], os.Stdout); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen-alerts" {
+		if err := genAlerts(os.Args[2:], os.Stdout); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
-	args := flag.Args()
-	if len(args) < 1 {
+	o, err := parseOptions(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	opts := o.exporter
+
+	if o.printVersion {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if len(o.servers) < 1 && !o.discovers() && !opts.AdminAPI && !opts.Probe && opts.SysURL == "" {
 		fmt.Printf("Usage:  %s <flags> url\n\n", os.Args[0])
 		flag.Usage()
 		return
-	} else if len(args) > 1 {
+	} else if len(o.servers) > 1 && !o.dryRun {
 		fmt.Println(
 			`WARNING:  While permitted by this exporter, monitoring more than one server
 violates Prometheus guidelines and best practices.  Each Prometheus NATS
@@ -147,31 +696,40 @@ beside it on the same machine.  Aggregate multiple servers only when
 necessary.`)
 	}
 
-	updateOptions(debugAndTrace, useSysLog, opts)
+	// Create an instance of the NATS exporter, whose configuration can be
+	// reloaded through its admin endpoints.
+	var exp *exporter.NATSExporter
+	opts.ReloadFunc = func() error { return reload(exp) }
+	exp = exporter.NewExporter(opts)
+	unregisterRuntimeCollectors(o)
+	prometheus.MustRegister(collector.NewBuildInfoCollector(version, commit))
 
-	// Create an instance of the NATS exporter.
-	exp := exporter.NewExporter(opts)
+	servers := collectedServers(o)
+	// For each URL specified, add the NATS server with the optional ID.
+	for _, s := range servers {
+		if err := exp.AddCollectedServer(s); err != nil {
+			collector.Fatalf("Unable to setup server in exporter: %s, %s: %v",
+				s.ID, s.URL, err)
+		}
+	}
+	if err := addDiscoverers(exp, o, servers); err != nil {
+		collector.Fatalf("%v", err)
+	}
 
-	if len(args) == 1 && opts.UseInternalServerID {
-		// Pick the server id from the /varz endpoint info.
-		url := flag.Args()[0]
-		id := collector.GetServerIDFromVarz(url, opts.RetryInterval)
-		if err := exp.AddServer(id, url); err != nil {
-			collector.Fatalf("Unable to setup server in exporter: %s, %s: %v", id, url, err)
+	if o.dryRun {
+		if err := exp.DryRun(os.Stdout); err != nil {
+			collector.Fatalf("%v", err)
 		}
-	} else {
-		// For each URL specified, add the NATS server with the optional ID.
-		for _, arg := range args {
-			// This should make the http request to get the server id
-			// that is returned from /varz.
-			id, url, err := parseServerIDAndURL(arg)
-			if err != nil {
-				collector.Fatalf("Unable to parse URL %q: %v", arg, err)
-			}
-			if err := exp.AddServer(id, url); err != nil {
-				collector.Fatalf("Unable to setup server in exporter: %s, %s: %v",
-					id, url, err)
-			}
+		return
+	}
+
+	if o.leaseName != "" {
+		l, err := discovery.NewKubernetesLease(o.leaseNamespace, o.leaseName, o.leaseDuration)
+		if err != nil {
+			collector.Fatalf("Unable to elect the leader in Kubernetes: %v", err)
+		}
+		if err := exp.SetElector(l); err != nil {
+			collector.Fatalf("%v", err)
 		}
 	}
 
@@ -180,12 +738,30 @@ necessary.`)
 		collector.Fatalf("error starting the exporter: %v\n", err)
 	}
 
-	// Setup the interrupt handler to gracefully exit.
+	// Reload the configuration on SIGHUP.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload(exp); err != nil {
+				collector.Errorf("Unable to reload the configuration: %v", err)
+			}
+		}
+	}()
+
+	handleLogLevelSignals()
+
+	// Setup the interrupt handler to gracefully exit, completing the
+	// scrapes in flight.
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		exp.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+		defer cancel()
+		if err := exp.Shutdown(ctx); err != nil {
+			collector.Noticef("Scrapes in flight did not complete before exiting: %v", err)
+		}
 		os.Exit(0)
 	}()
 
@@ -0,0 +1,41 @@
+// Copyright 2019 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/prometheus-nats-exporter/collector"
+)
+
+// handleLogLevelSignals raises the log level on SIGUSR1 and lowers it on
+// SIGUSR2.
+func handleLogLevelSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range c {
+			delta := 1
+			if sig == syscall.SIGUSR2 {
+				delta = -1
+			}
+			collector.Noticef("Log level changed to %s", shiftLogLevel(delta))
+		}
+	}()
+}